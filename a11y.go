@@ -0,0 +1,146 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// checkHTMLAccessibility runs a few basic accessibility checks over the
+// document (or fragment) and returns found violations.
+func checkHTMLAccessibility(doc []byte) []string {
+	var violations []string
+	var hasHTML, hasLang bool
+	var lastHeading int
+	var inLink bool
+	var linkText string
+	ids := make(map[string]struct{})
+
+	z := html.NewTokenizer(bytes.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				violations = append(violations, fmt.Sprintf("malformed html: %v", z.Err()))
+			}
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs := make(map[string]string, len(tok.Attr))
+			for _, a := range tok.Attr {
+				attrs[a.Key] = a.Val
+			}
+			if id, ok := attrs["id"]; ok {
+				if _, dup := ids[id]; dup {
+					violations = append(violations, fmt.Sprintf("duplicated id %q", id))
+				}
+				ids[id] = struct{}{}
+			}
+			switch tok.Data {
+			case "html":
+				hasHTML = true
+				hasLang = attrs["lang"] != ""
+			case "img":
+				if _, ok := attrs["alt"]; !ok {
+					violations = append(violations, fmt.Sprintf("image %q has no alt attribute", attrs["src"]))
+				}
+			case "a":
+				inLink, linkText = true, ""
+				if attrs["aria-label"] != "" {
+					linkText = attrs["aria-label"]
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				level, _ := strconv.Atoi(tok.Data[1:])
+				if lastHeading != 0 && level > lastHeading+1 {
+					violations = append(violations, fmt.Sprintf("heading level skipped: h%d after h%d", level, lastHeading))
+				}
+				lastHeading = level
+			}
+		case html.TextToken:
+			if inLink {
+				linkText += tok.Data
+			}
+		case html.EndTagToken:
+			if tok.Data == "a" && inLink {
+				if strings.TrimSpace(linkText) == "" {
+					violations = append(violations, "link has no text")
+				}
+				inLink = false
+			}
+		}
+	}
+	if hasHTML && !hasLang {
+		violations = append(violations, "document has no lang attribute")
+	}
+	return violations
+}
+
+// accessibleIndex returns an initial catalog index document with
+// landmarks and skip links enabled by the given options.
+func accessibleIndex(cfg *targetConfig) []byte {
+	list := fmt.Sprintf("<ul>%s</ul>", cfg.IndexPlaceholder)
+	if cfg.Lang == "" && !cfg.SkipLinks && !cfg.ARIALandmarks {
+		return []byte(list)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n")
+	if cfg.Lang != "" {
+		fmt.Fprintf(&buf, "<html lang=%q>\n", cfg.Lang)
+	} else {
+		buf.WriteString("<html>\n")
+	}
+	fmt.Fprintf(&buf, "<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n", html.EscapeString(cfg.Catalog))
+	if cfg.SkipLinks {
+		buf.WriteString("<a class=\"skip-link\" href=\"#content\">Skip to content</a>\n")
+	}
+	if cfg.ARIALandmarks {
+		fmt.Fprintf(&buf, "<main id=\"content\" role=\"main\">\n<nav aria-label=%q>%s</nav>\n</main>\n", cfg.Catalog, list)
+	} else {
+		fmt.Fprintf(&buf, "<div id=\"content\">%s</div>\n", list)
+	}
+	buf.WriteString("</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+// rowImages returns the images the row is published with: the image or photo
+// column and the image files of the files columns.
+func rowImages(row map[string]string) []string {
+	var images []string
+	if image := rowImage(row); image != "" {
+		images = append(images, image)
+	}
+	for _, file := range rowFiles(row) {
+		if telegramMediaType(file) == "photo" {
+			images = append(images, file)
+		}
+	}
+	return images
+}
+
+// checkRowAltText returns the validation error of the row published with
+// images but no alt text.
+func checkRowAltText(row map[string]string) error {
+	if images := rowImages(row); len(images) != 0 && strings.TrimSpace(row["alt"]) == "" {
+		return fmt.Errorf("%w: no alt text for image %s", errValidation, images[0])
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRowAltText(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     map[string]string
+		wantErr bool
+	}{
+		{"no images", map[string]string{"title": "t"}, false},
+		{"image with alt", map[string]string{"image": "a.jpg", "alt": "a cat"}, false},
+		{"image without alt", map[string]string{"image": "a.jpg"}, true},
+		{"blank alt", map[string]string{"image": "a.jpg", "alt": " "}, true},
+		{"photo without alt", map[string]string{"photo": "a.png"}, true},
+		{"image file without alt", map[string]string{"files": "doc.pdf, b.jpeg"}, true},
+		{"numbered image file without alt", map[string]string{"files_1": "b.webp"}, true},
+		{"non-image files", map[string]string{"files": "doc.pdf, a.mp3"}, false},
+		{"image file with alt", map[string]string{"files": "b.jpg", "alt": "b"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRowAltText(tt.row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errValidation) {
+				t.Errorf("error %v is not a validation error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API token roles. Admin tokens may do everything trigger tokens can.
+const (
+	apiRoleTrigger = "trigger"
+	apiRoleAdmin   = "admin"
+)
+
+const defaultAuditLogFile = "audit.log"
+
+// apiToken is an authenticated API client.
+type apiToken struct {
+	name  string
+	token string
+	role  string
+}
+
+// allows checks whether the token role grants the required role.
+func (t *apiToken) allows(role string) bool {
+	return t.role == apiRoleAdmin || t.role == role
+}
+
+// apiServer serves the HTTP API of the daemon mode.
+type apiServer struct {
+	cfg     *config
+	tokens  []*apiToken
+	actions *botActions
+	audit   *auditLog
+	mux     *http.ServeMux
+}
+
+func newAPIServer(cfg *config, actions *botActions) (*apiServer, error) {
+	s := &apiServer{cfg: cfg, actions: actions, mux: http.NewServeMux()}
+	for i, tcfg := range cfg.APITokens {
+		token := tcfg.Token
+		if tcfg.TokenFile != "" {
+			var err error
+			if token, err = readSecretFile(tcfg.TokenFile); err != nil {
+				return nil, fmt.Errorf("failed to read api token %s: %v", tcfg.Name, err)
+			}
+		}
+		if token == "" {
+			return nil, fmt.Errorf("invalid config: api token %d not set", i)
+		}
+		switch tcfg.Role {
+		case apiRoleTrigger, apiRoleAdmin:
+		default:
+			return nil, fmt.Errorf("invalid config: invalid api token %s role %s", tcfg.Name, tcfg.Role)
+		}
+		s.tokens = append(s.tokens, &apiToken{name: tcfg.Name, token: token, role: tcfg.Role})
+	}
+	if len(s.tokens) == 0 {
+		return nil, errors.New("invalid config: no api tokens")
+	}
+	s.audit = newAuditLog(cfg)
+	s.handle("/api/sync", http.MethodPost, apiRoleTrigger, s.handleSync)
+	s.handle("/api/run", http.MethodPost, apiRoleTrigger, s.handleRun)
+	s.handle("/api/status", http.MethodGet, apiRoleTrigger, s.handleStatus)
+	s.handle("/api/last-report", http.MethodGet, apiRoleTrigger, s.handleLastReport)
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.handle("/api/approve", http.MethodPost, apiRoleAdmin, s.handleApprove)
+	s.handle("/api/pending", http.MethodGet, apiRoleTrigger, s.handlePending)
+	s.handle("/api/history", http.MethodGet, apiRoleTrigger, s.handleHistory)
+	s.handle("/api/row", http.MethodGet, apiRoleTrigger, s.handleRow)
+	s.page("/", s.handleDashboard)
+	s.page("/runs/", s.handleDashboardRun)
+	return s, nil
+}
+
+// apiHandler handles an authorized request and returns the response value.
+type apiHandler func(r *http.Request, rec *auditRecord) (any, error)
+
+// apiError is an error with the response status code.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// handle registers the handler requiring the method and role.
+func (s *apiServer) handle(path, method, role string, h apiHandler) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			apiWriteError(w, &apiError{http.StatusMethodNotAllowed, "method not allowed"})
+			return
+		}
+		t := s.authenticate(r)
+		if t == nil {
+			apiWriteError(w, &apiError{http.StatusUnauthorized, "unauthorized"})
+			return
+		}
+		rec := &auditRecord{Time: time.Now(), Token: t.name, Role: t.role, Remote: r.RemoteAddr, Action: path}
+		if !t.allows(role) {
+			rec.Error = "forbidden"
+			s.audit.write(rec)
+			apiWriteError(w, &apiError{http.StatusForbidden, "forbidden"})
+			return
+		}
+		v, err := h(r, rec)
+		if err != nil {
+			rec.Error = redactSecrets(err.Error())
+		}
+		// only actions are audited, not reads
+		if method != http.MethodGet {
+			s.audit.write(rec)
+		}
+		if err != nil {
+			apiWriteError(w, err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, v)
+	})
+}
+
+// authenticate returns the token of the bearer authorization header or the
+// basic auth password.
+func (s *apiServer) authenticate(r *http.Request) *apiToken {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	if !ok || token == "" {
+		return nil
+	}
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.token), []byte(token)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *apiServer) handleSync(r *http.Request, rec *auditRecord) (any, error) {
+	results, err := s.actions.sync()
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]runTaskRecord, 0, len(results))
+	for _, res := range results {
+		resp = append(resp, newTaskRecord(res))
+	}
+	return resp, nil
+}
+
+// handleRun starts a run and returns without waiting for it, its result is
+// available with the status and the last report.
+func (s *apiServer) handleRun(r *http.Request, rec *auditRecord) (any, error) {
+	id, err := s.actions.start()
+	if err != nil {
+		return nil, err
+	}
+	rec.RunID = id
+	return map[string]string{"status": "started", "run_id": id}, nil
+}
+
+// apiStatus is the status response.
+type apiStatus struct {
+	Version string     `json:"version"`
+	Running bool       `json:"running"`
+	Started *time.Time `json:"started,omitempty"`
+	LastRun *apiRun    `json:"last_run,omitempty"`
+}
+
+type apiRun struct {
+	ID       string          `json:"id,omitempty"`
+	Finished time.Time       `json:"finished"`
+	Error    string          `json:"error,omitempty"`
+	Tasks    []runTaskRecord `json:"tasks,omitempty"`
+}
+
+func (s *apiServer) handleStatus(r *http.Request, rec *auditRecord) (any, error) {
+	st := s.actions.status
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	resp := apiStatus{Version: toolVersion(), Running: st.running}
+	if st.running {
+		started := st.started
+		resp.Started = &started
+	}
+	if !st.finished.IsZero() {
+		run := &apiRun{Finished: st.finished}
+		if st.lastErr != nil {
+			run.Error = redactSecrets(st.lastErr.Error())
+		}
+		if st.last != nil {
+			run.ID = st.last.ID
+			for _, t := range st.last.Tasks {
+				run.Tasks = append(run.Tasks, t.runTaskRecord)
+			}
+		}
+		resp.LastRun = run
+	}
+	return resp, nil
+}
+
+func (s *apiServer) handleLastReport(r *http.Request, rec *auditRecord) (any, error) {
+	st := s.actions.status
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.last == nil {
+		return nil, &apiError{http.StatusNotFound, "no run report"}
+	}
+	return st.last, nil
+}
+
+// handleHealth reports the process is serving, it requires no token.
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	apiWriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *apiServer) handleApprove(r *http.Request, rec *auditRecord) (any, error) {
+	name := r.FormValue("task")
+	row, err := strconv.Atoi(r.FormValue("row"))
+	if name == "" || err != nil {
+		return nil, &apiError{http.StatusBadRequest, "task and row required"}
+	}
+	rec.Args = []string{name, strconv.Itoa(row)}
+	if err = s.actions.approve(name, row); err != nil {
+		return nil, err
+	}
+	return map[string]string{"status": approvalApproved}, nil
+}
+
+// apiPending is the pending rows badge response.
+type apiPending struct {
+	Total  int               `json:"total"`
+	Tasks  map[string]int    `json:"tasks"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (s *apiServer) handlePending(r *http.Request, rec *auditRecord) (any, error) {
+	result, err := s.actions.pending()
+	if err != nil {
+		return nil, err
+	}
+	resp := apiPending{Tasks: make(map[string]int, len(result))}
+	for _, p := range result {
+		if p.err != nil {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Errors[p.name] = redactSecrets(p.err.Error())
+			continue
+		}
+		resp.Tasks[p.name] = len(p.rows)
+		resp.Total += len(p.rows)
+	}
+	return resp, nil
+}
+
+func (s *apiServer) handleHistory(r *http.Request, rec *auditRecord) (any, error) {
+	h, err := loadRunHistory(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return h.Runs, nil
+}
+
+// apiRow is the row history response.
+type apiRow struct {
+	Records map[string]string `json:"records"`
+	History []rowHistoryEntry `json:"history"`
+}
+
+func (s *apiServer) handleRow(r *http.Request, rec *auditRecord) (any, error) {
+	name := r.FormValue("task")
+	row, err := strconv.Atoi(r.FormValue("row"))
+	if name == "" || err != nil {
+		return nil, &apiError{http.StatusBadRequest, "task and row required"}
+	}
+	rec.Args = []string{name, strconv.Itoa(row)}
+	db, err := openHistoryDB(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.close()
+	var resp apiRow
+	if resp.Records, err = db.records(name, row); err != nil {
+		return nil, err
+	}
+	if resp.History, err = db.rowHistory(name, row); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func apiWriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write api response", "err", err)
+	}
+}
+
+func apiWriteError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var aerr *apiError
+	if errors.As(err, &aerr) {
+		status = aerr.status
+	} else if errors.Is(err, errRunInProgress) {
+		status = http.StatusConflict
+	}
+	apiWriteJSON(w, status, map[string]string{"error": redactSecrets(err.Error())})
+}
+
+// serveAPI serves the API on the address until the context is done.
+func serveAPI(ctx context.Context, cfg *config, actions *botActions) error {
+	s, err := newAPIServer(cfg, actions)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: cfg.APIListen, Handler: s.mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving api on %s\n", cfg.APIListen)
+	if err = srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// auditRecord is a line of the audit log. Actions of the API have the
+// token name and role set, actions of runs don't. RunID is the run started
+// by the API action or the run of the action.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Token  string    `json:"token,omitempty"`
+	Role   string    `json:"role,omitempty"`
+	Remote string    `json:"remote"`
+	Action string    `json:"action"`
+	RunID  string    `json:"run_id,omitempty"`
+	Args   []string  `json:"args,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// auditLogSchema versions each line of the audit log, lines written before
+// versioning was introduced are version 0.
+var auditLogSchema = &stateSchema{
+	name: "audit log",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+// auditLog appends records of API actions to the file as JSON lines.
+type auditLog struct {
+	mu   sync.Mutex
+	file string
+}
+
+func newAuditLog(cfg *config) *auditLog {
+	file := cfg.AuditLog
+	if file == "" {
+		file = filepath.Join(cfg.DataDir, defaultAuditLogFile)
+	}
+	return &auditLog{file: file}
+}
+
+func (a *auditLog) write(rec *auditRecord) {
+	if rec.Token != "" {
+		log.Printf("api %s by %s (%s)\n", rec.Action, rec.Token, rec.Role)
+	}
+	doc, err := auditLogSchema.document(rec)
+	if err != nil {
+		slog.Warn("failed to encode audit record", "err", err)
+		return
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		slog.Warn("failed to encode audit record", "err", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err = os.MkdirAll(filepath.Dir(a.file), dirPerm); err != nil {
+		slog.Warn("failed to create audit log dir", "err", err)
+		return
+	}
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		slog.Warn("failed to open audit log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		slog.Warn("failed to write audit log", "err", err)
+	}
+}
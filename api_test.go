@@ -0,0 +1,71 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	legacy := `{"time":"2024-01-02T03:04:05Z","token":"ci","role":"admin","remote":"127.0.0.1","action":"/sync"}` + "\n"
+	if err := os.WriteFile(file, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := newAuditLog(&config{AuditLog: file})
+	now := time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC)
+	a.write(&auditRecord{Time: now, Action: "publish", RunID: "20240102-030500-0001", Args: []string{"task", "1"}})
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if !bytes.Contains(lines[1], []byte(`"state_version":1`)) {
+		t.Errorf("record not versioned: %s", lines[1])
+	}
+	tests := []struct {
+		name string
+		line []byte
+		want auditRecord
+	}{
+		{"unversioned", lines[0], auditRecord{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Token: "ci", Role: "admin", Remote: "127.0.0.1", Action: "/sync"}},
+		{"current version", lines[1], auditRecord{Time: now, Action: "publish", RunID: "20240102-030500-0001", Args: []string{"task", "1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec auditRecord
+			if err := auditLogSchema.decode(tt.line, &rec); err != nil {
+				t.Fatal(err)
+			}
+			if !rec.Time.Equal(tt.want.Time) || rec.Token != tt.want.Token || rec.Action != tt.want.Action ||
+				rec.RunID != tt.want.RunID || len(rec.Args) != len(tt.want.Args) {
+				t.Errorf("got %+v, want %+v", rec, tt.want)
+			}
+		})
+	}
+
+	var rec auditRecord
+	if err = auditLogSchema.decode([]byte(`{"state_version":99,"action":"x"}`), &rec); err == nil {
+		t.Error("record of a newer version is decoded")
+	}
+}
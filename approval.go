@@ -0,0 +1,101 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"log/slog"
+	"strconv"
+)
+
+// Values of the approval column.
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+)
+
+const (
+	defaultApprovalColumn = "approved"
+	approveCallbackAction = "approve"
+)
+
+// approval sends new rows to the approvers chat and holds them until they
+// are approved with the inline button.
+type approval struct {
+	token  string
+	chat   string
+	column string
+}
+
+func newApproval(cfg *approvalConfig, token string) (*approval, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Chat == "" {
+		return nil, errors.New("invalid config: approval chat not set")
+	}
+	column := cfg.Column
+	if column == "" {
+		column = defaultApprovalColumn
+	}
+	return &approval{token: token, chat: cfg.Chat, column: column}, nil
+}
+
+// request sends row previews with the approve button to the approvers chat.
+func (a *approval) request(ctx context.Context, task *task, n int, row map[string]string, fs *drive.FilesService) error {
+	for _, t := range task.targets {
+		if p, ok := t.(previewer); ok && task.langs.routesRow(t.ID(), row) {
+			if err := p.Preview(ctx, row, fs, a.chat); err != nil {
+				return fmt.Errorf("failed to preview target %s: %v", t.ID(), err)
+			}
+		}
+	}
+	data, err := telegramCallbackData(approveCallbackAction, task.name, strconv.Itoa(n))
+	if err != nil {
+		return err
+	}
+	_, err = telegramSendMessageKeyboard(ctx, a.token, a.chat,
+		fmt.Sprintf("%s: row %d is waiting for approval", task.name, n),
+		[][]telegramButton{{{Text: "Approve", CallbackData: data}}},
+	)
+	return err
+}
+
+// approveCallback returns the handler of the approve button.
+func approveCallback(cfg *config, approve func(task string, row int) error) botCallbackHandler {
+	return func(ctx context.Context, cq *telegramCallbackQuery, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", errors.New("invalid arguments")
+		}
+		row, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid row number: %s", args[1])
+		}
+		slog.Info("approving row", "task", args[0], "row", row, "user", cq.From.Id)
+		if err = approve(args[0], row); err != nil {
+			return "", err
+		}
+		if cq.Message != nil {
+			if err = telegramEditMessageReplyMarkup(ctx, cfg.TelegramBotToken, strconv.Itoa(cq.Message.Chat.Id),
+				strconv.Itoa(cq.Message.MessageId), nil); err != nil {
+				slog.Warn("failed to approve row", "task", args[0], "row", row, "err", err)
+			}
+		}
+		return fmt.Sprintf("row %d approved", row), nil
+	}
+}
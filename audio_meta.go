@@ -0,0 +1,161 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// audioDuration reads the duration of the MP3 or MP4/M4A audio file.
+func audioDuration(file string) (time.Duration, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".mp3":
+		return mp3Duration(f)
+	case ".m4a", ".mp4", ".m4b", ".m4v", ".mov":
+		return mp4Duration(f)
+	default:
+		return 0, errors.New("unsupported audio format")
+	}
+}
+
+var (
+	mp3Bitrates1   = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mp3Bitrates2   = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	mp3SampleRates = [3]int{44100, 48000, 32000}
+)
+
+// mp3Duration reads the duration of the MPEG layer III stream from the
+// Xing/Info or VBRI header, CBR streams are estimated by the file size.
+func mp3Duration(f *os.File) (time.Duration, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var start int64
+	hdr := make([]byte, 10)
+	if _, err = io.ReadFull(f, hdr); err != nil {
+		return 0, err
+	}
+	if string(hdr[:3]) == "ID3" {
+		// the tag size is a syncsafe integer
+		start = int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9]) + 10
+		if hdr[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	buf := make([]byte, 8192)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version, layer := buf[i+1]>>3&3, buf[i+1]>>1&3
+		bitrateIdx, rateIdx := buf[i+2]>>4, buf[i+2]>>2&3
+		if version == 1 || layer != 1 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+			continue
+		}
+		mono := buf[i+3]>>6 == 3
+		// the Xing header follows the side information
+		rate, bitrate, spf, side := mp3SampleRates[rateIdx], mp3Bitrates1[bitrateIdx], 1152, 32
+		if mono {
+			side = 17
+		}
+		if version != 3 {
+			rate, bitrate, spf, side = rate/2, mp3Bitrates2[bitrateIdx], 576, 17
+			if mono {
+				side = 9
+			}
+			if version == 0 {
+				rate /= 2
+			}
+		}
+		frames := 0
+		if x := i + 4 + side; x+12 <= len(buf) && (string(buf[x:x+4]) == "Xing" || string(buf[x:x+4]) == "Info") {
+			if binary.BigEndian.Uint32(buf[x+4:x+8])&1 != 0 {
+				frames = int(binary.BigEndian.Uint32(buf[x+8 : x+12]))
+			}
+		} else if v := i + 4 + 32; v+18 <= len(buf) && string(buf[v:v+4]) == "VBRI" {
+			frames = int(binary.BigEndian.Uint32(buf[v+14 : v+18]))
+		}
+		if frames > 0 {
+			return time.Duration(frames) * time.Duration(spf) * time.Second / time.Duration(rate), nil
+		}
+		size := fi.Size() - start - int64(i)
+		return time.Duration(size*8) * time.Second / time.Duration(bitrate*1000), nil
+	}
+	return 0, errors.New("no mpeg audio frame found")
+}
+
+// mp4Duration reads the duration from the movie header box.
+func mp4Duration(r io.ReadSeeker) (time.Duration, error) {
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return 0, errors.New("no movie header found")
+			}
+			return 0, err
+		}
+		size, typ := int64(binary.BigEndian.Uint32(hdr[:4])), string(hdr[4:])
+		body := size - 8
+		if size == 1 {
+			if _, err := io.ReadFull(r, hdr); err != nil {
+				return 0, err
+			}
+			body = int64(binary.BigEndian.Uint64(hdr)) - 16
+		}
+		switch typ {
+		case "moov":
+			// descend into the container
+			continue
+		case "mvhd":
+			b := make([]byte, 32)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return 0, err
+			}
+			var scale, duration uint64
+			if b[0] == 1 {
+				scale, duration = uint64(binary.BigEndian.Uint32(b[20:24])), binary.BigEndian.Uint64(b[24:32])
+			} else {
+				scale, duration = uint64(binary.BigEndian.Uint32(b[12:16])), uint64(binary.BigEndian.Uint32(b[16:20]))
+			}
+			if scale == 0 {
+				return 0, errors.New("invalid movie header")
+			}
+			return time.Duration(duration) * time.Second / time.Duration(scale), nil
+		}
+		if size == 0 || body < 0 {
+			return 0, errors.New("no movie header found")
+		}
+		if _, err := r.Seek(body, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchOptions are the parameters of the synthetic benchmark run.
+type benchOptions struct {
+	rows        int
+	tasks       int
+	concurrency int
+	latency     time.Duration
+}
+
+// benchStats are the latencies of a measured operation.
+type benchStats struct {
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	Total time.Duration `json:"total"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	Max   time.Duration `json:"max"`
+}
+
+func newBenchStats(name string, ds []time.Duration) benchStats {
+	s := benchStats{Name: name, Count: len(ds)}
+	if len(ds) == 0 {
+		return s
+	}
+	ds = append([]time.Duration(nil), ds...)
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	for _, d := range ds {
+		s.Total += d
+	}
+	s.P50 = ds[(len(ds)-1)*50/100]
+	s.P95 = ds[(len(ds)-1)*95/100]
+	s.Max = ds[len(ds)-1]
+	return s
+}
+
+// benchResult is the outcome of the benchmark run. Phases are wall times of
+// the export steps, ops are latencies of single operations.
+type benchResult struct {
+	Rows          int           `json:"rows"`
+	Tasks         int           `json:"tasks"`
+	Concurrency   int           `json:"concurrency"`
+	Latency       time.Duration `json:"latency"`
+	Duration      time.Duration `json:"duration"`
+	RowsPerSecond float64       `json:"rows_per_second"`
+	Phases        []benchStats  `json:"phases"`
+	Ops           []benchStats  `json:"ops"`
+}
+
+func (r *benchResult) json() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *benchResult) print(w io.Writer) error {
+	fmt.Fprintf(w, "rows: %d, tasks: %d, concurrency: %d, latency: %v\n",
+		r.Rows, r.Tasks, r.Concurrency, r.Latency)
+	fmt.Fprintf(w, "duration: %v, %.1f rows/s\n\n", r.Duration.Round(time.Millisecond), r.RowsPerSecond)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tcount\ttotal\tp50\tp95\tmax\t")
+	for _, s := range append(r.Phases, r.Ops...) {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t\n", s.Name, s.Count,
+			s.Total.Round(time.Microsecond), s.P50.Round(time.Microsecond),
+			s.P95.Round(time.Microsecond), s.Max.Round(time.Microsecond))
+	}
+	return tw.Flush()
+}
+
+// benchTransport records latencies of outgoing requests by API.
+type benchTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	ops  map[string][]time.Duration
+}
+
+func (t *benchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := "http drive"
+	if strings.HasPrefix(req.URL.Path, "/bot") {
+		op = "http telegram"
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.mu.Lock()
+	t.ops[op] = append(t.ops[op], time.Since(start))
+	t.mu.Unlock()
+	return resp, err
+}
+
+// benchServer mocks the Drive, Sheets and Telegram APIs used by the export.
+type benchServer struct {
+	sheet   []byte
+	latency time.Duration
+	mu      sync.Mutex
+	message int
+}
+
+func (s *benchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.latency)
+	_, _ = io.Copy(io.Discard, r.Body)
+	switch p := r.URL.Path; {
+	case strings.HasPrefix(p, "/bot"):
+		s.mu.Lock()
+		s.message++
+		id := s.message
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d}}`, id)
+	case p == "/drive/v3/files":
+		fmt.Fprint(w, `{"files":[{"id":"bench","name":"bench"}]}`)
+	case strings.HasSuffix(p, "/export"):
+		w.Header().Set("Content-Type", exportMIME)
+		_, _ = w.Write(s.sheet)
+	case strings.HasSuffix(p, "/values:batchUpdate"):
+		fmt.Fprint(w, `{"spreadsheetId":"bench"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const benchTemplate = "<b>{{.title}}</b>\n\n{{.text}}\n"
+
+// benchSheet returns the xlsx workbook with the synthetic rows.
+func benchSheet(rows int) ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	header := []any{"title", "text", "telegram_bench_status", "telegram_bench_record_id"}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return nil, err
+	}
+	text := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 8)
+	for i := 1; i <= rows; i++ {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return nil, err
+		}
+		row := []any{fmt.Sprintf("Item %d", i), text}
+		if err = f.SetSheetRow(sheet, cell, &row); err != nil {
+			return nil, err
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// benchRenderer is implemented by targets rendering rows to text.
+type benchRenderer interface {
+	render(row map[string]string) (string, error)
+}
+
+// runBench runs the export of a synthetic sheet against mock endpoints and
+// measures fetch, render and publish latencies.
+func runBench(opts benchOptions) (*benchResult, error) {
+	if opts.rows <= 0 || opts.tasks <= 0 {
+		return nil, errors.New("invalid bench options: rows and tasks must be positive")
+	}
+	dir, err := os.MkdirTemp("", "drive_export_bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "bench.tmpl")
+	if err = os.WriteFile(tmpl, []byte(benchTemplate), filePerm); err != nil {
+		return nil, err
+	}
+	sheet, err := benchSheet(opts.rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sheet: %v", err)
+	}
+	srv := httptest.NewServer(&benchServer{sheet: sheet, latency: opts.latency})
+	defer srv.Close()
+
+	cfg := &config{
+		DataDir:              filepath.Join(dir, "data"),
+		TelegramBotToken:     "bench",
+		TelegramAPIURL:       srv.URL,
+		GoogleDriveEndpoint:  srv.URL + "/drive/v3/",
+		GoogleSheetsEndpoint: srv.URL + "/",
+		Concurrency:          opts.concurrency,
+	}
+	for i := 1; i <= opts.tasks; i++ {
+		cfg.Tasks = append(cfg.Tasks, &taskConfig{
+			Name: fmt.Sprintf("bench%d", i),
+			File: "bench",
+			Targets: []*targetConfig{{
+				Type:            telegramTargetType,
+				Name:            "bench",
+				TelegramChannel: "@bench",
+				Template:        tmpl,
+			}},
+		})
+	}
+	setupHTTP(context.Background(), cfg)
+	setupLimits(cfg)
+	clock := systemClock()
+	setupDrive(cfg, clock)
+	transport := &benchTransport{base: httpClient.Transport, ops: make(map[string][]time.Duration)}
+	httpClient = &http.Client{Transport: transport}
+
+	exp, err := newExportClient(cfg, httpClient, clock)
+	if err != nil {
+		return nil, fmt.Errorf("failed init export: %v", err)
+	}
+	defer exp.close()
+
+	result := &benchResult{Rows: opts.rows, Tasks: opts.tasks, Concurrency: exp.concurrency(), Latency: opts.latency}
+	phase := func(name string, fn func()) {
+		start := time.Now()
+		fn()
+		result.Phases = append(result.Phases, newBenchStats(name, []time.Duration{time.Since(start)}))
+	}
+	started := time.Now()
+	phase("fetch", func() { exp.fetch(context.Background()) })
+	if len(exp.tasks) != opts.tasks {
+		return nil, errors.New("failed to fetch bench sheets")
+	}
+
+	var render []time.Duration
+	for _, t := range exp.tasks {
+		rows, err := t.src.rows()
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range rows[1:] {
+			row := make(map[string]string, len(rows[0]))
+			for i, field := range rows[0] {
+				if i < len(values) {
+					row[field] = values[i]
+				}
+			}
+			for _, tt := range t.targets {
+				if r, ok := tt.(benchRenderer); ok {
+					start := time.Now()
+					if _, err = r.render(row); err != nil {
+						return nil, err
+					}
+					render = append(render, time.Since(start))
+				}
+			}
+		}
+	}
+
+	var results []taskResult
+	phase("process", func() { results = exp.process(context.Background()) })
+	phase("upload", func() { exp.upload(context.Background()) })
+	result.Duration = time.Since(started)
+
+	var publish []time.Duration
+	for _, r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("task %s failed: %v", r.name, r.err)
+		}
+		for _, outcome := range r.rows {
+			if outcome.Error != "" {
+				return nil, fmt.Errorf("task %s row %d failed: %s", r.name, outcome.Row, outcome.Error)
+			}
+			publish = append(publish, outcome.Duration)
+		}
+	}
+	result.RowsPerSecond = float64(opts.rows*opts.tasks) / result.Duration.Seconds()
+	result.Ops = append(result.Ops, newBenchStats("render", render), newBenchStats("publish", publish))
+	for _, op := range []string{"http drive", "http telegram"} {
+		result.Ops = append(result.Ops, newBenchStats(op, transport.ops[op]))
+	}
+	return result, nil
+}
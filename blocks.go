@@ -0,0 +1,86 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+const (
+	blocksColumn = "blocks"
+	noBlocks     = "none"
+)
+
+// contentBlocks are reusable template snippets (footers, donation texts)
+// configured once and enabled per row with the comma separated "blocks"
+// column, the task default blocks are used if the column is empty. Enabled
+// blocks are available to templates as .blocks.<name>.
+type contentBlocks struct {
+	blocks   map[string]string
+	defaults []string
+}
+
+func newContentBlocks(cfg *config, tcfg *taskConfig) (*contentBlocks, error) {
+	for _, name := range tcfg.Blocks {
+		if _, ok := cfg.Blocks[name]; !ok {
+			return nil, fmt.Errorf("invalid config: block %s not found", name)
+		}
+	}
+	return &contentBlocks{blocks: cfg.Blocks, defaults: tcfg.Blocks}, nil
+}
+
+// names returns the blocks enabled for the row.
+func (b *contentBlocks) names(row map[string]string) []string {
+	v := strings.TrimSpace(row[blocksColumn])
+	if v == "" {
+		return b.defaults
+	}
+	if v == noBlocks {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// text returns the row blocks for text templates.
+func (b *contentBlocks) text(row map[string]string) map[string]string {
+	m := make(map[string]string)
+	if b == nil {
+		return m
+	}
+	for _, name := range b.names(row) {
+		if content, ok := b.blocks[name]; ok {
+			m[name] = content
+		}
+	}
+	return m
+}
+
+// html returns the row blocks for HTML templates, block contents come from
+// the config and are trusted.
+func (b *contentBlocks) html(row map[string]string) map[string]template.HTML {
+	m := make(map[string]template.HTML)
+	for name, content := range b.text(row) {
+		m[name] = template.HTML(content)
+	}
+	return m
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultBotStateFile = "bot_state.json"
+
+// botState is the bot state persisted between restarts.
+type botState struct {
+	file   string
+	Offset int                   `json:"offset"`
+	Chats  map[int]*botChatState `json:"chats"`
+}
+
+type botChatState struct {
+	// SyncPending is set for sync requests received but not yet reported.
+	SyncPending bool      `json:"sync_pending,omitempty"`
+	LastSync    time.Time `json:"last_sync,omitempty"`
+	LastCommand string    `json:"last_command,omitempty"`
+}
+
+var botStateSchema = &stateSchema{
+	name: "bot",
+	migrations: []stateMigration{
+		noMigration, // 1: state version introduced
+	},
+}
+
+// loadBotState reads the bot state, restored reports whether it existed.
+func loadBotState(cfg *config) (state *botState, restored bool, err error) {
+	file := cfg.BotStateFile
+	if file == "" {
+		file = filepath.Join(cfg.DataDir, defaultBotStateFile)
+	}
+	state = &botState{file: file}
+	if err = botStateSchema.read(file, state); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		err = nil
+	} else {
+		restored = true
+	}
+	if state.Chats == nil {
+		state.Chats = make(map[int]*botChatState)
+	}
+	return state, restored, nil
+}
+
+func (s *botState) chat(id int) *botChatState {
+	c, ok := s.Chats[id]
+	if !ok {
+		c = &botChatState{}
+		s.Chats[id] = c
+	}
+	return c
+}
+
+func (s *botState) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.file), dirPerm); err != nil {
+		return err
+	}
+	return botStateSchema.write(s.file, s.file+".tmp", s)
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const telegramSecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// botWebhook receives bot updates pushed by Telegram to the webhook server.
+type botWebhook struct {
+	secret  string
+	updates chan *telegramUpdate
+	errs    chan error
+}
+
+func telegramSetWebhook(ctx context.Context, token, url, secret string) error {
+	_, err := telegramCall(ctx, token, "setWebhook", map[string]any{
+		"url":             url,
+		"secret_token":    secret,
+		"allowed_updates": []string{"message", "callback_query"},
+	})
+	return err
+}
+
+// startBotWebhook starts the webhook server and registers the webhook. The
+// server is stopped when the context is done.
+func startBotWebhook(ctx context.Context, cfg *config) (*botWebhook, error) {
+	wcfg := cfg.BotWebhook
+	if wcfg.URL == "" || wcfg.Listen == "" {
+		return nil, errors.New("invalid config: bot webhook url or listen address not set")
+	}
+	secret := wcfg.SecretToken
+	if wcfg.SecretTokenFile != "" {
+		var err error
+		if secret, err = readSecretFile(wcfg.SecretTokenFile); err != nil {
+			return nil, fmt.Errorf("failed to read bot webhook secret token: %v", err)
+		}
+	}
+	if secret == "" {
+		return nil, errors.New("invalid config: bot webhook secret token not set")
+	}
+	wh := &botWebhook{
+		secret:  secret,
+		updates: make(chan *telegramUpdate),
+		errs:    make(chan error, 1),
+	}
+	srv := &http.Server{Addr: wcfg.Listen, Handler: wh}
+	go func() {
+		var err error
+		if wcfg.CertFile != "" {
+			err = srv.ListenAndServeTLS(wcfg.CertFile, wcfg.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			wh.errs <- fmt.Errorf("webhook server error: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := telegramSetWebhook(ctx, cfg.TelegramBotToken, wcfg.URL, secret); err != nil {
+		_ = srv.Close()
+		return nil, fmt.Errorf("failed to set webhook: %v", err)
+	}
+	slog.Info("receiving updates", "listen", wcfg.Listen)
+	return wh, nil
+}
+
+func (wh *botWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(telegramSecretTokenHeader)), []byte(wh.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var u telegramUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Telegram retries the update unless it is accepted with 200
+	select {
+	case wh.updates <- &u:
+	case <-r.Context().Done():
+		http.Error(w, "timeout", http.StatusServiceUnavailable)
+	}
+}
+
+// getUpdates waits up to timeout for the first update and returns it with
+// the others already received.
+func (wh *botWebhook) getUpdates(ctx context.Context, timeout time.Duration) ([]*telegramUpdate, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var updates []*telegramUpdate
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-wh.errs:
+		return nil, err
+	case <-timer.C:
+		return nil, nil
+	case u := <-wh.updates:
+		updates = append(updates, u)
+	}
+	for {
+		select {
+		case u := <-wh.updates:
+			updates = append(updates, u)
+		default:
+			return updates, nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// buttonTemplate is an inline keyboard button of posts with the text and
+// URL rendered from the row.
+type buttonTemplate struct {
+	text *template.Template
+	url  *template.Template
+}
+
+func parseButtonTemplates(cfg [][]*buttonConfig) ([][]*buttonTemplate, error) {
+	keyboard := make([][]*buttonTemplate, 0, len(cfg))
+	for i, row := range cfg {
+		buttons := make([]*buttonTemplate, 0, len(row))
+		for j, bcfg := range row {
+			if bcfg.Text == "" || bcfg.URL == "" {
+				return nil, fmt.Errorf("invalid config: button %d.%d text or url not set", i+1, j+1)
+			}
+			b := &buttonTemplate{}
+			var err error
+			if b.text, err = template.New("text").Option("missingkey=zero").Parse(bcfg.Text); err != nil {
+				return nil, fmt.Errorf("failed to parse button %d.%d text: %v", i+1, j+1, err)
+			}
+			if b.url, err = template.New("url").Option("missingkey=zero").Parse(bcfg.URL); err != nil {
+				return nil, fmt.Errorf("failed to parse button %d.%d url: %v", i+1, j+1, err)
+			}
+			buttons = append(buttons, b)
+		}
+		keyboard = append(keyboard, buttons)
+	}
+	return keyboard, nil
+}
+
+// renderButtons renders the inline keyboard of the row. Buttons rendered
+// with an empty text or URL, e.g. catalog links of rows not in the catalog,
+// are left out.
+func renderButtons(keyboard [][]*buttonTemplate, row map[string]string) ([][]telegramButton, error) {
+	var rendered [][]telegramButton
+	for _, buttons := range keyboard {
+		var rbuttons []telegramButton
+		for _, b := range buttons {
+			var text, url bytes.Buffer
+			if err := executeTemplate(b.text, &text, row); err != nil {
+				return nil, fmt.Errorf("failed to render button text: %w", err)
+			}
+			if err := executeTemplate(b.url, &url, row); err != nil {
+				return nil, fmt.Errorf("failed to render button url: %w", err)
+			}
+			bt := telegramButton{Text: strings.TrimSpace(text.String()), URL: strings.TrimSpace(url.String())}
+			if bt.Text != "" && bt.URL != "" {
+				rbuttons = append(rbuttons, bt)
+			}
+		}
+		if len(rbuttons) != 0 {
+			rendered = append(rendered, rbuttons)
+		}
+	}
+	return rendered, nil
+}
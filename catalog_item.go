@@ -0,0 +1,118 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const catalogItemFile = "item.json"
+
+// catalogItem is the item metadata stored in the item directory, so the
+// catalog pages built from all items don't depend on the source sheet.
+type catalogItem struct {
+	ID        string        `json:"id"`
+	Title     string        `json:"title"`
+	Published time.Time     `json:"published"`
+	Tags      []string      `json:"tags,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Series    string        `json:"series,omitempty"`
+	Episode   int           `json:"episode,omitempty"`
+	// Row is the source row the item was built from, used to render the
+	// page again.
+	Row map[string]string `json:"row,omitempty"`
+}
+
+// newCatalogItem returns the item metadata of the row. Tags are read from
+// the comma separated "tags" column, audio duration from the "duration"
+// column in seconds or [hh:]mm:ss, series from the "series" column.
+func newCatalogItem(id string, row map[string]any, published time.Time) (*catalogItem, error) {
+	item := &catalogItem{ID: id, Published: published}
+	item.Title, _ = row["title"].(string)
+	if tags, _ := row["tags"].(string); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				item.Tags = append(item.Tags, tag)
+			}
+		}
+	}
+	item.Series, _ = row["series"].(string)
+	if ep, _ := row["episode"].(string); ep != "" {
+		item.Episode, _ = strconv.Atoi(ep)
+	}
+	if d, _ := row["duration"].(string); d != "" {
+		var err error
+		if item.Duration, err = parseDuration(d); err != nil {
+			return nil, fmt.Errorf("invalid row: invalid duration %s", d)
+		}
+	}
+	return item, nil
+}
+
+// parseDuration parses seconds or [hh:]mm:ss.
+func parseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second, nil
+}
+
+func readCatalogItem(idir string) (*catalogItem, error) {
+	b, err := os.ReadFile(filepath.Join(idir, catalogItemFile))
+	if err != nil {
+		return nil, err
+	}
+	var item catalogItem
+	if err = json.Unmarshal(b, &item); err != nil {
+		return nil, fmt.Errorf("failed to parse item metadata: %v", err)
+	}
+	return &item, nil
+}
+
+func (item *catalogItem) write(idir string) error {
+	b, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(idir, catalogItemFile), b, filePerm)
+}
+
+// readCatalogItems reads the metadata of the catalog items, items created
+// before the metadata was introduced are skipped.
+func readCatalogItems(cdir string, ids []string) ([]*catalogItem, error) {
+	var items []*catalogItem
+	for _, id := range ids {
+		item, err := readCatalogItem(filepath.Join(cdir, id))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("item %s: %v", id, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const catalogManifestFile = "manifest.json"
+
+// catalogManifest lists the catalog items, it is the source of the index
+// pages and of the item ids.
+type catalogManifest struct {
+	LastId int                     `json:"last_id"`
+	Items  []*catalogManifestEntry `json:"items"`
+}
+
+type catalogManifestEntry struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
+	Published time.Time `json:"published"`
+	Updated   time.Time `json:"updated"`
+	// RowHash is the hash of the source row the item was last built from.
+	RowHash string `json:"row_hash,omitempty"`
+}
+
+var catalogManifestSchema = &stateSchema{
+	name: "catalog manifest",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+// loadCatalogManifest reads the catalog manifest. Catalogs created before
+// the manifest was introduced are migrated from the item directories, their
+// metadata and the index entries.
+func loadCatalogManifest(cdir string, index []byte, entries *regexp.Regexp) (*catalogManifest, error) {
+	var m catalogManifest
+	err := catalogManifestSchema.read(filepath.Join(cdir, catalogManifestFile), &m)
+	if err == nil {
+		return &m, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	titles := make(map[string]string)
+	for _, match := range entries.FindAllSubmatch(index, -1) {
+		titles[string(match[1])] = string(match[2])
+	}
+	dirents, err := os.ReadDir(cdir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog directory: %v", err)
+	}
+	for _, dirent := range dirents {
+		id, err := strconv.Atoi(dirent.Name())
+		if err != nil || !dirent.IsDir() {
+			continue
+		}
+		m.LastId = max(m.LastId, id)
+		entry := &catalogManifestEntry{ID: dirent.Name(), Title: titles[dirent.Name()]}
+		if item, err := readCatalogItem(filepath.Join(cdir, dirent.Name())); err == nil {
+			entry.Title, entry.Published = item.Title, item.Published
+		} else if info, err := dirent.Info(); err == nil {
+			entry.Published = info.ModTime()
+		}
+		entry.Slug = slugify(entry.Title)
+		entry.Updated = entry.Published
+		m.Items = append(m.Items, entry)
+	}
+	sort.Slice(m.Items, func(i, j int) bool {
+		a, _ := strconv.Atoi(m.Items[i].ID)
+		b, _ := strconv.Atoi(m.Items[j].ID)
+		return a < b
+	})
+	return &m, nil
+}
+
+func (m *catalogManifest) write(cdir, tmp string) error {
+	return catalogManifestSchema.write(filepath.Join(cdir, catalogManifestFile), tmp, m)
+}
+
+func (m *catalogManifest) entry(id string) *catalogManifestEntry {
+	for _, entry := range m.Items {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// set adds the entry or replaces the one with the same id.
+func (m *catalogManifest) set(entry *catalogManifestEntry) {
+	for i, e := range m.Items {
+		if e.ID == entry.ID {
+			m.Items[i] = entry
+			return
+		}
+	}
+	m.Items = append(m.Items, entry)
+}
+
+func (m *catalogManifest) remove(id string) {
+	for i, entry := range m.Items {
+		if entry.ID == id {
+			m.Items = append(m.Items[:i], m.Items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *catalogManifest) ids() []string {
+	ids := make([]string, len(m.Items))
+	for i, entry := range m.Items {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+// rowHash returns the hash of the source row, used to tell whether the item
+// is up to date.
+func rowHash(row map[string]string) string {
+	b, _ := json.Marshal(row)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	catalogPagesDir  = "page"
+	paginationStart  = "<!-- pagination -->"
+	paginationEnd    = "<!-- /pagination -->"
+	catalogPageIndex = "index.html"
+)
+
+const defaultPaginationTemplate = `<nav class="pagination" aria-label="Pages">
+{{if .Prev}}<a href="{{.Prev}}" rel="prev">Previous</a>
+{{end}}{{range .Links}}{{if .Current}}<span aria-current="page">{{.Number}}</span>
+{{else}}<a href="{{.URL}}">{{.Number}}</a>
+{{end}}{{end}}{{if .Next}}<a href="{{.Next}}" rel="next">Next</a>
+{{end}}</nav>
+`
+
+type catalogPageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// catalogPagination is the data of the pagination template.
+type catalogPagination struct {
+	Page  int
+	Pages int
+	Prev  string
+	Next  string
+	Links []catalogPageLink
+}
+
+func newCatalogPaginationTemplate(file string) (*template.Template, error) {
+	if file != "" {
+		return template.ParseFiles(file)
+	}
+	return template.New("pagination").Parse(defaultPaginationTemplate)
+}
+
+func (ct *htmlCatalogTarget) pageURL(page int) string {
+	if page == 1 {
+		return ct.baseURL + filepath.Join("/", ct.staticPrefix, ct.catalog) + "/"
+	}
+	return ct.baseURL + filepath.Join("/", ct.staticPrefix, ct.catalog, catalogPagesDir, strconv.Itoa(page)) + "/"
+}
+
+// indexSkeleton returns the index without the item entries and pagination
+// controls. The controls are rendered at the <!-- pagination --> marker,
+// which is added after the list of entries if the index has none.
+func (ct *htmlCatalogTarget) indexSkeleton() []byte {
+	pagination := regexp.MustCompile(`(?s)` + regexp.QuoteMeta(paginationStart) + `.*?` + regexp.QuoteMeta(paginationEnd))
+	buf := pagination.ReplaceAll(ct.indexEntriesRegexp().ReplaceAll(ct.indexBuf, nil), []byte(paginationStart))
+	if bytes.Contains(buf, []byte(paginationStart)) {
+		return buf
+	}
+	pos := bytes.Index(buf, []byte(ct.indexPlaceholder))
+	if pos < 0 {
+		return buf
+	}
+	pos += len(ct.indexPlaceholder)
+	if end := regexp.MustCompile(`</[uo]l>`).FindIndex(buf[pos:]); end != nil {
+		pos += end[1]
+	}
+	return append(buf[:pos:pos], append([]byte(paginationStart), buf[pos:]...)...)
+}
+
+// indexItems returns the manifest entries, newest first.
+func (ct *htmlCatalogTarget) indexItems() []*catalogManifestEntry {
+	items := append([]*catalogManifestEntry(nil), ct.manifest.Items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].Published.Equal(items[j].Published) {
+			return items[i].Published.After(items[j].Published)
+		}
+		a, _ := strconv.Atoi(items[i].ID)
+		b, _ := strconv.Atoi(items[j].ID)
+		return a > b
+	})
+	return items
+}
+
+// writePages regenerates the index pages from the catalog manifest: the
+// newest items go to index.html, older ones to page/<n>/index.html.
+func (ct *htmlCatalogTarget) writePages() error {
+	items := ct.indexItems()
+	skeleton := ct.indexSkeleton()
+	pages := (len(items) + ct.pageSize - 1) / ct.pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	var err error
+	for page := 1; page <= pages; page++ {
+		var entries, controls bytes.Buffer
+		for _, item := range items[(page-1)*ct.pageSize : min(page*ct.pageSize, len(items))] {
+			entries.WriteString(ct.indexEntry(item.ID, item.Title))
+		}
+		entries.WriteString(ct.indexPlaceholder)
+		controls.WriteString(paginationStart)
+		if pages > 1 {
+			data := catalogPagination{Page: page, Pages: pages}
+			if page > 1 {
+				data.Prev = ct.pageURL(page - 1)
+			}
+			if page < pages {
+				data.Next = ct.pageURL(page + 1)
+			}
+			for n := 1; n <= pages; n++ {
+				data.Links = append(data.Links, catalogPageLink{Number: n, URL: ct.pageURL(n), Current: n == page})
+			}
+			if err = executeTemplate(ct.paginationTemplate, &controls, data); err != nil {
+				return fmt.Errorf("failed to render pagination template: %v", err)
+			}
+		}
+		controls.WriteString(paginationEnd)
+		buf := bytes.Replace(skeleton, []byte(ct.indexPlaceholder), entries.Bytes(), 1)
+		buf = bytes.Replace(buf, []byte(paginationStart), controls.Bytes(), 1)
+		if page == 1 {
+			ct.indexBuf = buf
+			if err = ct.writeIndex(); err != nil {
+				return err
+			}
+			continue
+		}
+		pdir := filepath.Join(ct.catalogDir, catalogPagesDir, strconv.Itoa(page))
+		if err = os.MkdirAll(pdir, dirPerm); err != nil {
+			return err
+		}
+		file := filepath.Join(pdir, catalogPageIndex)
+		if err = os.WriteFile(file+".tmp", buf, filePerm); err != nil {
+			return err
+		}
+		if err = os.Rename(file+".tmp", file); err != nil {
+			return err
+		}
+	}
+	// remove pages left from a bigger catalog
+	dirents, err := os.ReadDir(filepath.Join(ct.catalogDir, catalogPagesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, dirent := range dirents {
+		if n, err := strconv.Atoi(dirent.Name()); err == nil && n > pages {
+			if err = os.RemoveAll(filepath.Join(ct.catalogDir, catalogPagesDir, dirent.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// rebuildCatalogs regenerates the index, item and derived pages of all html
+// catalog targets from their manifests and templates.
+func rebuildCatalogs(ctx context.Context, cfg *config, clock runClock) error {
+	if err := os.MkdirAll(cfg.DataDir, dirPerm); err != nil {
+		return fmt.Errorf("failed to create data dir: %v", err)
+	}
+	unlock, err := lockDir(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock data dir: %v", err)
+	}
+	defer unlock()
+	dir, err := os.MkdirTemp(cfg.DataDir, "rebuild")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tcfg := range cfg.Tasks {
+		blocks, err := newContentBlocks(cfg, tcfg)
+		if err != nil {
+			return fmt.Errorf("task %s: %v", tcfg.Name, err)
+		}
+		folder := tcfg.DriveFolderId
+		if folder == "" {
+			folder = cfg.DriveFolderId
+		}
+		tdir := filepath.Join(dir, tcfg.Name)
+		if err = os.MkdirAll(tdir, dirPerm); err != nil {
+			return err
+		}
+		for _, tgcfg := range tcfg.Targets {
+			if tgcfg.Type != htmlCatalogTargetType {
+				continue
+			}
+			t, err := newTarget(cfg, tgcfg, tdir, tcfg.DriveId, folder, blocks, clock)
+			if err != nil {
+				return fmt.Errorf("task %s: %v", tcfg.Name, err)
+			}
+			ct := t.(*htmlCatalogTarget)
+			log.Printf("rebuilding catalog %s of task %s\n", ct.ID(), tcfg.Name)
+			if err = ct.rebuild(ctx, tgcfg); err != nil {
+				return fmt.Errorf("task %s target %s: %v", tcfg.Name, ct.ID(), err)
+			}
+			for _, warning := range ct.Warnings() {
+				slog.Warn(warning, "task", tcfg.Name, "target", ct.ID())
+			}
+		}
+	}
+	return nil
+}
+
+// rebuild regenerates the catalog from scratch: the index from the index
+// template (or the current index if it still has the placeholder, or the
+// default index) and the manifest entries, the item pages from the stored
+// source rows, then the pages built in Finish.
+func (ct *htmlCatalogTarget) rebuild(ctx context.Context, cfg *targetConfig) error {
+	var index []byte
+	switch {
+	case cfg.IndexTemplate != "":
+		b, err := os.ReadFile(cfg.IndexTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to read index template: %v", err)
+		}
+		index = b
+	case bytes.Contains(ct.indexBuf, []byte(ct.indexPlaceholder)):
+		index = ct.indexEntriesRegexp().ReplaceAll(ct.indexBuf, nil)
+	default:
+		ct.warnings = append(ct.warnings, fmt.Sprintf("%s index: placeholder not found, default index used", ct.ID()))
+		index = accessibleIndex(cfg)
+	}
+	if !bytes.Contains(index, []byte(ct.indexPlaceholder)) {
+		return fmt.Errorf("index template has no placeholder %s", ct.indexPlaceholder)
+	}
+
+	items := append([]*catalogManifestEntry(nil), ct.manifest.Items...)
+	sort.Slice(items, func(i, j int) bool {
+		a, _ := strconv.Atoi(items[i].ID)
+		b, _ := strconv.Atoi(items[j].ID)
+		return a < b
+	})
+	var entries bytes.Buffer
+	for _, entry := range items {
+		entries.WriteString(ct.indexEntry(entry.ID, entry.Title))
+	}
+	entries.WriteString(ct.indexPlaceholder)
+	ct.indexBuf = bytes.Replace(index, []byte(ct.indexPlaceholder), entries.Bytes(), 1)
+	if err := ct.writeIndex(); err != nil {
+		return err
+	}
+
+	for _, entry := range items {
+		idir := filepath.Join(ct.catalogDir, entry.ID)
+		item, err := readCatalogItem(idir)
+		if err != nil || item.Row == nil {
+			// items written before the source row was stored
+			ct.warnings = append(ct.warnings, fmt.Sprintf("%s item %s: no source row, page not rebuilt", ct.ID(), entry.ID))
+			continue
+		}
+		row, _, err := ct.prepareRow(item.Row)
+		if err != nil {
+			return fmt.Errorf("item %s: %v", entry.ID, err)
+		}
+		if _, err = ct.renderItem(entry.ID, idir, row, nil); err != nil {
+			return fmt.Errorf("item %s: %v", entry.ID, err)
+		}
+		ct.touchSeries(item.Series)
+	}
+	return ct.Finish(ctx)
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const catalogSeriesDir = "series"
+
+const defaultCatalogSeriesTemplate = `<!DOCTYPE html>
+<html{{if .Lang}} lang="{{.Lang}}"{{end}}>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<main>
+<h1>{{.Name}}</h1>
+<ol>
+{{range .Items}}<li><a href="{{.URL}}">{{.Title}}</a></li>
+{{end}}</ol>
+</main>
+</body>
+</html>
+`
+
+// catalogLink is a link to a catalog page.
+type catalogLink struct {
+	Title string
+	URL   string
+}
+
+// seriesNav is the series navigation of an item page.
+type seriesNav struct {
+	URL  string
+	Prev *catalogLink
+	Next *catalogLink
+}
+
+type catalogSeries struct {
+	Name  string
+	Lang  string
+	URL   string
+	Items []catalogLink
+}
+
+func newCatalogSeriesTemplate(file string) (*template.Template, error) {
+	if file != "" {
+		return template.ParseFiles(file)
+	}
+	return template.New("series").Parse(defaultCatalogSeriesTemplate)
+}
+
+// slugify returns the lowercase dash separated URL name of the string, used
+// for series directories and page slugs.
+func slugify(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() != 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "-")
+}
+
+// itemURL returns the item link: /<catalog>?item=<id> in the query link
+// style, the item page directory in the path style.
+func (ct *htmlCatalogTarget) itemURL(id string) string {
+	if ct.linkStyle == linkStylePath {
+		return ct.baseURL + filepath.Join("/", ct.staticPrefix, ct.catalog, id) + "/"
+	}
+	return fmt.Sprintf("%s/%s?item=%s", ct.baseURL, ct.catalog, id)
+}
+
+func (ct *htmlCatalogTarget) seriesURL(name string) string {
+	return ct.baseURL + filepath.Join("/", ct.staticPrefix, ct.catalog, catalogSeriesDir, slugify(name)) + "/"
+}
+
+// touchSeries marks the series to be rebuilt in Finish.
+func (ct *htmlCatalogTarget) touchSeries(name string) {
+	if ct.seriesTemplate == nil || name == "" {
+		return
+	}
+	if ct.touchedSeries == nil {
+		ct.touchedSeries = make(map[string]bool)
+	}
+	ct.touchedSeries[name] = true
+}
+
+// sortSeries orders series items by episode number, then by id.
+func sortSeries(items []*catalogItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Episode != items[j].Episode {
+			return items[i].Episode < items[j].Episode
+		}
+		a, _ := strconv.Atoi(items[i].ID)
+		b, _ := strconv.Atoi(items[j].ID)
+		return a < b
+	})
+}
+
+// writeSeries rebuilds the pages of the series changed in this run and the
+// navigation links of their items.
+func (ct *htmlCatalogTarget) writeSeries() error {
+	if len(ct.touchedSeries) == 0 {
+		return nil
+	}
+	items, err := readCatalogItems(ct.catalogDir, ct.manifest.ids())
+	if err != nil {
+		return fmt.Errorf("failed to read catalog items: %v", err)
+	}
+	series := make(map[string][]*catalogItem)
+	for _, item := range items {
+		if ct.touchedSeries[item.Series] {
+			series[item.Series] = append(series[item.Series], item)
+		}
+	}
+	for name := range ct.touchedSeries {
+		sdir := filepath.Join(ct.catalogDir, catalogSeriesDir, slugify(name))
+		sitems := series[name]
+		if len(sitems) == 0 {
+			if err = os.RemoveAll(sdir); err != nil {
+				return err
+			}
+			continue
+		}
+		sortSeries(sitems)
+		data := catalogSeries{Name: name, Lang: ct.lang, URL: ct.seriesURL(name)}
+		for _, item := range sitems {
+			data.Items = append(data.Items, catalogLink{Title: item.Title, URL: ct.itemURL(item.ID)})
+		}
+		for i, item := range sitems {
+			if item.Row == nil {
+				// items written before the source row was stored
+				continue
+			}
+			nav := &seriesNav{URL: data.URL}
+			if i > 0 {
+				nav.Prev = &data.Items[i-1]
+			}
+			if i < len(sitems)-1 {
+				nav.Next = &data.Items[i+1]
+			}
+			row, _, err := ct.prepareRow(item.Row)
+			if err != nil {
+				return fmt.Errorf("item %s: %v", item.ID, err)
+			}
+			if _, err = ct.renderItem(item.ID, filepath.Join(ct.catalogDir, item.ID), row, nav); err != nil {
+				return fmt.Errorf("item %s: %v", item.ID, err)
+			}
+		}
+		var buf bytes.Buffer
+		if err = executeTemplate(ct.seriesTemplate, &buf, data); err != nil {
+			return fmt.Errorf("failed to render series template: %v", err)
+		}
+		if err = os.MkdirAll(sdir, dirPerm); err != nil {
+			return err
+		}
+		file := filepath.Join(sdir, "index.html")
+		if err = os.WriteFile(file+".tmp", buf.Bytes(), filePerm); err != nil {
+			return err
+		}
+		if err = os.Rename(file+".tmp", file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const catalogStatsFile = "stats.html"
+
+const defaultCatalogStatsTemplate = `<!DOCTYPE html>
+<html{{if .Lang}} lang="{{.Lang}}"{{end}}>
+<head>
+<meta charset="utf-8">
+<title>{{.Catalog}} statistics</title>
+</head>
+<body>
+<main>
+<h1>{{.Catalog}} statistics</h1>
+<p>Items: {{.Items}}{{if .Duration}}, total duration: {{.Duration}}{{end}}</p>
+<h2>Items per month</h2>
+<table>
+<tr><th scope="col">Month</th><th scope="col">Items</th></tr>
+{{range .Months}}<tr><td>{{.Name}}</td><td>{{.Items}}</td></tr>
+{{end}}</table>
+{{if .Tags}}<h2>Items per tag</h2>
+<table>
+<tr><th scope="col">Tag</th><th scope="col">Items</th></tr>
+{{range .Tags}}<tr><td>{{.Name}}</td><td>{{.Items}}</td></tr>
+{{end}}</table>
+{{end}}<p>Updated {{.Updated}}</p>
+</main>
+</body>
+</html>
+`
+
+type catalogStatsCount struct {
+	Name  string
+	Items int
+}
+
+type catalogStats struct {
+	Catalog  string
+	Lang     string
+	Items    int
+	Duration time.Duration
+	Months   []catalogStatsCount
+	Tags     []catalogStatsCount
+	Updated  string
+}
+
+func newCatalogStatsTemplate(file string) (*template.Template, error) {
+	if file != "" {
+		return template.ParseFiles(file)
+	}
+	return template.New("stats").Parse(defaultCatalogStatsTemplate)
+}
+
+// writeStats rebuilds the catalog stats page from the items metadata.
+func (ct *htmlCatalogTarget) writeStats() error {
+	items, err := readCatalogItems(ct.catalogDir, ct.manifest.ids())
+	if err != nil {
+		return fmt.Errorf("failed to read catalog items: %v", err)
+	}
+	stats := catalogStats{
+		Catalog: ct.catalog,
+		Lang:    ct.lang,
+		Items:   len(items),
+		Updated: ct.runTime.Format(time.DateTime),
+	}
+	months := make(map[string]int)
+	tags := make(map[string]int)
+	for _, item := range items {
+		stats.Duration += item.Duration
+		months[item.Published.Format("2006-01")]++
+		for _, tag := range item.Tags {
+			tags[tag]++
+		}
+	}
+	for m, n := range months {
+		stats.Months = append(stats.Months, catalogStatsCount{Name: m, Items: n})
+	}
+	sort.Slice(stats.Months, func(i, j int) bool {
+		return stats.Months[i].Name > stats.Months[j].Name
+	})
+	for tag, n := range tags {
+		stats.Tags = append(stats.Tags, catalogStatsCount{Name: tag, Items: n})
+	}
+	sort.Slice(stats.Tags, func(i, j int) bool {
+		if stats.Tags[i].Items != stats.Tags[j].Items {
+			return stats.Tags[i].Items > stats.Tags[j].Items
+		}
+		return stats.Tags[i].Name < stats.Tags[j].Name
+	})
+	var buf bytes.Buffer
+	if err = executeTemplate(ct.statsTemplate, &buf, stats); err != nil {
+		return fmt.Errorf("failed to render stats template: %v", err)
+	}
+	tmp := filepath.Join(ct.taskDir, ct.ID()+"_"+catalogStatsFile)
+	if err = os.WriteFile(tmp, buf.Bytes(), filePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(ct.catalogDir, catalogStatsFile))
+}
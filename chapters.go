@@ -0,0 +1,72 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// chapter is an audio chapter of the "chapters" column.
+type chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// Seconds returns the chapter start for media fragment links (#t=).
+func (c chapter) Seconds() int {
+	return int(c.Start / time.Second)
+}
+
+// Time returns the chapter start formatted as [h:]mm:ss.
+func (c chapter) Time() string {
+	s := c.Seconds()
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// parseChapters parses the chapters list, a "[hh:]mm:ss title" line per
+// chapter in order of the start time.
+func parseChapters(s string) ([]chapter, error) {
+	var chapters []chapter
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ts, title, _ := strings.Cut(line, " ")
+		start, err := parseDuration(ts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter %q: %v", line, err)
+		}
+		if n := len(chapters); n != 0 && start <= chapters[n-1].Start {
+			return nil, fmt.Errorf("invalid chapter %q: chapters not in order", line)
+		}
+		chapters = append(chapters, chapter{Start: start, Title: strings.TrimSpace(title)})
+	}
+	return chapters, nil
+}
+
+// formatChapters returns the chapters list as text.
+func formatChapters(chapters []chapter) string {
+	var sb strings.Builder
+	for _, c := range chapters {
+		sb.WriteString(c.Time() + " " + c.Title + "\n")
+	}
+	return sb.String()
+}
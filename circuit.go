@@ -0,0 +1,54 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+const defaultCircuitBreakerFailures = 3
+
+// skippedStatus marks rows not handled because the target circuit is open,
+// they are handled again by the next run.
+const skippedStatus = "skipped: target unavailable"
+
+// circuitBreaker stops calling targets failing with consecutive temporary
+// errors for the rest of the run, so remaining rows don't time out one by
+// one.
+type circuitBreaker struct {
+	threshold int
+	failures  map[string]int
+}
+
+func newCircuitBreaker(cfg *config) *circuitBreaker {
+	threshold := cfg.CircuitBreakerFailures
+	if threshold == 0 {
+		threshold = defaultCircuitBreakerFailures
+	}
+	return &circuitBreaker{threshold: threshold, failures: make(map[string]int)}
+}
+
+// allow reports whether the target circuit is closed. A negative threshold
+// disables the breaker.
+func (cb *circuitBreaker) allow(id string) bool {
+	return cb.threshold < 0 || cb.failures[id] < cb.threshold
+}
+
+// record accounts the result of the target call, it returns true if the
+// circuit has just been opened.
+func (cb *circuitBreaker) record(id string, err error) bool {
+	if err == nil || !isRetryable(err) {
+		cb.failures[id] = 0
+		return false
+	}
+	cb.failures[id]++
+	return cb.threshold > 0 && cb.failures[id] == cb.threshold
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// runClock provides the time and run ids of exports, it is passed to tasks
+// and targets. The system clock is replaced by a fixed one to make export
+// dirs, timestamps and scheduling decisions of runs reproducible.
+type runClock struct {
+	now   func() time.Time
+	runID func(now time.Time) (string, error)
+}
+
+func systemClock() runClock {
+	return runClock{now: time.Now, runID: newRunID}
+}
+
+// fixedClock returns the clock stopped at t. Run ids end with a sequence
+// number in place of random bytes, so exports of the same process still get
+// distinct dirs.
+func fixedClock(t time.Time) runClock {
+	var seq atomic.Uint32
+	return runClock{
+		now: func() time.Time { return t },
+		runID: func(now time.Time) (string, error) {
+			return fmt.Sprintf("%s-%08x", now.Format(runIDTimeFormat), seq.Add(1)), nil
+		},
+	}
+}
+
+// withRunID returns the clock using the id for the next run, so the id of a
+// run started in the background is known to the caller.
+func (c runClock) withRunID(id string) runClock {
+	c.runID = func(time.Time) (string, error) {
+		return id, nil
+	}
+	return c
+}
+
+// newClock returns the clock fixed at the RFC 3339 time, the system clock
+// if empty.
+func newClock(fixed string) (runClock, error) {
+	if fixed == "" {
+		return systemClock(), nil
+	}
+	t, err := time.Parse(time.RFC3339, fixed)
+	if err != nil {
+		return runClock{}, fmt.Errorf("invalid clock time: %v", err)
+	}
+	return fixedClock(t), nil
+}
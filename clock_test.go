@@ -0,0 +1,152 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testClockTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestNewClock(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixed   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"fixed", "2024-01-02T03:04:05Z", testClockTime, false},
+		{"offset", "2024-01-02T05:04:05+02:00", testClockTime, false},
+		{"invalid", "2024-01-02", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock, err := newClock(tt.fixed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !clock.now().Equal(tt.want) {
+				t.Errorf("now %v, want %v", clock.now(), tt.want)
+			}
+		})
+	}
+	if clock, err := newClock(""); err != nil || clock.now == nil || clock.runID == nil {
+		t.Errorf("system clock not set: %v", err)
+	}
+}
+
+func TestFixedClockRunIDs(t *testing.T) {
+	clock := fixedClock(testClockTime)
+	for _, want := range []string{"20240102-030405-00000001", "20240102-030405-00000002", "20240102-030405-00000003"} {
+		id, err := clock.runID(clock.now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("run id %s, want %s", id, want)
+		}
+	}
+}
+
+// TestExportClock checks that exports created with the fixed clock get the
+// same dirs, run info and task times in every run.
+func TestExportClock(t *testing.T) {
+	for run := 0; run < 2; run++ {
+		dir := t.TempDir()
+		cfg := &config{DataDir: dir, Tasks: []*taskConfig{{Name: "task", File: "sheet"}}}
+		exp, err := newExportClient(cfg, http.DefaultClient, fixedClock(testClockTime))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp.id != "20240102-030405-00000001" {
+			t.Errorf("run %d: id %s", run, exp.id)
+		}
+		if exp.dir != filepath.Join(dir, exp.id) {
+			t.Errorf("run %d: dir %s", run, exp.dir)
+		}
+		b, err := os.ReadFile(filepath.Join(exp.dir, runInfoFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info runInfo
+		if err = json.Unmarshal(b, &info); err != nil {
+			t.Fatal(err)
+		}
+		if !info.Started.Equal(testClockTime) {
+			t.Errorf("run %d: started %v", run, info.Started)
+		}
+		if now := exp.tasks["task"].now(); !now.Equal(testClockTime) {
+			t.Errorf("run %d: task time %v", run, now)
+		}
+		if r := exp.report(nil); !r.Finished.Equal(testClockTime) {
+			t.Errorf("run %d: finished %v", run, r.Finished)
+		}
+		exp.close()
+	}
+}
+
+func TestScheduleDueFixedClock(t *testing.T) {
+	s, err := newSchedule(&scheduleConfig{Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := fixedClock(testClockTime).now()
+	tests := []struct {
+		publish string
+		want    bool
+	}{
+		{"", true},
+		{"2024-01-02 03:04", true},
+		{"2024-01-02 03:05", false},
+		{"2024-01-01", true},
+		{"2024-01-03", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.publish, func(t *testing.T) {
+			got, err := s.due(map[string]string{s.column: tt.publish}, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("due %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetsFixedClock(t *testing.T) {
+	clock := fixedClock(testClockTime)
+	dir := t.TempDir()
+	tcfg := &targetConfig{Name: "feed", Dir: dir, Catalog: "c", Feed: &feedConfig{Title: "t", Link: "l", BaseURL: "b"}}
+	rt, err := newRSSTarget(tcfg, dir, "", "", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rt.(*rssTarget).runTime.Equal(testClockTime) {
+		t.Errorf("rss run time %v", rt.(*rssTarget).runTime)
+	}
+	ht, err := newHugoTarget(&targetConfig{Name: "hugo", Dir: dir, Catalog: "h"}, dir, "", "", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ht.(*hugoTarget).runTime.Equal(testClockTime) {
+		t.Errorf("hugo run time %v", ht.(*hugoTarget).runTime)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/xuri/excelize/v2"
+	"sort"
+)
+
+// rowMediaColumns are the columns of row attachments read by targets.
+var rowMediaColumns = []string{"audio", "video", "document", "image", "photo", "files", "thumbnail", "chapters"}
+
+// columnMapping is the resolved mapping of the sheet columns of the task,
+// columns are referenced as "header (letter)".
+type columnMapping struct {
+	Targets map[string]targetColumnRefs `json:"targets"`
+	// Special are the detected columns by their role.
+	Special map[string]string `json:"special,omitempty"`
+}
+
+type targetColumnRefs struct {
+	Status   string `json:"status"`
+	RecordID string `json:"record_id"`
+}
+
+func columnRef(fields []string, idx int) string {
+	name, err := excelize.ColumnNumberToName(idx + 1)
+	if err != nil {
+		return fields[idx]
+	}
+	return fields[idx] + " (" + name + ")"
+}
+
+// columnMapping resolves the columns of the header.
+func (task *task) columnMapping(fields []string, statusColumns, recordIdColumns map[string]int) *columnMapping {
+	m := &columnMapping{Targets: make(map[string]targetColumnRefs, len(statusColumns))}
+	for tid, idx := range statusColumns {
+		m.Targets[tid] = targetColumnRefs{
+			Status:   columnRef(fields, idx),
+			RecordID: columnRef(fields, recordIdColumns[tid]),
+		}
+	}
+	roles := make(map[string]string)
+	for _, c := range rowMediaColumns {
+		roles[c] = c
+	}
+	roles["publish_at"] = task.schedule.column
+	if task.approval != nil {
+		roles["approval"] = task.approval.column
+	}
+	if task.langs != nil {
+		roles["lang"] = task.langs.column
+	}
+	if task.episodes != nil {
+		roles["episode"] = task.episodes.column
+	}
+	for i, f := range fields {
+		for role, column := range roles {
+			if f == column {
+				if m.Special == nil {
+					m.Special = make(map[string]string)
+				}
+				m.Special[role] = columnRef(fields, i)
+			}
+		}
+	}
+	return m
+}
+
+// log logs the mapping of the task columns.
+func (m *columnMapping) log(task *task) {
+	tids := make([]string, 0, len(m.Targets))
+	for tid := range m.Targets {
+		tids = append(tids, tid)
+	}
+	sort.Strings(tids)
+	for _, tid := range tids {
+		c := m.Targets[tid]
+		task.log.Info("target columns", "target", tid, "status", c.Status, "record_id", c.RecordID)
+	}
+	roles := make([]string, 0, len(m.Special))
+	for role := range m.Special {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	args := make([]any, 0, 2*len(roles))
+	for _, role := range roles {
+		args = append(args, role, m.Special[role])
+	}
+	task.log.Info("special columns", args...)
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// computedField is a row field computed with an expression, e.g.
+// "year = substr(row.date, 0, 4)". Expressions are function calls, row
+// fields (row.<name>), string and number literals joined with +.
+type computedField struct {
+	name string
+	expr exprNode
+}
+
+type exprNode interface {
+	eval(row map[string]string) (string, error)
+}
+
+type exprLiteral string
+
+func (e exprLiteral) eval(map[string]string) (string, error) {
+	return string(e), nil
+}
+
+type exprField string
+
+func (e exprField) eval(row map[string]string) (string, error) {
+	return row[string(e)], nil
+}
+
+type exprConcat []exprNode
+
+func (e exprConcat) eval(row map[string]string) (string, error) {
+	var sb strings.Builder
+	for _, part := range e {
+		s, err := part.eval(row)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), nil
+}
+
+type exprCall struct {
+	name string
+	fn   exprFunc
+	args []exprNode
+}
+
+func (e *exprCall) eval(row map[string]string) (string, error) {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		var err error
+		if args[i], err = arg.eval(row); err != nil {
+			return "", err
+		}
+	}
+	s, err := e.fn(args)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", e.name, err)
+	}
+	return s, nil
+}
+
+// exprFunc is a function of computed field expressions.
+type exprFunc func(args []string) (string, error)
+
+// exprFuncs are the functions available to expressions with their number of
+// arguments, -1 for any.
+var exprFuncs = map[string]struct {
+	argc int
+	fn   exprFunc
+}{
+	"humanizeSeconds": {1, func(args []string) (string, error) {
+		if args[0] == "" {
+			return "", nil
+		}
+		d, err := parseDuration(args[0])
+		if err != nil {
+			return "", err
+		}
+		h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+		if h > 0 {
+			return fmt.Sprintf("%d:%02d:%02d", h, m, s), nil
+		}
+		return fmt.Sprintf("%d:%02d", m, s), nil
+	}},
+	"substr": {-1, func(args []string) (string, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return "", fmt.Errorf("expected 2 or 3 arguments, got %d", len(args))
+		}
+		r := []rune(args[0])
+		start, err := strconv.Atoi(args[1])
+		if err != nil || start < 0 {
+			return "", fmt.Errorf("invalid start %s", args[1])
+		}
+		start = min(start, len(r))
+		end := len(r)
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("invalid length %s", args[2])
+			}
+			end = min(start+n, len(r))
+		}
+		return string(r[start:end]), nil
+	}},
+	"upper": {1, func(args []string) (string, error) { return strings.ToUpper(args[0]), nil }},
+	"lower": {1, func(args []string) (string, error) { return strings.ToLower(args[0]), nil }},
+	"trim":  {1, func(args []string) (string, error) { return strings.TrimSpace(args[0]), nil }},
+	"slug":  {1, func(args []string) (string, error) { return slugify(args[0]), nil }},
+	"replace": {3, func(args []string) (string, error) {
+		return strings.ReplaceAll(args[0], args[1], args[2]), nil
+	}},
+	"default": {2, func(args []string) (string, error) {
+		if args[0] == "" {
+			return args[1], nil
+		}
+		return args[0], nil
+	}},
+	"formatDate": {2, func(args []string) (string, error) {
+		if args[0] == "" {
+			return "", nil
+		}
+		for _, layout := range defaultScheduleFormats {
+			if t, err := time.Parse(layout, args[0]); err == nil {
+				return t.Format(args[1]), nil
+			}
+		}
+		return "", fmt.Errorf("invalid date %s", args[0])
+	}},
+}
+
+// parseComputedFields parses "name = expression" definitions.
+func parseComputedFields(defs []string) ([]*computedField, error) {
+	var fields []*computedField
+	for _, def := range defs {
+		name, src, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid config: invalid computed field %q", def)
+		}
+		p := &exprParser{src: src}
+		expr, err := p.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: computed field %s: %v", name, err)
+		}
+		fields = append(fields, &computedField{name: name, expr: expr})
+	}
+	return fields, nil
+}
+
+// computeFields adds the computed fields to the row in order, so later
+// fields may use earlier ones. Fields of the sheet are never overwritten.
+func computeFields(fields []*computedField, row map[string]string) error {
+	for _, f := range fields {
+		if _, ok := row[f.name]; ok {
+			continue
+		}
+		v, err := f.expr.eval(row)
+		if err != nil {
+			return fmt.Errorf("%w: computed field %s: %v", errValidation, f.name, err)
+		}
+		row[f.name] = v
+	}
+	return nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	expr, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos:], p.pos)
+	}
+	return expr, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) concat() (exprNode, error) {
+	var parts exprConcat
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, term)
+		if p.peek() != '+' {
+			break
+		}
+		p.pos++
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return parts, nil
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str(c)
+	case c >= '0' && c <= '9' || c == '-':
+		start := p.pos
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9'; p.pos++ {
+		}
+		return exprLiteral(p.src[start:p.pos]), nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	name := p.ident()
+	if name == "" {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos:], p.pos)
+	}
+	if name == "row" && p.peek() == '.' {
+		p.pos++
+		field := p.ident()
+		if field == "" {
+			return nil, fmt.Errorf("field name expected at %d", p.pos)
+		}
+		return exprField(field), nil
+	}
+	f, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if p.peek() != '(' {
+		return nil, fmt.Errorf("( expected after %s", name)
+	}
+	p.pos++
+	call := &exprCall{name: name, fn: f.fn}
+	if p.peek() == ')' {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.concat()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			c := p.peek()
+			p.pos++
+			if c == ')' {
+				break
+			}
+			if c != ',' {
+				return nil, fmt.Errorf(", or ) expected in %s arguments", name)
+			}
+		}
+	}
+	if f.argc >= 0 && len(call.args) != f.argc {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, f.argc, len(call.args))
+	}
+	return call, nil
+}
+
+func (p *exprParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		p.pos += size
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *exprParser) str(quote byte) (exprNode, error) {
+	var sb strings.Builder
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			p.pos++
+			sb.WriteByte(p.src[p.pos])
+			continue
+		}
+		if c == quote {
+			p.pos++
+			return exprLiteral(sb.String()), nil
+		}
+		sb.WriteByte(c)
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
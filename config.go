@@ -16,40 +16,349 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 type config struct {
-	DataDir               string        `json:"data_dir"`
-	GoogleCredentialsFile string        `json:"google_credentials_file"`
-	GoogleTokenFile       string        `json:"google_token_file"`
-	TelegramBotToken      string        `json:"telegram_bot_token"`
-	BotUsers              []int         `json:"bot_users"`
-	BotRefreshInterval    int           `json:"bot_refresh_interval"`
-	BotMaxErrors          int           `json:"bot_max_errors"`
-	BotTriggerMessage     string        `json:"bot_trigger_message"`
-	Tasks                 []*taskConfig `json:"tasks"`
+	DataDir               string              `json:"data_dir"`
+	GoogleCredentialsFile string              `json:"google_credentials_file"`
+	GoogleTokenFile       string              `json:"google_token_file"`
+	DriveFolderId         string              `json:"drive_folder_id"`
+	TelegramBotToken      string              `json:"telegram_bot_token"`
+	TelegramBotTokenFile  string              `json:"telegram_bot_token_file"`
+	BotUsers              []int               `json:"bot_users"`
+	BotChats              []int               `json:"bot_chats"`
+	BotUsername           string              `json:"bot_username"`
+	BotRequireMention     bool                `json:"bot_require_mention"`
+	BotRefreshInterval    int                 `json:"bot_refresh_interval"`
+	BotPollTimeout        int                 `json:"bot_poll_timeout"`
+	BotMaxErrors          int                 `json:"bot_max_errors"`
+	BotTriggerMessage     string              `json:"bot_trigger_message"`
+	BotStateFile          string              `json:"bot_state_file"`
+	BotWebhook            *botWebhookConfig   `json:"bot_webhook"`
+	ReportTemplate        string              `json:"report_template"`
+	ReportStrings         map[string]string   `json:"report_strings"`
+	Blocks                map[string]string   `json:"blocks"`
+	Reminder              *reminderConfig     `json:"reminder"`
+	TelegramAPIURL        string              `json:"telegram_api_url"`
+	GoogleDriveEndpoint   string              `json:"google_drive_endpoint"`
+	GoogleSheetsEndpoint  string              `json:"google_sheets_endpoint"`
+	GoogleTokenURL        string              `json:"google_token_url"`
+	UserAgent             string              `json:"user_agent"`
+	HTTPTimeouts          *httpTimeoutsConfig `json:"http_timeouts"`
+	Retry                 *retryConfig        `json:"retry"`
+	Limits                *limitsConfig       `json:"limits"`
+	Concurrency           int                 `json:"concurrency"`
+	// CircuitBreakerFailures is the number of consecutive temporary target
+	// failures skipping the target for the rest of the run, -1 disables it.
+	CircuitBreakerFailures int               `json:"circuit_breaker_failures"`
+	APIListen              string            `json:"api_listen"`
+	APITokens              []*apiTokenConfig `json:"api_tokens"`
+	AuditLog               string            `json:"audit_log"`
+	HistoryFile            string            `json:"history_file"`
+	HistorySize            int               `json:"history_size"`
+	HistoryDB              string            `json:"history_db"`
+	Cron                   *cronConfig       `json:"cron"`
+	Notifiers              []*notifierConfig `json:"notifiers"`
+	// DriveProperties sets run metadata as "app" or "public" properties of
+	// source spreadsheets.
+	DriveProperties string `json:"drive_properties"`
+	// DriveIncludeTrashed makes file lookups by name match trashed files.
+	DriveIncludeTrashed bool `json:"drive_include_trashed"`
+	// DriveSharedDrives makes file lookups of tasks without a drive_id
+	// search shared drives too.
+	DriveSharedDrives bool           `json:"drive_shared_drives"`
+	Logging           *loggingConfig `json:"logging"`
+	// LockWait is the time in seconds a run waits for the one in progress
+	// to finish before it is rejected.
+	LockWait   int               `json:"lock_wait"`
+	MediaCache *mediaCacheConfig `json:"media_cache"`
+	Tasks      []*taskConfig     `json:"tasks"`
+	// Profiles are named partial configs overriding the fields above.
+	Profiles map[string]json.RawMessage `json:"profiles"`
 }
 
 type taskConfig struct {
-	Name    string          `json:"name"`
-	File    string          `json:"file"`
-	Targets []*targetConfig `json:"targets"`
+	Name          string          `json:"name"`
+	File          string          `json:"file"`
+	DriveFolderId string          `json:"drive_folder_id"`
+	DriveId       string          `json:"drive_id"`
+	Enrich        *enrichConfig   `json:"enrich"`
+	Approval      *approvalConfig `json:"approval"`
+	Schedule      *scheduleConfig `json:"schedule"`
+	Episode       *episodeConfig  `json:"episode"`
+	Joins         []*joinConfig   `json:"joins"`
+	Langs         *langConfig     `json:"langs"`
+	// Cron is the task run schedule in the scheduler mode.
+	Cron string `json:"cron"`
+	// Source is the way the spreadsheet is read: "xlsx" (default) exports
+	// it as a workbook, "sheets" reads it with the Sheets API. Both write
+	// back only the changed cells with the Sheets API; the result workbook
+	// is no longer uploaded to Drive, it is only kept in the export dir.
+	// Cells are written as entered text (RAW), so written statuses, record
+	// ids and episode numbers are strings, not typed numbers or dates, and
+	// are never parsed as formulas. Sheets relying on typed values of
+	// these columns should convert them with formulas such as VALUE().
+	Source string `json:"source"`
+	// ExportFallback makes the xlsx source read the spreadsheet with the
+	// Sheets API when it exceeds the Drive export size limit.
+	ExportFallback bool `json:"export_fallback"`
+	// Sheet is the name of the task sheet, SheetIndex its 1-based position
+	// if the name is not set. The first sheet is used by default.
+	Sheet      string `json:"sheet"`
+	SheetIndex int    `json:"sheet_index"`
+	// HeaderRow is the sheet row number of the header, 1 by default.
+	// DataRow is the first data row, the one below the header by default.
+	HeaderRow int `json:"header_row"`
+	DataRow   int `json:"data_row"`
+	// Computed are "name = expression" fields added to rows.
+	Computed []string        `json:"computed"`
+	Blocks   []string        `json:"blocks"`
+	Targets  []*targetConfig `json:"targets"`
+}
+
+type botWebhookConfig struct {
+	URL             string `json:"url"`
+	Listen          string `json:"listen"`
+	SecretToken     string `json:"secret_token"`
+	SecretTokenFile string `json:"secret_token_file"`
+	CertFile        string `json:"cert_file"`
+	KeyFile         string `json:"key_file"`
+}
+
+// httpTimeoutsConfig are HTTP client timeouts in seconds, the request
+// timeout includes retries.
+type httpTimeoutsConfig struct {
+	Connect        int `json:"connect"`
+	TLSHandshake   int `json:"tls_handshake"`
+	ResponseHeader int `json:"response_header"`
+	Idle           int `json:"idle"`
+	Request        int `json:"request"`
+}
+
+// notifierConfig is a sink of run reports: a Telegram chat, an email
+// address, a Slack webhook or stdout.
+type notifierConfig struct {
+	Type             string   `json:"type"`
+	Chat             string   `json:"chat"`
+	WebhookURL       string   `json:"webhook_url"`
+	SMTPAddr         string   `json:"smtp_addr"`
+	SMTPUsername     string   `json:"smtp_username"`
+	SMTPPassword     string   `json:"smtp_password"`
+	SMTPPasswordFile string   `json:"smtp_password_file"`
+	From             string   `json:"from"`
+	To               []string `json:"to"`
+	Subject          string   `json:"subject"`
+	OnlyFailures     bool     `json:"only_failures"`
+}
+
+// loggingConfig sets the log level (debug, info, warn or error) and the
+// format (text or json).
+type loggingConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// mediaCacheConfig enables the attachment cache shared across runs, kept
+// in data_dir/media_cache and limited to 1 GB by default.
+type mediaCacheConfig struct {
+	Dir   string `json:"dir"`
+	MaxMB int64  `json:"max_mb"`
+}
+
+// cronConfig is the default run schedule of the scheduler mode.
+type cronConfig struct {
+	Schedule      string `json:"schedule"`
+	Timezone      string `json:"timezone"`
+	JitterSeconds int    `json:"jitter_seconds"`
+}
+
+type retryConfig struct {
+	MaxAttempts  int `json:"max_attempts"`
+	BackoffMs    int `json:"backoff_ms"`
+	MaxBackoffMs int `json:"max_backoff_ms"`
+}
+
+type limitsConfig struct {
+	MaxTempMB         int64 `json:"max_temp_mb"`
+	MaxUploadBufferMB int64 `json:"max_upload_buffer_mb"`
+	// TemplateTimeoutMs and MaxTemplateOutputKB limit template rendering,
+	// 10 s and 8 MB by default.
+	TemplateTimeoutMs   int   `json:"template_timeout_ms"`
+	MaxTemplateOutputKB int64 `json:"max_template_output_kb"`
+}
+
+type reminderConfig struct {
+	Chat          string `json:"chat"`
+	MaxAgeHours   int    `json:"max_age_hours"`
+	IntervalHours int    `json:"interval_hours"`
+}
+
+type apiTokenConfig struct {
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+	Role      string `json:"role"`
+}
+
+// joinConfig merges rows of the sheet with the key column value equal to
+// the task row key field, fields are added with the prefix.
+type joinConfig struct {
+	Sheet  string `json:"sheet"`
+	Key    string `json:"key"`
+	Column string `json:"column"`
+	Prefix string `json:"prefix"`
+}
+
+// langConfig maps row languages to the ids of their targets.
+type langConfig struct {
+	Column  string              `json:"column"`
+	Targets map[string][]string `json:"targets"`
+}
+
+type episodeConfig struct {
+	Column string `json:"column"`
+	Start  int    `json:"start"`
+}
+
+type scheduleConfig struct {
+	Column   string   `json:"column"`
+	Timezone string   `json:"timezone"`
+	Formats  []string `json:"formats"`
+}
+
+type approvalConfig struct {
+	Chat   string `json:"chat"`
+	Column string `json:"column"`
+}
+
+type enrichConfig struct {
+	Endpoint string   `json:"endpoint"`
+	Token    string   `json:"token"`
+	Fields   []string `json:"fields"`
 }
 
 type targetConfig struct {
-	Type             string `json:"type"`
-	Name             string `json:"name"`
-	Dir              string `json:"dir"`
-	Catalog          string `json:"catalog"`
-	TelegramChannel  string `json:"telegram_channel"`
-	Template         string `json:"template"`
-	IndexPlaceholder string `json:"index_placeholder"`
-	StaticPrefix     string `json:"static_prefix"`
-}
-
-func readConfig() (*config, error) {
-	file := os.Args[0] + ".json"
+	Type                string            `json:"type"`
+	Name                string            `json:"name"`
+	Dir                 string            `json:"dir"`
+	Catalog             string            `json:"catalog"`
+	TelegramChannel     string            `json:"telegram_channel"`
+	ChaptersMode        string            `json:"chapters_mode"`
+	Variants            map[string]string `json:"variants"`
+	VariantSelection    string            `json:"variant_selection"`
+	VariantColumn       string            `json:"variant_column"`
+	Template            string            `json:"template"`
+	Templates           map[string]string `json:"templates"`
+	TemplateColumn      string            `json:"template_column"`
+	TextFormat          string            `json:"text_format"`
+	ParseMode           string            `json:"parse_mode"`
+	SplitMessages       bool              `json:"split_messages"`
+	MessageThreadID     int               `json:"message_thread_id"`
+	ThreadColumn        string            `json:"thread_column"`
+	Summary             *summaryConfig    `json:"summary"`
+	Buttons             [][]*buttonConfig `json:"buttons"`
+	DisableNotification bool              `json:"disable_notification"`
+	ProtectContent      bool              `json:"protect_content"`
+	ScheduleColumn      string            `json:"schedule_column"`
+	IndexPlaceholder    string            `json:"index_placeholder"`
+	IndexTemplate       string            `json:"index_template"`
+	StaticPrefix        string            `json:"static_prefix"`
+	BaseURL             string            `json:"base_url"`
+	LinkStyle           string            `json:"link_style"`
+	Instance            string            `json:"instance"`
+	AccessToken         string            `json:"access_token"`
+	Visibility          string            `json:"visibility"`
+	URL                 string            `json:"url"`
+	UpdateURL           string            `json:"update_url"`
+	DeleteURL           string            `json:"delete_url"`
+	Headers             map[string]string `json:"headers"`
+	Body                string            `json:"body"`
+	ContentType         string            `json:"content_type"`
+	IdField             string            `json:"id_field"`
+	Transcribe          *transcribeConfig `json:"transcribe"`
+	AccessibilityStrict bool              `json:"accessibility_strict"`
+	Lang                string            `json:"lang"`
+	SkipLinks           bool              `json:"skip_links"`
+	ARIALandmarks       bool              `json:"aria_landmarks"`
+	ValidateHTML        bool              `json:"validate_html"`
+	Stats               bool              `json:"stats"`
+	StatsTemplate       string            `json:"stats_template"`
+	Series              bool              `json:"series"`
+	SeriesTemplate      string            `json:"series_template"`
+	Feed                *feedConfig       `json:"feed"`
+	Shortcode           string            `json:"shortcode"`
+	PageSize            int               `json:"page_size"`
+	PaginationTemplate  string            `json:"pagination_template"`
+	// DeployManifest writes the manifest of catalog files changed by the
+	// run for deployments.
+	DeployManifest bool `json:"deploy_manifest"`
+}
+
+// buttonConfig is an inline keyboard button, text and url are templates
+// rendered with the row fields.
+type buttonConfig struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// summaryConfig posts a message listing the posts of a run publishing more
+// than min_items of them.
+type summaryConfig struct {
+	MinItems int    `json:"min_items"`
+	Template string `json:"template"`
+}
+
+type feedConfig struct {
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Description string `json:"description"`
+	Language    string `json:"language"`
+	Author      string `json:"author"`
+	Image       string `json:"image"`
+	BaseURL     string `json:"base_url"`
+	ItemLink    string `json:"item_link"`
+	MaxItems    int    `json:"max_items"`
+	Podcast     bool   `json:"podcast"`
+	Category    string `json:"category"`
+	Explicit    bool   `json:"explicit"`
+	OwnerName   string `json:"owner_name"`
+	OwnerEmail  string `json:"owner_email"`
+	ShowType    string `json:"show_type"`
+}
+
+type transcribeConfig struct {
+	Endpoint string   `json:"endpoint"`
+	Token    string   `json:"token"`
+	Model    string   `json:"model"`
+	Command  []string `json:"command"`
+	Column   string   `json:"column"`
+}
+
+// Environment variables for the config path and secret overrides.
+const (
+	envConfig                = "DRIVE_EXPORT_CONFIG"
+	envTelegramBotToken      = "DRIVE_EXPORT_TELEGRAM_BOT_TOKEN"
+	envGoogleCredentialsFile = "DRIVE_EXPORT_GOOGLE_CREDENTIALS_FILE"
+	envGoogleTokenFile       = "DRIVE_EXPORT_GOOGLE_TOKEN_FILE"
+	envProfile               = "DRIVE_EXPORT_PROFILE"
+)
+
+// readConfig reads the config from the file, the DRIVE_EXPORT_CONFIG
+// variable or <executable>.json, in that order of precedence, and applies
+// the profile (or DRIVE_EXPORT_PROFILE) if set. Secrets set in the
+// environment override the file values.
+func readConfig(file string, profile string) (*config, error) {
+	if file == "" {
+		file = os.Getenv(envConfig)
+	}
+	if file == "" {
+		file = os.Args[0] + ".json"
+	}
 	b, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -58,5 +367,86 @@ func readConfig() (*config, error) {
 	if err = json.Unmarshal(b, &cfg); err != nil {
 		return nil, err
 	}
+	if profile == "" {
+		profile = os.Getenv(envProfile)
+	}
+	if profile != "" {
+		if err = cfg.applyProfile(profile); err != nil {
+			return nil, err
+		}
+	}
+	for env, v := range map[string]*string{
+		envTelegramBotToken:      &cfg.TelegramBotToken,
+		envGoogleCredentialsFile: &cfg.GoogleCredentialsFile,
+		envGoogleTokenFile:       &cfg.GoogleTokenFile,
+	} {
+		if val, ok := os.LookupEnv(env); ok {
+			*v = val
+		}
+	}
+	if _, ok := os.LookupEnv(envTelegramBotToken); !ok && cfg.TelegramBotTokenFile != "" {
+		if cfg.TelegramBotToken, err = readSecretFile(cfg.TelegramBotTokenFile); err != nil {
+			return nil, fmt.Errorf("failed to read telegram bot token: %v", err)
+		}
+	}
 	return &cfg, nil
 }
+
+// applyProfile overrides config fields set in the profile. Fields are
+// replaced as a whole, so tasks, maps and nested settings of the base config
+// never leak into the profile ones. Unless the profile sets its own data
+// dir, profile data is kept in a data dir subdirectory, so locks and bot
+// state of different profiles don't collide.
+func (cfg *config) applyProfile(name string) error {
+	raw, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("invalid profile %s: %v", name, err)
+	}
+	if _, ok = fields["profiles"]; ok {
+		return fmt.Errorf("invalid profile %s: nested profiles", name)
+	}
+	var pcfg config
+	if err := json.Unmarshal(raw, &pcfg); err != nil {
+		return fmt.Errorf("invalid profile %s: %v", name, err)
+	}
+	dst, src := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(&pcfg).Elem()
+	for key := range fields {
+		if i := configFieldIndex(key); i >= 0 {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	if _, ok = fields["data_dir"]; !ok {
+		cfg.DataDir = filepath.Join(cfg.DataDir, name)
+	}
+	return nil
+}
+
+// configFieldIndex returns the index of the config field decoded from the
+// JSON key, matched case-insensitively like encoding/json does, or -1.
+func configFieldIndex(key string) int {
+	t := reflect.TypeOf(config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if name != "-" && strings.EqualFold(name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// readSecretFile reads a secret mounted as a file (docker/k8s secrets).
+func readSecretFile(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		profile string
+		want    func(cfg *config) any
+		expect  any
+	}{
+		{
+			name:    "tasks are replaced, not merged",
+			base:    `{"tasks":[{"name":"a","file":"secret.xlsx","drive_folder_id":"f"}]}`,
+			profile: `{"tasks":[{"name":"b"}]}`,
+			want:    func(cfg *config) any { return *cfg.Tasks[0] },
+			expect:  taskConfig{Name: "b"},
+		},
+		{
+			name:    "task count follows the profile",
+			base:    `{"tasks":[{"name":"a"},{"name":"b"}]}`,
+			profile: `{"tasks":[{"name":"c"}]}`,
+			want:    func(cfg *config) any { return len(cfg.Tasks) },
+			expect:  1,
+		},
+		{
+			name:    "maps are replaced, not unioned",
+			base:    `{"blocks":{"a":"1","b":"2"}}`,
+			profile: `{"blocks":{"c":"3"}}`,
+			want:    func(cfg *config) any { return cfg.Blocks },
+			expect:  map[string]string{"c": "3"},
+		},
+		{
+			name:    "unset fields keep base values",
+			base:    `{"telegram_bot_token":"t","tasks":[{"name":"a"}]}`,
+			profile: `{"history_size":5}`,
+			want:    func(cfg *config) any { return []any{cfg.TelegramBotToken, cfg.HistorySize, cfg.Tasks[0].Name} },
+			expect:  []any{"t", 5, "a"},
+		},
+		{
+			name:    "nested settings are replaced",
+			base:    `{"logging":{"level":"debug","format":"json"}}`,
+			profile: `{"logging":{"level":"warn"}}`,
+			want:    func(cfg *config) any { return *cfg.Logging },
+			expect:  loggingConfig{Level: "warn"},
+		},
+		{
+			name:    "keys match case-insensitively",
+			base:    `{"tasks":[{"name":"a","file":"x"}]}`,
+			profile: `{"Tasks":[{"Name":"b"}]}`,
+			want:    func(cfg *config) any { return *cfg.Tasks[0] },
+			expect:  taskConfig{Name: "b"},
+		},
+		{
+			name:    "data dir defaults to a subdirectory",
+			base:    `{"data_dir":"data"}`,
+			profile: `{}`,
+			want:    func(cfg *config) any { return cfg.DataDir },
+			expect:  filepath.Join("data", "p"),
+		},
+		{
+			name:    "data dir set by the profile",
+			base:    `{"data_dir":"data"}`,
+			profile: `{"data_dir":"other"}`,
+			want:    func(cfg *config) any { return cfg.DataDir },
+			expect:  "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			if err := json.Unmarshal([]byte(tt.base), &cfg); err != nil {
+				t.Fatal(err)
+			}
+			cfg.Profiles = map[string]json.RawMessage{"p": json.RawMessage(tt.profile)}
+			if err := cfg.applyProfile("p"); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.want(&cfg); !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("got %+v, want %+v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+	}{
+		{"missing", ""},
+		{"nested profiles", `{"profiles":{}}`},
+		{"invalid json", `{"tasks":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{Profiles: map[string]json.RawMessage{}}
+			if tt.profile != "" {
+				cfg.Profiles["p"] = json.RawMessage(tt.profile)
+			}
+			if err := cfg.applyProfile("p"); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
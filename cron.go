@@ -0,0 +1,255 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week.
+type cronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses the expression, fields are lists of values, ranges and
+// steps, e.g. "*/15 9-18 * * 1-5".
+func parseCron(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if s, ok := cronShortcuts[spec]; ok {
+		spec = s
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: 5 fields expected", expr)
+	}
+	s := &cronSchedule{expr: expr}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		*f.bits = bits
+	}
+	// 7 is Sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = fields[2] != "*"
+	s.dowRestricted = fields[4] != "*"
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, st, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %s", st)
+			}
+			rng, step = r, n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %s", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %s", to)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range %s", part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time matching the schedule after t.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule matches within a few years
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day matches, restricted day of month and
+// day of week fields match either, as in cron.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// cronEntry runs the tasks on the schedule.
+type cronEntry struct {
+	schedule *cronSchedule
+	tasks    []string
+	next     time.Time
+}
+
+// newCronEntries returns the entries of the task schedules and the default
+// schedule of tasks without their own, expr overrides the config one.
+func newCronEntries(cfg *config, expr string) ([]*cronEntry, error) {
+	if expr == "" && cfg.Cron != nil {
+		expr = cfg.Cron.Schedule
+	}
+	var entries []*cronEntry
+	var rest []string
+	for _, tcfg := range cfg.Tasks {
+		if tcfg.Cron == "" {
+			rest = append(rest, tcfg.Name)
+			continue
+		}
+		s, err := parseCron(tcfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: task %s: %v", tcfg.Name, err)
+		}
+		entries = append(entries, &cronEntry{schedule: s, tasks: []string{tcfg.Name}})
+	}
+	if expr != "" && len(rest) != 0 {
+		s, err := parseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+		entries = append(entries, &cronEntry{schedule: s, tasks: rest})
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("invalid config: no cron schedules")
+	}
+	return entries, nil
+}
+
+// runScheduler runs the tasks on their cron schedules until ctx is done.
+// Runs are sequential, so schedules due while a run is in progress start
+// after it, once, instead of overlapping. Each start is delayed by a random
+// jitter up to the config one.
+func runScheduler(ctx context.Context, cfg *config, expr string, sync func(tasks []string) ([]taskResult, error)) error {
+	entries, err := newCronEntries(cfg, expr)
+	if err != nil {
+		return err
+	}
+	loc := time.Local
+	var jitter time.Duration
+	if cfg.Cron != nil {
+		if cfg.Cron.Timezone != "" {
+			if loc, err = time.LoadLocation(cfg.Cron.Timezone); err != nil {
+				return fmt.Errorf("invalid config: invalid cron timezone: %v", err)
+			}
+		}
+		jitter = time.Duration(cfg.Cron.JitterSeconds) * time.Second
+	}
+	schedule := func(e *cronEntry, now time.Time) {
+		e.next = e.schedule.next(now.In(loc))
+		if jitter > 0 {
+			e.next = e.next.Add(time.Duration(rand.Int63n(int64(jitter))))
+		}
+		log.Printf("next run of %s at %s\n", strings.Join(e.tasks, ", "), e.next.Format(time.RFC3339))
+	}
+	now := time.Now()
+	for _, e := range entries {
+		schedule(e, now)
+	}
+
+	sdStartWatchdog(ctx)
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("failed to notify systemd", "err", err)
+	}
+	for {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].next.Before(entries[j].next)
+		})
+		select {
+		case <-ctx.Done():
+			_ = sdNotify("STOPPING=1")
+			log.Println("stopped scheduler")
+			return nil
+		case <-time.After(time.Until(entries[0].next)):
+		}
+		now := time.Now()
+		var tasks []string
+		for _, e := range entries {
+			if !e.next.After(now) {
+				tasks = append(tasks, e.tasks...)
+			}
+		}
+		log.Printf("scheduled run of %s\n", strings.Join(tasks, ", "))
+		if _, err := sync(tasks); err != nil {
+			slog.Error("scheduled run failed", "err", err)
+		}
+		// schedules missed during the run are skipped
+		now = time.Now()
+		for _, e := range entries {
+			if !e.next.After(now) {
+				schedule(e, now)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dashboardRecentRuns = 20
+
+const dashboardTemplate = `{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>drive_export</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: .3em .6em; text-align: left; }
+.failed { color: #b00; }
+.pending { color: #a60; }
+</style>
+</head>
+<body>
+<h1><a href="/">drive_export</a> <small>{{.Version}}</small></h1>
+{{end}}
+
+{{define "foot"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "head" .}}
+<h2>Pending rows</h2>
+<table>
+<tr><th>Task</th><th>Pending</th></tr>
+{{range .Tasks}}<tr><td>{{.Name}}</td><td{{if .Pending}} class="pending"{{end}}>{{.Pending}}</td></tr>
+{{end}}</table>
+<h2>Recent runs</h2>
+<table>
+<tr><th>Run</th><th>Started</th><th>Duration</th><th>Done</th><th>Failed</th><th>Pending</th><th>Errors</th></tr>
+{{range .Runs}}<tr>
+<td><a href="/runs/{{.ID}}">{{.ID}}</a></td><td>{{.Started.Format "2006-01-02 15:04:05"}}</td><td>{{.Duration}}</td>
+<td>{{.Done}}</td><td{{if .Failed}} class="failed"{{end}}>{{.Failed}}</td><td>{{.Pending}}</td><td{{if .Errors}} class="failed"{{end}}>{{.Errors}}</td>
+</tr>
+{{else}}<tr><td colspan="7">no runs yet</td></tr>
+{{end}}</table>
+<h2>Tasks</h2>
+<table>
+<tr><th>Task</th><th>Runs</th><th>Done</th><th>Failed</th><th>Success</th></tr>
+{{range .Tasks}}<tr><td>{{.Name}}</td><td>{{.Runs}}</td><td>{{.Done}}</td><td>{{.Failed}}</td><td>{{.Rate}}</td></tr>
+{{end}}</table>
+<h2>Targets</h2>
+<table>
+<tr><th>Task</th><th>Target</th><th>Done</th><th>Failed</th><th>Success</th></tr>
+{{range .Targets}}<tr><td>{{.Task}}</td><td>{{.Name}}</td><td>{{.Done}}</td><td>{{.Failed}}</td><td>{{.Rate}}</td></tr>
+{{end}}</table>
+{{template "foot" .}}{{end}}
+
+{{define "run"}}{{template "head" .}}
+<h2>Run {{.Run.ID}}</h2>
+<p>Started {{.Run.Started.Format "2006-01-02 15:04:05"}}, finished {{.Run.Finished.Format "2006-01-02 15:04:05"}}</p>
+{{range .Run.Tasks}}<h3>{{.Name}}</h3>
+{{if .Error}}<p class="failed">error: {{.Error}}</p>{{end}}
+<p>records: total {{.Total}}, done {{.Done}}, failed {{.Failed}}, pending {{.Pending}}, scheduled {{.Scheduled}}{{if .Skipped}}, skipped {{.Skipped}}{{end}}</p>
+{{if .Targets}}<table>
+<tr><th>Target</th><th>Done</th><th>Failed</th></tr>
+{{range $id, $t := .Targets}}<tr><td>{{$id}}</td><td>{{$t.Done}}</td><td>{{$t.Failed}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Warnings}}<ul>
+{{range .Warnings}}<li class="pending">{{.}}</li>
+{{end}}</ul>{{end}}
+{{end}}
+{{template "foot" .}}{{end}}
+`
+
+var dashboardTemplates = template.Must(template.New("dashboard").Parse(dashboardTemplate))
+
+type dashboardRun struct {
+	*runRecord
+	Duration time.Duration
+	Done     int
+	Failed   int
+	Pending  int
+	Errors   int
+}
+
+type dashboardStats struct {
+	Task   string
+	Name   string
+	Runs   int
+	Done   int
+	Failed int
+	// Pending is the pending rows number of the latest run.
+	Pending int
+}
+
+func (s *dashboardStats) Rate() string {
+	if s.Done+s.Failed == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(s.Done)*100/float64(s.Done+s.Failed))
+}
+
+type dashboardData struct {
+	Version string
+	Runs    []dashboardRun
+	Tasks   []*dashboardStats
+	Targets []*dashboardStats
+	Run     *runRecord
+}
+
+// newDashboardData aggregates the run history, runs are ordered newest first.
+func newDashboardData(h *runHistory) *dashboardData {
+	data := &dashboardData{Version: toolVersion()}
+	tasks := make(map[string]*dashboardStats)
+	targets := make(map[string]*dashboardStats)
+	for i, run := range h.Runs {
+		dr := dashboardRun{runRecord: run, Duration: run.Finished.Sub(run.Started).Round(time.Second)}
+		for _, t := range run.Tasks {
+			dr.Done += t.Done
+			dr.Failed += t.Failed
+			dr.Pending += t.Pending
+			if t.Error != "" {
+				dr.Errors++
+			}
+			ts, ok := tasks[t.Name]
+			if !ok {
+				ts = &dashboardStats{Name: t.Name, Pending: t.Pending}
+				tasks[t.Name] = ts
+			}
+			ts.Runs++
+			ts.Done += t.Done
+			ts.Failed += t.Failed
+			for id, tr := range t.Targets {
+				key := t.Name + "/" + id
+				ts, ok := targets[key]
+				if !ok {
+					ts = &dashboardStats{Task: t.Name, Name: id}
+					targets[key] = ts
+				}
+				ts.Runs++
+				ts.Done += tr.Done
+				ts.Failed += tr.Failed
+			}
+		}
+		if i < dashboardRecentRuns {
+			data.Runs = append(data.Runs, dr)
+		}
+	}
+	for _, ts := range tasks {
+		data.Tasks = append(data.Tasks, ts)
+	}
+	sort.Slice(data.Tasks, func(i, j int) bool {
+		return data.Tasks[i].Name < data.Tasks[j].Name
+	})
+	for _, ts := range targets {
+		data.Targets = append(data.Targets, ts)
+	}
+	sort.Slice(data.Targets, func(i, j int) bool {
+		if data.Targets[i].Task != data.Targets[j].Task {
+			return data.Targets[i].Task < data.Targets[j].Task
+		}
+		return data.Targets[i].Name < data.Targets[j].Name
+	})
+	return data
+}
+
+// page registers the dashboard page handler. Pages are available with any
+// valid token, browsers may pass it as the basic auth password.
+func (s *apiServer) page(path string, fn func(w http.ResponseWriter, r *http.Request) error) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.authenticate(r) == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="drive_export"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := fn(w, r); err != nil {
+			slog.Warn("failed to render dashboard page", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func (s *apiServer) handleDashboard(w http.ResponseWriter, r *http.Request) error {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return nil
+	}
+	h, err := loadRunHistory(s.cfg)
+	if err != nil {
+		return err
+	}
+	return dashboardTemplates.ExecuteTemplate(w, "index", newDashboardData(h))
+}
+
+func (s *apiServer) handleDashboardRun(w http.ResponseWriter, r *http.Request) error {
+	h, err := loadRunHistory(s.cfg)
+	if err != nil {
+		return err
+	}
+	run := h.get(strings.TrimPrefix(r.URL.Path, "/runs/"))
+	if run == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	return dashboardTemplates.ExecuteTemplate(w, "run", &dashboardData{Version: toolVersion(), Run: run})
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	deployManifestFile = "deploy.json"
+	deployHashesFile   = "deploy-hashes.json"
+)
+
+// deployManifest lists the catalog files added, changed and removed by the
+// run, so deployments may upload only the delta. Paths are slash separated
+// and relative to the catalog directory.
+type deployManifest struct {
+	Generated time.Time `json:"generated"`
+	Added     []string  `json:"added"`
+	Changed   []string  `json:"changed"`
+	Removed   []string  `json:"removed"`
+}
+
+// deployHashes are the SHA-256 hashes of the catalog files by path as of
+// the last run.
+type deployHashes struct {
+	Files map[string]string `json:"files"`
+}
+
+var deployManifestSchema = &stateSchema{
+	name: "deploy manifest",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+var deployHashesSchema = &stateSchema{
+	name: "deploy hashes",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+// hashTree returns the hashes of the files of the directory except the
+// deploy manifest and hashes.
+func hashTree(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == deployManifestFile || rel == deployHashesFile {
+			return nil
+		}
+		if hashes[rel], err = hashFile(path); err != nil {
+			return err
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffHashes returns the manifest of changes between the hashes.
+func diffHashes(prev, cur map[string]string, now time.Time) *deployManifest {
+	m := &deployManifest{Generated: now, Added: []string{}, Changed: []string{}, Removed: []string{}}
+	for file, hash := range cur {
+		if old, ok := prev[file]; !ok {
+			m.Added = append(m.Added, file)
+		} else if old != hash {
+			m.Changed = append(m.Changed, file)
+		}
+	}
+	for file := range prev {
+		if _, ok := cur[file]; !ok {
+			m.Removed = append(m.Removed, file)
+		}
+	}
+	sort.Strings(m.Added)
+	sort.Strings(m.Changed)
+	sort.Strings(m.Removed)
+	return m
+}
+
+// writeDeployManifest writes the manifest of the catalog files changed
+// since the last run into the directory and stores the current hashes.
+// All files are added on the first run. Temp files are written to tmpDir.
+func writeDeployManifest(dir, tmpDir, prefix string, now time.Time) error {
+	var prev deployHashes
+	if err := deployHashesSchema.read(filepath.Join(dir, deployHashesFile), &prev); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cur, err := hashTree(dir)
+	if err != nil {
+		return err
+	}
+	m := diffHashes(prev.Files, cur, now)
+	err = deployManifestSchema.write(
+		filepath.Join(dir, deployManifestFile),
+		filepath.Join(tmpDir, prefix+"_"+deployManifestFile),
+		m,
+	)
+	if err != nil {
+		return err
+	}
+	return deployHashesSchema.write(
+		filepath.Join(dir, deployHashesFile),
+		filepath.Join(tmpDir, prefix+"_"+deployHashesFile),
+		&deployHashes{Files: cur},
+	)
+}
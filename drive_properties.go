@@ -0,0 +1,102 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Drive properties modes: app properties are private to the OAuth client,
+// public properties are visible to all apps of the file.
+const (
+	drivePropertiesApp    = "app"
+	drivePropertiesPublic = "public"
+)
+
+const drivePropertyPrefix = "drive_export_"
+
+// runProperties returns the sync metadata of the task result set as Drive
+// file properties.
+func (exp *export) runProperties(result taskResult) map[string]string {
+	props := map[string]string{
+		"last_run": exp.started.UTC().Format(time.RFC3339),
+		"run_id":   exp.id,
+		"version":  toolVersion(),
+		"total":    strconv.Itoa(result.total),
+		"done":     strconv.Itoa(result.done),
+		"failed":   strconv.Itoa(result.failed),
+		"pending":  strconv.Itoa(result.pending),
+		"error":    "",
+	}
+	if result.err != nil {
+		// keys with values are limited to 124 bytes
+		props["error"] = truncateBytes(redactSecrets(result.err.Error()), 124-len(drivePropertyPrefix+"error"))
+	}
+	m := make(map[string]string, len(props))
+	for k, v := range props {
+		m[drivePropertyPrefix+k] = v
+	}
+	return m
+}
+
+// setProperties sets the sync metadata of the run on the source
+// spreadsheets of the tasks, failures are logged.
+func (exp *export) setProperties(ctx context.Context, results []taskResult) {
+	mode := exp.cfg.DriveProperties
+	if mode == "" {
+		return
+	}
+	for _, result := range results {
+		t, ok := exp.tasks[result.name]
+		if !ok || t.src.driveId() == "" {
+			continue
+		}
+		f := &drive.File{}
+		props := exp.runProperties(result)
+		if mode == drivePropertiesPublic {
+			f.Properties = props
+		} else {
+			f.AppProperties = props
+		}
+		if _, err := exp.fs.Update(t.src.driveId(), f).SupportsAllDrives(true).Fields("id").Context(ctx).Do(); err != nil {
+			t.log.Warn("failed to set drive properties", "err", classifyHTTPError(err))
+		}
+	}
+}
+
+// truncateBytes cuts s to at most n bytes at a rune boundary.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func validDriveProperties(mode string) error {
+	switch mode {
+	case "", drivePropertiesApp, drivePropertiesPublic:
+		return nil
+	default:
+		return fmt.Errorf("invalid config: invalid drive properties mode %s", mode)
+	}
+}
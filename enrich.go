@@ -0,0 +1,101 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// enricher fills missing row fields (teasers, SEO descriptions, etc.) by
+// calling an external HTTP endpoint, e.g. a proxy in front of an LLM API.
+type enricher struct {
+	endpoint string
+	token    string
+	fields   []string
+}
+
+func newEnricher(cfg *enrichConfig) *enricher {
+	if cfg == nil || cfg.Endpoint == "" || len(cfg.Fields) == 0 {
+		return nil
+	}
+	return &enricher{
+		endpoint: cfg.Endpoint,
+		token:    cfg.Token,
+		fields:   cfg.Fields,
+	}
+}
+
+type enrichRequest struct {
+	Row    map[string]string `json:"row"`
+	Fields []string          `json:"fields"`
+}
+
+type enrichResponse struct {
+	Fields map[string]string `json:"fields"`
+}
+
+// missing returns configured fields that are present in the sheet but empty
+// in the row.
+func (e *enricher) missing(row map[string]string) []string {
+	var fields []string
+	for _, f := range e.fields {
+		if v, ok := row[f]; ok && v == "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// enrich requests values for the missing fields and returns the filled ones.
+func (e *enricher) enrich(ctx context.Context, row map[string]string) (map[string]string, error) {
+	fields := e.missing(row)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	var buf uploadBuffer
+	if err := json.NewEncoder(&buf).Encode(enrichRequest{Row: row, Fields: fields}); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, &buf.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, classifyHTTPError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("enrich request error: %s", resp.Status)
+	}
+	var result enrichResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode enrich response: %v", err)
+	}
+	filled := make(map[string]string, len(fields))
+	for _, f := range fields {
+		if v := result.Fields[f]; v != "" {
+			filled[f] = v
+		}
+	}
+	return filled, nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnrichUploadBuffer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"fields":{"teaser":"short"}}`))
+	}))
+	defer srv.Close()
+	maxUploadBuffer = 1024
+	defer func() { maxUploadBuffer = 0 }()
+
+	e := &enricher{endpoint: srv.URL, fields: []string{"teaser"}}
+	filled, err := e.enrich(context.Background(), map[string]string{"text": "text", "teaser": ""})
+	if err != nil || filled["teaser"] != "short" {
+		t.Errorf("filled = %v, err = %v", filled, err)
+	}
+	_, err = e.enrich(context.Background(), map[string]string{"text": strings.Repeat("t", 2048), "teaser": ""})
+	if !isLimitError(err) {
+		t.Errorf("err = %v, want limit error", err)
+	}
+}
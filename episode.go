@@ -0,0 +1,121 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	defaultEpisodeColumn = "episode"
+	episodesDir          = "episodes"
+)
+
+// episodeCounter numbers published rows of the task. The number is set to
+// the episode column of rows which don't have it, so it is written back if
+// the sheet has the column, and is available to templates as episode.
+// Numbers are reserved for the row being published and taken only once it
+// is inserted, so failed inserts leave no gaps. Taken numbers are kept by
+// row key, rows published to some targets get the same number on retries
+// also without the sheet column.
+type episodeCounter struct {
+	file   string
+	column string
+	Last   int            `json:"last"`
+	Rows   map[string]int `json:"rows,omitempty"`
+	// reserved are the numbers assigned to rows not inserted yet.
+	reserved map[string]int
+}
+
+var episodeCounterSchema = &stateSchema{
+	name: "episode counter",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+		noMigration, // 2: numbers by row
+	},
+}
+
+func newEpisodeCounter(cfg *config, tcfg *taskConfig) (*episodeCounter, error) {
+	if tcfg.Episode == nil {
+		return nil, nil
+	}
+	c := &episodeCounter{
+		file:     filepath.Join(cfg.DataDir, episodesDir, tcfg.Name+".json"),
+		column:   tcfg.Episode.Column,
+		Rows:     make(map[string]int),
+		reserved: make(map[string]int),
+	}
+	if c.column == "" {
+		c.column = defaultEpisodeColumn
+	}
+	if err := episodeCounterSchema.read(c.file, c); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		c.Last = tcfg.Episode.Start - 1
+		if c.Last < 0 {
+			c.Last = 0
+		}
+	}
+	return c, nil
+}
+
+// assign sets the episode number to the row unless it has one: the number
+// taken by the row with the key, or the next one reserved until commit.
+func (c *episodeCounter) assign(row map[string]string, key string) {
+	if row[c.column] == "" {
+		n, ok := c.Rows[key]
+		if !ok {
+			n = c.Last + 1
+			c.reserved[key] = n
+		}
+		row[c.column] = strconv.Itoa(n)
+	}
+	row[defaultEpisodeColumn] = row[c.column]
+}
+
+// commit takes the number reserved for the row once it is inserted, the
+// counter is saved right away so numbers are never reused.
+func (c *episodeCounter) commit(key string) error {
+	n, ok := c.reserved[key]
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), dirPerm); err != nil {
+		return err
+	}
+	last := c.Last
+	c.Last, c.Rows[key] = n, n
+	if err := episodeCounterSchema.write(c.file, c.file+".tmp", c); err != nil {
+		c.Last = last
+		delete(c.Rows, key)
+		return err
+	}
+	delete(c.reserved, key)
+	return nil
+}
+
+// release clears the number reserved for the row if it was not inserted,
+// so it is neither written back nor taken by the row.
+func (c *episodeCounter) release(row map[string]string, key string) {
+	if _, ok := c.reserved[key]; !ok {
+		return
+	}
+	delete(c.reserved, key)
+	row[c.column] = ""
+	row[defaultEpisodeColumn] = ""
+}
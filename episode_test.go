@@ -0,0 +1,75 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEpisodeCounter(t *testing.T) {
+	type step struct {
+		key     string
+		column  string
+		insert  bool
+		episode string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		last  int
+	}{
+		{"numbers inserted rows", []step{{"a", "", true, "1"}, {"b", "", true, "2"}}, 2},
+		{"failed insert leaves no gap", []step{{"a", "", false, "1"}, {"b", "", true, "1"}, {"a", "", true, "2"}}, 2},
+		{"retried row keeps its number", []step{{"a", "", true, "1"}, {"b", "", true, "2"}, {"a", "", true, "1"}}, 2},
+		{"restored row keeps its number", []step{{"a", "", true, "1"}, {"a", "", false, "1"}, {"b", "", true, "2"}}, 2},
+		{"sheet number kept", []step{{"a", "7", true, "7"}, {"b", "", true, "1"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config{DataDir: t.TempDir()}
+			tcfg := &taskConfig{Name: "task", Episode: &episodeConfig{}}
+			c, err := newEpisodeCounter(cfg, tcfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.steps {
+				row := map[string]string{defaultEpisodeColumn: s.column}
+				c.assign(row, s.key)
+				if row[defaultEpisodeColumn] != s.episode {
+					t.Errorf("step %d: episode = %q, want %q", i, row[defaultEpisodeColumn], s.episode)
+				}
+				if s.insert {
+					if err = c.commit(s.key); err != nil {
+						t.Fatal(err)
+					}
+				}
+				c.release(row, s.key)
+				want := s.episode
+				if _, taken := c.Rows[s.key]; !taken && s.column == "" {
+					want = ""
+				}
+				if row[defaultEpisodeColumn] != want {
+					t.Errorf("step %d: episode after release = %q, want %q", i, row[defaultEpisodeColumn], want)
+				}
+			}
+			if c, err = newEpisodeCounter(cfg, tcfg); err != nil {
+				t.Fatal(err)
+			}
+			if c.Last != tt.last {
+				t.Errorf("saved last = %d, want %d", c.Last, tt.last)
+			}
+		})
+	}
+}
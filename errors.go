@@ -0,0 +1,101 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"net"
+	"net/http"
+)
+
+// Error classes of the pipeline. Errors are wrapped with their class, so
+// retries, statuses and exit codes are decided with errors.Is rather than
+// by matching messages.
+var (
+	errNotFound    = errors.New("not found")
+	errRateLimited = errors.New("rate limited")
+	errValidation  = errors.New("invalid row")
+	errTransient   = errors.New("temporary failure")
+)
+
+// Error class names of run reports and status cells.
+const (
+	errorClassNotFound    = "not_found"
+	errorClassRateLimited = "rate_limited"
+	errorClassValidation  = "validation"
+	errorClassTransient   = "transient"
+	// errorClassOther is the status cell class of unclassified errors.
+	errorClassOther = "error"
+)
+
+// httpStatusClass returns the error class of the HTTP response status.
+func httpStatusClass(code int) error {
+	switch {
+	case code == http.StatusNotFound:
+		return errNotFound
+	case code == http.StatusTooManyRequests:
+		return errRateLimited
+	case code >= 500:
+		return errTransient
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		return errValidation
+	}
+	return nil
+}
+
+// classifyHTTPError wraps the error of an API call with its class, network
+// failures are transient. The error stays in the chain.
+func classifyHTTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	err = redactURLError(err)
+	var ge *googleapi.Error
+	if errors.As(err, &ge) {
+		if class := httpStatusClass(ge.Code); class != nil {
+			return fmt.Errorf("%w: %w", class, err)
+		}
+		return err
+	}
+	// the cause is kept, so cancellation can be told from network errors
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return fmt.Errorf("%w: %w", errTransient, err)
+	}
+	return err
+}
+
+// isRetryable reports whether the failed operation may succeed on a later
+// run without changes to the row.
+func isRetryable(err error) bool {
+	return errors.Is(err, errRateLimited) || errors.Is(err, errTransient)
+}
+
+// errorClass returns the name of the error class for reports.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, errNotFound):
+		return errorClassNotFound
+	case errors.Is(err, errRateLimited):
+		return errorClassRateLimited
+	case errors.Is(err, errValidation):
+		return errorClassValidation
+	case errors.Is(err, errTransient):
+		return errorClassTransient
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClassifyHTTPError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class string
+		retry bool
+	}{
+		{"google not found", &googleapi.Error{Code: 404}, errorClassNotFound, false},
+		{"google rate limit", &googleapi.Error{Code: 429}, errorClassRateLimited, true},
+		{"google server error", &googleapi.Error{Code: 503}, errorClassTransient, true},
+		{"google bad request", &googleapi.Error{Code: 400}, errorClassValidation, false},
+		{"google forbidden", &googleapi.Error{Code: 403}, "", false},
+		{"network error", &url.Error{Op: "Post", URL: "https://example.com", Err: errors.New("connection reset")}, errorClassTransient, true},
+		{"cancelled request", &url.Error{Op: "Post", URL: "https://example.com", Err: context.Canceled}, errorClassTransient, true},
+		{"other error", errors.New("boom"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyHTTPError(tt.err)
+			if class := errorClass(err); class != tt.class {
+				t.Errorf("class = %q, want %q", class, tt.class)
+			}
+			if retry := isRetryable(err); retry != tt.retry {
+				t.Errorf("retryable = %v, want %v", retry, tt.retry)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("cause is lost")
+			}
+		})
+	}
+	if err := classifyHTTPError(&url.Error{Op: "Post", URL: "u", Err: context.Canceled}); !errors.Is(err, context.Canceled) {
+		t.Error("cancellation is lost")
+	}
+}
+
+func TestTelegramError(t *testing.T) {
+	tests := []struct {
+		code  int
+		desc  string
+		class error
+	}{
+		{400, "Bad Request: message is not modified: specified new message content and reply markup are exactly the same", errTelegramNotModified},
+		{400, "Bad Request: message to delete not found", errNotFound},
+		{400, "Bad Request: message to edit not found", errNotFound},
+		{400, "Bad Request: chat not found", errNotFound},
+		{400, "Bad Request: file not found in the message text", errValidation},
+		{400, "Bad Request: can't parse entities", errValidation},
+		{403, "Forbidden: bot was blocked by the user", nil},
+		{429, "Too Many Requests: retry after 5", errRateLimited},
+		{502, "Bad Gateway", errTransient},
+	}
+	classes := []error{errTelegramNotModified, errNotFound, errValidation, errRateLimited, errTransient}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := telegramError(tt.code, tt.desc)
+			for _, class := range classes {
+				if is := errors.Is(err, class); is != (class == tt.class) {
+					t.Errorf("errors.Is(%v) = %v", class, is)
+				}
+			}
+			want := fmt.Sprintf("telegram request error %d: %s", tt.code, tt.desc)
+			if err.Error() != want {
+				t.Errorf("message = %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestHasRetryableFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []error
+		want bool
+	}{
+		{"no failures", []error{nil}, false},
+		{"validation", []error{fmt.Errorf("%w: bad", errValidation)}, false},
+		{"rate limited", []error{nil, fmt.Errorf("%w: slow down", errRateLimited)}, true},
+		{"transient", []error{telegramError(500, "Internal Server Error")}, true},
+		{"not found", []error{telegramError(400, "Bad Request: chat not found")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result taskResult
+			for i, err := range tt.errs {
+				result.target(&webhookTarget{name: "w"}, i+2, rowOpInsert, "", err, time.Now())
+			}
+			if got := hasRetryableFailures([]taskResult{result}); got != tt.want {
+				t.Errorf("hasRetryableFailures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -15,19 +15,34 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/drive/v3"
-	"log"
+	"google.golang.org/api/sheets/v4"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
 type export struct {
-	cfg   *config
-	dir   string
-	fs    *drive.FilesService
-	tasks map[string]*task
+	cfg     *config
+	id      string
+	started time.Time
+	clock   runClock
+	dir     string
+	fs      *drive.FilesService
+	ss      *sheets.SpreadsheetsService
+	tasks   map[string]*task
+	db      *historyDB
+	unlock  func() error
 }
 
 const (
@@ -35,67 +50,276 @@ const (
 	dirPerm  = 0755
 )
 
-func newExport(cfg *config) (*export, error) {
-	var err error
-	var exp = &export{cfg: cfg}
-	exp.dir = filepath.Join(cfg.DataDir, time.Now().Format(time.DateTime))
+const runInfoFile = "run.json"
+
+// runInfo is stored in the export dir to identify the run it belongs to.
+type runInfo struct {
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+	PID     int       `json:"pid"`
+	Version string    `json:"version"`
+	Tasks   []string  `json:"tasks"`
+}
+
+const runIDTimeFormat = "20060102-150405"
+
+// newRunID returns a unique sortable run id.
+func newRunID(now time.Time) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return now.Format(runIDTimeFormat) + "-" + hex.EncodeToString(b), nil
+}
+
+func newExport(cfg *config, clock runClock) (*export, error) {
+	return newExportClient(cfg, nil, clock)
+}
+
+// newExportClient creates the export using the client for Google APIs, the
+// authorized client is used if nil.
+func newExportClient(cfg *config, client *http.Client, clock runClock) (exp *export, err error) {
+	if err = os.MkdirAll(cfg.DataDir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %v", err)
+	}
+	unlock, err := lockDirWait(cfg.DataDir, time.Duration(cfg.LockWait)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock data dir: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = unlock()
+		}
+	}()
+
+	tempSpace.reset()
+	driveLookups.reset()
+	now := clock.now()
+	exp = &export{cfg: cfg, started: now, clock: clock, unlock: unlock}
+	if exp.id, err = clock.runID(now); err != nil {
+		return nil, fmt.Errorf("failed to generate run id: %v", err)
+	}
+	exp.dir = filepath.Join(cfg.DataDir, exp.id)
 	if err = os.MkdirAll(exp.dir, dirPerm); err != nil {
 		return nil, fmt.Errorf("failed to create export exportDir: %v", err)
 	}
+	if err = validDriveProperties(cfg.DriveProperties); err != nil {
+		return nil, err
+	}
+	if exp.db, err = openHistoryDB(cfg); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = exp.db.close()
+		}
+	}()
+	if err = exp.db.importRunHistory(cfg); err != nil {
+		return nil, fmt.Errorf("failed to import run history: %v", err)
+	}
+	info := runInfo{ID: exp.id, Started: now, PID: os.Getpid(), Version: toolVersion()}
 	exp.tasks = make(map[string]*task, len(cfg.Tasks))
 	for _, tcfg := range cfg.Tasks {
 		if _, ok := exp.tasks[tcfg.Name]; ok {
 			return nil, fmt.Errorf("invalid config: duplicated task %s", tcfg.Name)
 		}
-		t, err := newTask(cfg, tcfg, exp.dir)
+		t, err := newTask(cfg, tcfg, exp.dir, exp.db, clock)
 		if err != nil {
 			return nil, fmt.Errorf("failed to init task %s: %v", tcfg.Name, err)
 		}
 		exp.tasks[tcfg.Name] = t
+		info.Tasks = append(info.Tasks, tcfg.Name)
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(exp.dir, runInfoFile), b, filePerm); err != nil {
+		return nil, fmt.Errorf("failed to write run info: %v", err)
+	}
+	if client == nil {
+		if client, err = getGoogleClient(cfg); err != nil {
+			return nil, err
+		}
 	}
-	exp.fs, err = getDriveFilesService(cfg)
+	exp.fs, err = getDriveFilesService(cfg, client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get files service: %v", err)
 	}
+	exp.ss, err = getSheetsService(cfg, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sheets service: %v", err)
+	}
+	slog.Info("started run", "run", exp.id)
 	return exp, nil
 }
 
-func (exp *export) fetch() {
-	for name, t := range exp.tasks {
-		log.Printf("fetching files for task: %s\n", t.name)
-		if err := t.fetch(exp.fs); err != nil {
-			log.Printf("fail: %v\n", err)
-			delete(exp.tasks, name)
+// concurrency returns the number of tasks handled in parallel.
+func (exp *export) concurrency() int {
+	if exp.cfg.Concurrency > 0 {
+		return exp.cfg.Concurrency
+	}
+	return 1
+}
+
+// each calls fn for every task using up to concurrency goroutines.
+func (exp *export) each(fn func(t *task)) {
+	var g errgroup.Group
+	g.SetLimit(exp.concurrency())
+	for _, t := range exp.tasks {
+		t := t
+		g.Go(func() error {
+			fn(t)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+func (exp *export) fetch(ctx context.Context) {
+	var mu sync.Mutex
+	var failed []string
+	exp.each(func(t *task) {
+		t.log.Info("fetching files")
+		if err := t.fetch(ctx, exp.fs, exp.ss); err != nil {
+			t.log.Error("failed to fetch", "err", err)
+			mu.Lock()
+			failed = append(failed, t.name)
+			mu.Unlock()
 		} else {
-			log.Printf("success: %s -> %s\n", t.origin, t.source)
+			t.log.Info("fetched", "file", t.origin)
 		}
+	})
+	for _, name := range failed {
+		delete(exp.tasks, name)
 	}
 }
 
-func (exp *export) process() []taskResult {
+// process processes the fetched tasks, tasks stop after the current row
+// when ctx is done.
+func (exp *export) process(ctx context.Context) []taskResult {
+	var mu sync.Mutex
 	var results []taskResult
-	for _, t := range exp.tasks {
-		log.Printf("processing task: %s\n", t.name)
-		result := t.process(exp.fs)
-		results = append(results, result)
+	exp.each(func(t *task) {
+		t.log.Info("processing")
+		result := t.process(ctx, exp.fs)
 		if result.err != nil {
-			log.Printf("fail: %v\n", result.err)
+			t.log.Error("failed to process", "err", result.err)
 		}
-	}
+		mu.Lock()
+		results = append(results, result)
+		mu.Unlock()
+	})
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].name < results[j].name
+	})
 	return results
 }
 
-func (exp *export) upload() {
+// uploadAttempts is the number of attempts to write back the statuses of a
+// task, each resuming with the cells not written yet.
+const uploadAttempts = 3
+
+func (exp *export) upload(ctx context.Context) {
+	exp.each(func(t *task) {
+		t.log.Info("updating files")
+		for attempt := 1; ; attempt++ {
+			err := t.update(ctx)
+			if err == nil {
+				return
+			}
+			if attempt >= uploadAttempts || !isRetryable(err) {
+				t.log.Error("failed to update files", "err", err)
+				return
+			}
+			t.log.Warn("failed to update files, resuming", "attempt", attempt, "err", err)
+		}
+	})
+}
+
+// taskPending are the rows waiting to be handled by the task.
+type taskPending struct {
+	name string
+	rows []pendingRow
+	err  error
+}
+
+// pending counts rows waiting to be handled by the fetched tasks.
+func (exp *export) pending() []taskPending {
+	var result []taskPending
 	for _, t := range exp.tasks {
-		log.Printf("updating files for task: %s\n", t.name)
-		if err := t.update(exp.fs); err != nil {
-			log.Printf("fail: %v\n", err)
+		rows, err := t.pending()
+		result = append(result, taskPending{name: t.name, rows: rows, err: err})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// preview sends the task row rendered by previewing targets to the chat.
+func (exp *export) preview(ctx context.Context, name string, n int, chat string) error {
+	t, ok := exp.tasks[name]
+	if !ok {
+		return fmt.Errorf("task %s not found", name)
+	}
+	if err := t.fetch(ctx, exp.fs, exp.ss); err != nil {
+		return err
+	}
+	row, err := t.row(ctx, n)
+	if err != nil {
+		return err
+	}
+	sent := false
+	for _, tt := range t.targets {
+		if p, ok := tt.(previewer); ok && t.langs.routesRow(tt.ID(), row) {
+			if err = p.Preview(ctx, row, exp.fs, chat); err != nil {
+				return fmt.Errorf("failed to preview target %s: %v", tt.ID(), err)
+			}
+			sent = true
 		}
 	}
+	if !sent {
+		return fmt.Errorf("task %s has no targets supporting preview", name)
+	}
+	return nil
+}
+
+// approve marks the task row pending approval as approved in the sheet.
+func (exp *export) approve(ctx context.Context, name string, n int) error {
+	t, ok := exp.tasks[name]
+	if !ok {
+		return fmt.Errorf("task %s not found", name)
+	}
+	if t.approval == nil {
+		return fmt.Errorf("task %s has no approval configured", name)
+	}
+	if err := t.fetch(ctx, exp.fs, exp.ss); err != nil {
+		return err
+	}
+	if err := t.setField(n, t.approval.column, approvalPending, approvalApproved); err != nil {
+		return err
+	}
+	return t.update(ctx)
 }
 
 func (exp *export) clean() {
 	if err := os.RemoveAll(exp.dir); err != nil {
-		log.Print(err)
+		slog.Warn("failed to clean export dir", "err", err)
+	}
+}
+
+// close releases task resources and the data dir lock.
+func (exp *export) close() {
+	for _, t := range exp.tasks {
+		if err := t.close(); err != nil {
+			t.log.Warn("failed to close task", "err", err)
+		}
+	}
+	if err := exp.db.close(); err != nil {
+		slog.Warn("failed to close history db", "err", err)
+	}
+	if err := exp.unlock(); err != nil {
+		slog.Warn("failed to unlock data dir", "err", err)
 	}
 }
@@ -22,27 +22,35 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
 )
 
-func downloadDriveFile(fs *drive.FilesService, src, dst string) (string, error) {
-	return fetchDriveFile(fs, src, "", dst, "")
+func downloadDriveFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, dst string) (string, error) {
+	return fetchDriveFile(ctx, fs, sharedDrive, folder, src, "", dst, "")
 }
 
-func exportDriveFile(fs *drive.FilesService, src, srcMIME, dst, dstMIME string) (string, error) {
-	return fetchDriveFile(fs, src, srcMIME, dst, dstMIME)
+func exportDriveFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, srcMIME, dst, dstMIME string) (string, error) {
+	return fetchDriveFile(ctx, fs, sharedDrive, folder, src, srcMIME, dst, dstMIME)
 }
 
-func fetchDriveFile(fs *drive.FilesService, src, srcMIME, dst, dstMIME string) (string, error) {
-	id, err := getDriveFileId(fs, src, srcMIME)
+func fetchDriveFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, srcMIME, dst, dstMIME string) (string, error) {
+	id, err := getDriveFileId(ctx, fs, sharedDrive, folder, src, srcMIME)
 	if err != nil {
 		return "", err
 	}
-	rc, err := getDriveFileReadCloser(fs, id, dstMIME)
+	rc, err := getDriveFileReadCloser(ctx, fs, id, dstMIME)
 	if err != nil {
 		return "", err
 	}
@@ -60,65 +68,265 @@ func fetchDriveFile(fs *drive.FilesService, src, srcMIME, dst, dstMIME string) (
 	return id, nil
 }
 
-func getDriveFileId(fs *drive.FilesService, src, mime string) (string, error) {
-	q := "name = '" + src + "'"
-	if mime != "" {
-		q += "and mimeType = '" + mime + "'"
+const driveFolderMIME = "application/vnd.google-apps.folder"
+
+// errExportSizeLimit is returned when Drive refuses to export the file for
+// exceeding the export size limit (about 10 MB).
+var errExportSizeLimit = errors.New("file exceeds the Drive export size limit, set export_fallback or use the sheets source")
+
+// isExportSizeLimit reports whether the export failed for the file size.
+// Errors of media downloads carry the reason only in the response body.
+func isExportSizeLimit(err error) bool {
+	var ge *googleapi.Error
+	if !errors.As(err, &ge) || ge.Code != http.StatusForbidden {
+		return false
+	}
+	for _, e := range ge.Errors {
+		if e.Reason == "exportSizeLimitExceeded" {
+			return true
+		}
+	}
+	return strings.Contains(ge.Body, "exportSizeLimitExceeded")
+}
+
+// driveIncludeTrashed makes lookups match trashed files, to export them in
+// recovery scenarios.
+var driveIncludeTrashed bool
+
+// driveSharedDrives makes lookups of tasks without a shared drive search
+// all drives rather than My Drive only.
+var driveSharedDrives bool
+
+// setupDrive applies the Drive lookup options of the config.
+func setupDrive(cfg *config, clock runClock) {
+	driveIncludeTrashed = cfg.DriveIncludeTrashed
+	driveSharedDrives = cfg.DriveSharedDrives
+	driveMediaCache = newMediaCache(cfg, clock)
+}
+
+// driveQuote quotes the string for Drive search queries.
+func driveQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+var (
+	driveURLPathIdRe = regexp.MustCompile(`/d/([A-Za-z0-9_-]+)`)
+	driveIdRe        = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// driveIdPrefix marks file ids in task files and attachment cells, bare
+// values are always names, as long names can't be told from ids.
+const driveIdPrefix = "id:"
+
+// driveFileRef returns the file id if src is a Drive or Docs URL or a file
+// id with driveIdPrefix.
+func driveFileRef(src string) (string, bool) {
+	if strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") {
+		u, err := url.Parse(src)
+		if err != nil || !strings.HasSuffix(u.Host, ".google.com") {
+			return "", false
+		}
+		if m := driveURLPathIdRe.FindStringSubmatch(u.Path); m != nil {
+			return m[1], true
+		}
+		if id := u.Query().Get("id"); id != "" {
+			return id, true
+		}
+		return "", false
+	}
+	if id, ok := strings.CutPrefix(src, driveIdPrefix); ok && driveIdRe.MatchString(id) {
+		return id, true
+	}
+	return "", false
+}
+
+// driveFileName returns the name of the file referenced by the path, or
+// an empty string if it is referenced by id.
+func driveFileName(src string) string {
+	if _, ok := driveFileRef(src); ok {
+		return ""
+	}
+	return path.Base(src)
+}
+
+// getDriveFileId finds the file by name. The search is restricted to the
+// folder if set and to the shared drive if set, slash separated paths are
+// resolved through subfolders. Files referenced by id or URL are not
+// searched.
+func getDriveFileId(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, mime string) (string, error) {
+	if id, ok := driveFileRef(src); ok {
+		return id, nil
+	}
+	parts := strings.Split(src, "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if dir == "" {
+			continue
+		}
+		id, err := findDriveFile(ctx, fs, sharedDrive, folder, dir, driveFolderMIME)
+		if err != nil {
+			return "", fmt.Errorf("folder %s: %w", dir, err)
+		}
+		folder = id
+	}
+	return findDriveFile(ctx, fs, sharedDrive, folder, parts[len(parts)-1], mime)
+}
+
+// driveLookupKey identifies a file lookup by name.
+type driveLookupKey struct {
+	drive, folder, name, mime string
+}
+
+// driveLookups caches file ids found by name for the duration of a run, so
+// rows referencing the same media or folders don't list files again.
+var driveLookups = &driveLookupCache{}
+
+type driveLookupCache struct {
+	mu  sync.Mutex
+	ids map[driveLookupKey]string
+}
+
+// reset empties the cache at the start of a run.
+func (c *driveLookupCache) reset() {
+	c.mu.Lock()
+	c.ids = nil
+	c.mu.Unlock()
+}
+
+func (c *driveLookupCache) get(key driveLookupKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[key]
+	return id, ok
+}
+
+func (c *driveLookupCache) put(key driveLookupKey, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[driveLookupKey]string)
+	}
+	c.ids[key] = id
+}
+
+// findDriveFile returns the id of the file found by name in the shared
+// drive if set, found ids are cached, failed lookups are not.
+func findDriveFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, mime string) (string, error) {
+	key := driveLookupKey{drive: sharedDrive, folder: folder, name: src, mime: mime}
+	if id, ok := driveLookups.get(key); ok {
+		return id, nil
 	}
-	list, err := fs.List().Q(q).Do()
+	id, err := listDriveFile(ctx, fs, sharedDrive, folder, src, mime)
 	if err != nil {
 		return "", err
 	}
+	driveLookups.put(key, id)
+	return id, nil
+}
+
+func listDriveFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src, mime string) (string, error) {
+	q := "name = " + driveQuote(src)
+	if mime != "" {
+		q += " and mimeType = " + driveQuote(mime)
+	}
+	if folder != "" {
+		q += " and " + driveQuote(folder) + " in parents"
+	}
+	if !driveIncludeTrashed {
+		q += " and trashed = false"
+	}
+	call := fs.List().Q(q)
+	switch {
+	case sharedDrive != "":
+		call = call.SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Corpora("drive").DriveId(sharedDrive)
+	case driveSharedDrives:
+		call = call.SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Corpora("allDrives")
+	}
+	list, err := call.Context(ctx).Do()
+	if err != nil {
+		return "", classifyHTTPError(err)
+	}
 	if len(list.Files) != 1 {
 		if len(list.Files) != 0 {
-			log.Printf("failed to find file %s, candidates:\n", src)
+			candidates := make([]string, 0, len(list.Files))
 			for _, f := range list.Files {
-				log.Printf("%s\t%s\n", f.Id, f.Name)
+				candidates = append(candidates, f.Id+" "+f.Name)
 			}
+			slog.Warn("failed to find file, several candidates", "file", src, "candidates", candidates)
 		}
-		return "", errors.New("file not found")
+		return "", fmt.Errorf("file %w", errNotFound)
 	}
 	return list.Files[0].Id, nil
 }
 
-func getDriveFileReadCloser(fs *drive.FilesService, id string, mime string) (io.ReadCloser, error) {
+func getDriveFileReadCloser(ctx context.Context, fs *drive.FilesService, id string, mime string) (io.ReadCloser, error) {
 	var r *http.Response
 	var err error
 	if mime != "" {
-		r, err = fs.Export(id, mime).Download()
+		r, err = fs.Export(id, mime).Context(ctx).Download()
 	} else {
-		r, err = fs.Get(id).Download()
+		r, err = fs.Get(id).SupportsAllDrives(true).Context(ctx).Download()
 	}
 	if err != nil {
+		if isExportSizeLimit(err) {
+			return nil, fmt.Errorf("%w: %v", errExportSizeLimit, redactURLError(err))
+		}
+		return nil, classifyHTTPError(err)
+	}
+	rc, err := tempSpace.reader(id, r.Body, r.ContentLength)
+	if err != nil {
+		r.Body.Close()
 		return nil, err
 	}
-	return r.Body, nil
+	return rc, nil
 }
 
-func getDriveFilesService(cfg *config) (*drive.FilesService, error) {
-	ctx := context.Background()
+// getGoogleClient returns the authorized client for Google APIs.
+func getGoogleClient(cfg *config) (*http.Client, error) {
 	b, err := os.ReadFile(cfg.GoogleCredentialsFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read client secret file: %v", err)
 	}
 
 	// If modifying these scopes, delete your previously saved token.json.
+	// The Drive scope also grants access to the Sheets API.
 	auth, err := google.ConfigFromJSON(b, drive.DriveScope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse client secret file to config: %v", err)
 	}
+	if cfg.GoogleTokenURL != "" {
+		auth.Endpoint.TokenURL = cfg.GoogleTokenURL
+	}
 	client, err := getClient(auth, cfg.GoogleTokenFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize client: %v", err)
 	}
+	return client, nil
+}
 
-	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+func getDriveFilesService(cfg *config, client *http.Client) (*drive.FilesService, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if cfg.GoogleDriveEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.GoogleDriveEndpoint))
+	}
+	srv, err := drive.NewService(context.Background(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %v", err)
 	}
 	return srv.Files, nil
 }
 
+func getSheetsService(cfg *config, client *http.Client) (*sheets.SpreadsheetsService, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if cfg.GoogleSheetsEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.GoogleSheetsEndpoint))
+	}
+	srv, err := sheets.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	return srv.Spreadsheets, nil
+}
+
 // Retrieve a token, saves the token, then returns the generated client.
 func getClient(auth *oauth2.Config, file string) (*http.Client, error) {
 	// The file token.json stores the user's access and refresh tokens, and is
@@ -133,11 +341,16 @@ func getClient(auth *oauth2.Config, file string) (*http.Client, error) {
 			return nil, err
 		}
 	}
-	return auth.Client(context.Background(), tok), nil
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	return auth.Client(ctx, tok), nil
 }
 
 // Request a token from the web, then returns the retrieved token.
 func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	if !isInteractive() {
+		return nil, errors.New("no valid google token and stdin is not a terminal: " +
+			"authorize once interactively and mount the token file (google_token_file)")
+	}
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
@@ -147,13 +360,23 @@ func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 		return nil, fmt.Errorf("failed to read authorization code: %v", err)
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	tok, err := config.Exchange(ctx, authCode)
 	if err != nil {
-		log.Fatalf("failed to retrieve token: %v", err)
+		fatal("failed to retrieve token", err)
 	}
 	return tok, nil
 }
 
+// isInteractive reports whether stdin is attached to a terminal.
+func isInteractive() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 // Retrieves a token from a local file.
 func tokenFromFile(file string) (*oauth2.Token, error) {
 	f, err := os.Open(file)
@@ -0,0 +1,111 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestListDriveFileSharedDrives(t *testing.T) {
+	tests := []struct {
+		name         string
+		sharedDrives bool
+		sharedDrive  string
+		want         url.Values
+	}{
+		{"my drive", false, "", url.Values{"corpora": nil, "driveId": nil, "includeItemsFromAllDrives": nil}},
+		{"all drives", true, "", url.Values{"corpora": {"allDrives"}, "driveId": nil, "includeItemsFromAllDrives": {"true"}}},
+		{"task drive", false, "d1", url.Values{"corpora": {"drive"}, "driveId": {"d1"}, "includeItemsFromAllDrives": {"true"}}},
+		{"task drive with all drives", true, "d1", url.Values{"corpora": {"drive"}, "driveId": {"d1"}, "includeItemsFromAllDrives": {"true"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query url.Values
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"files":[{"id":"f1","name":"sheet"}]}`))
+			}))
+			defer srv.Close()
+			srvc, err := drive.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			driveSharedDrives = tt.sharedDrives
+			defer func() { driveSharedDrives = false }()
+
+			id, err := listDriveFile(context.Background(), srvc.Files, tt.sharedDrive, "folder", "sheet", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "f1" {
+				t.Errorf("id %s", id)
+			}
+			for key, want := range tt.want {
+				if got := query[key]; len(got) != len(want) || len(want) != 0 && got[0] != want[0] {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestListDriveFileCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request of a cancelled context sent")
+	}))
+	defer srv.Close()
+	srvc, err := drive.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = listDriveFile(ctx, srvc.Files, "", "folder", "sheet", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want cancelled", err)
+	}
+}
+
+func TestDriveFileRef(t *testing.T) {
+	tests := []struct {
+		src string
+		id  string
+		ok  bool
+	}{
+		{"https://docs.google.com/spreadsheets/d/1AbC_dEf-2gHiJkLmNoPqRsTuVwXyZ/edit#gid=0", "1AbC_dEf-2gHiJkLmNoPqRsTuVwXyZ", true},
+		{"https://drive.google.com/open?id=1AbCdEf2", "1AbCdEf2", true},
+		{"https://example.com/d/1AbCdEf2", "", false},
+		{"id:1AbC_dEf-2gHiJkLmNoPqRsTuVwXyZ", "1AbC_dEf-2gHiJkLmNoPqRsTuVwXyZ", true},
+		{"id:bad/id", "", false},
+		{"1AbC_dEf-2gHiJkLmNoPqRsTuVwXyZ", "", false},
+		{"Podcast_Episodes_2024_Master_List", "", false},
+		{"media/cover.jpg", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			id, ok := driveFileRef(tt.src)
+			if id != tt.id || ok != tt.ok {
+				t.Errorf("got %q %v, want %q %v", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
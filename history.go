@@ -0,0 +1,140 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultRunHistoryFile = "history.json"
+	defaultRunHistorySize = 100
+)
+
+// runHistory is the view of the recent runs of the history db. The JSON
+// history file of earlier versions is imported by the next run.
+type runHistory struct {
+	Runs []*runRecord `json:"runs"`
+}
+
+type runRecord struct {
+	ID       string          `json:"id"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Tasks    []runTaskRecord `json:"tasks"`
+}
+
+type runTaskRecord struct {
+	Name      string                     `json:"name"`
+	Total     int                        `json:"total"`
+	Done      int                        `json:"done"`
+	Failed    int                        `json:"failed"`
+	Pending   int                        `json:"pending"`
+	Scheduled int                        `json:"scheduled,omitempty"`
+	Skipped   int                        `json:"skipped,omitempty"`
+	Warnings  []string                   `json:"warnings,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+	Targets   map[string]runTargetRecord `json:"targets,omitempty"`
+}
+
+type runTargetRecord struct {
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+}
+
+var runHistorySchema = &stateSchema{
+	name: "history",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+		noMigration, // 2: skipped rows
+	},
+}
+
+func newTaskRecord(result taskResult) runTaskRecord {
+	rec := runTaskRecord{
+		Name:      result.name,
+		Total:     result.total,
+		Done:      result.done,
+		Failed:    result.failed,
+		Pending:   result.pending,
+		Scheduled: result.scheduled,
+		Skipped:   result.skipped,
+		Warnings:  result.warnings,
+	}
+	if result.err != nil {
+		rec.Error = redactSecrets(result.err.Error())
+	}
+	if len(result.targets) != 0 {
+		rec.Targets = make(map[string]runTargetRecord, len(result.targets))
+		for id, tr := range result.targets {
+			rec.Targets[id] = runTargetRecord{Done: tr.done, Failed: tr.failed}
+		}
+	}
+	return rec
+}
+
+func runHistoryFile(cfg *config) string {
+	if cfg.HistoryFile != "" {
+		return cfg.HistoryFile
+	}
+	return filepath.Join(cfg.DataDir, defaultRunHistoryFile)
+}
+
+// loadRunHistory reads the recent runs from the history db.
+func loadRunHistory(cfg *config) (*runHistory, error) {
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.close()
+	size := cfg.HistorySize
+	if size <= 0 {
+		size = defaultRunHistorySize
+	}
+	runs, err := db.runs(size)
+	if err != nil {
+		return nil, err
+	}
+	return &runHistory{Runs: runs}, nil
+}
+
+// importRunHistory moves the runs of the JSON history file into the db.
+func (h *historyDB) importRunHistory(cfg *config) error {
+	file := runHistoryFile(cfg)
+	var legacy runHistory
+	if err := runHistorySchema.read(file, &legacy); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, run := range legacy.Runs {
+		if err := h.insertRun(run, ""); err != nil {
+			return err
+		}
+	}
+	return os.Remove(file)
+}
+
+// get returns the run with the id or nil.
+func (h *runHistory) get(id string) *runRecord {
+	for _, r := range h.Runs {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"time"
+)
+
+const defaultHistoryDBFile = "history.db"
+
+// historyDB is the SQLite database of the runs, their row outcomes, the
+// record ids published by rows and the inserts not written back to sheets
+// yet. The database is shared by runs, queries and the API, concurrent
+// access is serialized by SQLite.
+type historyDB struct {
+	db *sql.DB
+}
+
+// historyDBMigrations create and upgrade the schema, the schema version is
+// kept in user_version.
+var historyDBMigrations = []string{
+	// 1: initial version
+	`CREATE TABLE runs (
+		id TEXT PRIMARY KEY,
+		started INTEGER NOT NULL,
+		finished INTEGER NOT NULL,
+		version TEXT NOT NULL,
+		tasks TEXT NOT NULL
+	);
+	CREATE TABLE row_outcomes (
+		run_id TEXT NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+		task TEXT NOT NULL,
+		row INTEGER NOT NULL,
+		target TEXT NOT NULL,
+		op TEXT NOT NULL,
+		status TEXT NOT NULL,
+		record_id TEXT NOT NULL,
+		variant TEXT NOT NULL,
+		error TEXT NOT NULL,
+		class TEXT NOT NULL,
+		duration INTEGER NOT NULL
+	);
+	CREATE INDEX row_outcomes_row ON row_outcomes(task, row);
+	CREATE TABLE records (
+		task TEXT NOT NULL,
+		target TEXT NOT NULL,
+		row INTEGER NOT NULL,
+		record_id TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		PRIMARY KEY (task, target, row)
+	);
+	CREATE TABLE publish_journal (
+		task TEXT NOT NULL,
+		target TEXT NOT NULL,
+		row_key TEXT NOT NULL,
+		record_id TEXT NOT NULL,
+		time INTEGER NOT NULL,
+		PRIMARY KEY (task, target, row_key)
+	);`,
+}
+
+func historyDBFile(cfg *config) string {
+	if cfg.HistoryDB != "" {
+		return cfg.HistoryDB
+	}
+	return filepath.Join(cfg.DataDir, defaultHistoryDBFile)
+}
+
+// openHistoryDB opens the database creating or upgrading its schema.
+// Commits are synced to disk, so journaled inserts survive crashes.
+func openHistoryDB(cfg *config) (*historyDB, error) {
+	dsn := "file:" + historyDBFile(cfg) +
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history db: %v", err)
+	}
+	h := &historyDB{db: db}
+	if err = h.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate history db: %v", err)
+	}
+	return h, nil
+}
+
+func (h *historyDB) migrate() error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var version int
+	if err = tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(historyDBMigrations) {
+		return fmt.Errorf("schema version %d is newer than supported %d", version, len(historyDBMigrations))
+	}
+	if version == len(historyDBMigrations) {
+		return nil
+	}
+	for _, m := range historyDBMigrations[version:] {
+		if _, err = tx.Exec(m); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(historyDBMigrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *historyDB) close() error {
+	return h.db.Close()
+}
+
+const insertRunSQL = "INSERT OR REPLACE INTO runs (id, started, finished, version, tasks) VALUES (?, ?, ?, ?, ?)"
+
+// insertRun stores the run without row outcomes.
+func (h *historyDB) insertRun(run *runRecord, version string) error {
+	b, err := json.Marshal(run.Tasks)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.Exec(insertRunSQL, run.ID, run.Started.UnixNano(), run.Finished.UnixNano(), version, string(b))
+	return err
+}
+
+// addRun stores the run report with its row outcomes and the record ids of
+// the rows, and removes runs beyond the size most recent ones.
+func (h *historyDB) addRun(r *runReport, size int) error {
+	tasks := make([]runTaskRecord, 0, len(r.Tasks))
+	for _, t := range r.Tasks {
+		tasks = append(tasks, t.runTaskRecord)
+	}
+	b, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(insertRunSQL, r.ID, r.Started.UnixNano(), r.Finished.UnixNano(), r.Version, string(b)); err != nil {
+		return err
+	}
+	for _, t := range r.Tasks {
+		for _, o := range t.Rows {
+			if _, err = tx.Exec("INSERT INTO row_outcomes (run_id, task, row, target, op, status, record_id, variant, error, class, duration) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				r.ID, t.Name, o.Row, o.Target, o.Op, o.Status, o.RecordId, o.Variant, o.Error, o.Class, int64(o.Duration)); err != nil {
+				return err
+			}
+			if o.Status != "ok" {
+				continue
+			}
+			if o.Op == rowOpDelete {
+				_, err = tx.Exec("DELETE FROM records WHERE task = ? AND target = ? AND row = ?", t.Name, o.Target, o.Row)
+			} else {
+				_, err = tx.Exec("INSERT OR REPLACE INTO records (task, target, row, record_id, run_id) VALUES (?, ?, ?, ?, ?)",
+					t.Name, o.Target, o.Row, o.RecordId, r.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if size <= 0 {
+		size = defaultRunHistorySize
+	}
+	if _, err = tx.Exec("DELETE FROM runs WHERE id NOT IN (SELECT id FROM runs ORDER BY started DESC LIMIT ?)", size); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// runs returns the limit most recent runs.
+func (h *historyDB) runs(limit int) ([]*runRecord, error) {
+	rows, err := h.db.Query("SELECT id, started, finished, tasks FROM runs ORDER BY started DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []*runRecord{}
+	for rows.Next() {
+		var started, finished int64
+		var tasks string
+		run := &runRecord{}
+		if err = rows.Scan(&run.ID, &started, &finished, &tasks); err != nil {
+			return nil, err
+		}
+		run.Started, run.Finished = time.Unix(0, started), time.Unix(0, finished)
+		if err = json.Unmarshal([]byte(tasks), &run.Tasks); err != nil {
+			return nil, fmt.Errorf("invalid tasks of run %s: %v", run.ID, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// rowHistory returns the operations on the task row, most recent first.
+func (h *historyDB) rowHistory(task string, row int) ([]rowHistoryEntry, error) {
+	rows, err := h.db.Query(`SELECT o.run_id, r.started, o.row, o.target, o.op, o.status, o.record_id, o.variant, o.error, o.class, o.duration
+		FROM row_outcomes o JOIN runs r ON r.id = o.run_id
+		WHERE o.task = ? AND o.row = ? ORDER BY r.started DESC, o.rowid`, task, row)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []rowHistoryEntry{}
+	for rows.Next() {
+		var e rowHistoryEntry
+		var started, duration int64
+		if err = rows.Scan(&e.Run, &started, &e.Row, &e.Target, &e.Op, &e.Status, &e.RecordId, &e.Variant, &e.Error, &e.Class, &duration); err != nil {
+			return nil, err
+		}
+		e.Started, e.Duration = time.Unix(0, started), time.Duration(duration)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// records returns the record ids the task row was last published with, by
+// target.
+func (h *historyDB) records(task string, row int) (map[string]string, error) {
+	rows, err := h.db.Query("SELECT target, record_id FROM records WHERE task = ? AND row = ?", task, row)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make(map[string]string)
+	for rows.Next() {
+		var target, id string
+		if err = rows.Scan(&target, &id); err != nil {
+			return nil, err
+		}
+		records[target] = id
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,272 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testHistoryDB(t *testing.T) (*config, *historyDB) {
+	t.Helper()
+	cfg := &config{DataDir: t.TempDir()}
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.close() })
+	return cfg, db
+}
+
+func testRunReport(id string, started time.Time, rows ...rowOutcome) *runReport {
+	return &runReport{
+		ID:       id,
+		Started:  started,
+		Finished: started.Add(time.Minute),
+		Tasks:    []taskReport{{runTaskRecord: runTaskRecord{Name: "t", Total: len(rows)}, Rows: rows}},
+	}
+}
+
+func TestHistoryDBMigrate(t *testing.T) {
+	cfg, db := testHistoryDB(t)
+	if err := db.close(); err != nil {
+		t.Fatal(err)
+	}
+	// reopening an up to date db is a no-op
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err = db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(historyDBMigrations) {
+		t.Errorf("user_version = %d, want %d", version, len(historyDBMigrations))
+	}
+	if _, err = db.db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	db.close()
+	if _, err = openHistoryDB(cfg); err == nil {
+		t.Error("newer schema opened without error")
+	}
+}
+
+func TestHistoryDBRuns(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		size    int
+		runs    []*runReport
+		want    []string
+		records map[string]string
+		history []string
+	}{
+		{
+			name: "record ids follow the last ok operation",
+			runs: []*runReport{
+				testRunReport("r1", base, rowOutcome{Row: 2, Target: "tg", Op: rowOpInsert, Status: "ok", RecordId: "1"}),
+				testRunReport("r2", base.Add(time.Hour),
+					rowOutcome{Row: 2, Target: "tg", Op: rowOpUpdate, Status: "failed", RecordId: "2", Class: errorClassTransient}),
+			},
+			want:    []string{"r2", "r1"},
+			records: map[string]string{"tg": "1"},
+			history: []string{"r2:update:failed", "r1:insert:ok"},
+		},
+		{
+			name: "deletes remove record ids",
+			runs: []*runReport{
+				testRunReport("r1", base,
+					rowOutcome{Row: 2, Target: "tg", Op: rowOpInsert, Status: "ok", RecordId: "1"},
+					rowOutcome{Row: 2, Target: "rss", Op: rowOpInsert, Status: "ok", RecordId: "a"}),
+				testRunReport("r2", base.Add(time.Hour), rowOutcome{Row: 2, Target: "tg", Op: rowOpDelete, Status: "ok"}),
+			},
+			want:    []string{"r2", "r1"},
+			records: map[string]string{"rss": "a"},
+			history: []string{"r2:delete:ok", "r1:insert:ok", "r1:insert:ok"},
+		},
+		{
+			name: "old runs are pruned with their outcomes",
+			size: 2,
+			runs: []*runReport{
+				testRunReport("r1", base, rowOutcome{Row: 2, Target: "tg", Op: rowOpInsert, Status: "ok", RecordId: "1"}),
+				testRunReport("r2", base.Add(time.Hour)),
+				testRunReport("r3", base.Add(2*time.Hour)),
+			},
+			want:    []string{"r3", "r2"},
+			records: map[string]string{"tg": "1"},
+			history: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, db := testHistoryDB(t)
+			for _, r := range tt.runs {
+				if err := db.addRun(r, tt.size); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runs, err := db.runs(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, r := range runs {
+				ids = append(ids, r.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("runs = %v, want %v", ids, tt.want)
+			}
+			records, err := db.records("t", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, tt.records) {
+				t.Errorf("records = %v, want %v", records, tt.records)
+			}
+			entries, err := db.rowHistory("t", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history := []string{}
+			for _, e := range entries {
+				history = append(history, e.Run+":"+e.Op+":"+e.Status)
+			}
+			if !reflect.DeepEqual(history, tt.history) {
+				t.Errorf("history = %v, want %v", history, tt.history)
+			}
+		})
+	}
+}
+
+func TestHistoryDBRunRecord(t *testing.T) {
+	_, db := testHistoryDB(t)
+	started := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	r := testRunReport("r1", started)
+	r.Tasks[0].Done, r.Tasks[0].Error = 3, "failed"
+	if err := db.addRun(r, 0); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.runs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &runRecord{ID: "r1", Started: started, Finished: r.Finished, Tasks: []runTaskRecord{r.Tasks[0].runTaskRecord}}
+	if len(runs) != 1 || !runs[0].Started.Equal(want.Started) || !runs[0].Finished.Equal(want.Finished) ||
+		!reflect.DeepEqual(runs[0].Tasks, want.Tasks) {
+		t.Errorf("runs = %+v, want %+v", runs, want)
+	}
+}
+
+func TestImportRunHistory(t *testing.T) {
+	cfg, db := testHistoryDB(t)
+	started := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	legacy := &runHistory{Runs: []*runRecord{
+		{ID: "r1", Started: started, Finished: started, Tasks: []runTaskRecord{{Name: "t", Done: 1}}},
+	}}
+	file := runHistoryFile(cfg)
+	if err := runHistorySchema.write(file, file+".tmp", legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.importRunHistory(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("history file not removed: %v", err)
+	}
+	h, err := loadRunHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Runs) != 1 || h.Runs[0].ID != "r1" || h.Runs[0].Tasks[0].Done != 1 {
+		t.Errorf("runs = %+v", h.Runs)
+	}
+	// nothing left to import
+	if err = db.importRunHistory(cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPublishJournal(t *testing.T) {
+	cfg, db := testHistoryDB(t)
+	clock := fixedClock(time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC))
+	j := newPublishJournal(cfg, db, "t", clock)
+	other := newPublishJournal(cfg, db, "o", clock)
+	if err := j.add("tg", "2:h", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.add("tg", "2:h", "20"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		j      *publishJournal
+		target string
+		row    string
+		id     string
+		ok     bool
+	}{
+		{j, "tg", "2:h", "10", true},
+		{j, "tg", "2:x", "", false},
+		{j, "rss", "2:h", "", false},
+		{other, "tg", "2:h", "20", true},
+	}
+	for _, tt := range tests {
+		id, ok, err := tt.j.published(tt.target, tt.row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("published(%s, %s) of %s = %q, %v, want %q, %v", tt.target, tt.row, tt.j.task, id, ok, tt.id, tt.ok)
+		}
+	}
+	if err := j.clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := j.published("tg", "2:h"); ok {
+		t.Error("entry of the cleared task is left")
+	}
+	if _, ok, _ := other.published("tg", "2:h"); !ok {
+		t.Error("entry of another task is cleared")
+	}
+}
+
+func TestPublishJournalLegacy(t *testing.T) {
+	cfg, db := testHistoryDB(t)
+	j := newPublishJournal(cfg, db, "t", systemClock())
+	if err := os.MkdirAll(filepath.Dir(j.legacy), dirPerm); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"time":"2023-01-01T10:00:00Z","target":"tg","row":"2:h","record_id":"10"}
+{"time":"2023-01-01T10:00:01Z","target":"tg","row":"3:h","rec`
+	if err := os.WriteFile(j.legacy, []byte(data), filePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.load(); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok, _ := j.published("tg", "2:h"); !ok || id != "10" {
+		t.Errorf("published = %q, %v, want 10, true", id, ok)
+	}
+	if _, ok, _ := j.published("tg", "3:h"); ok {
+		t.Error("cut line imported")
+	}
+	if _, err := os.Stat(j.legacy); !os.IsNotExist(err) {
+		t.Errorf("journal file not removed: %v", err)
+	}
+}
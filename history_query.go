@@ -0,0 +1,101 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// writeHistory writes the recent runs.
+func writeHistory(w io.Writer, cfg *config, asJSON bool) error {
+	h, err := loadRunHistory(cfg)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(h.Runs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "run\tstarted\tduration\ttask\trows\tdone\tfailed\tpending\t")
+	for _, run := range h.Runs {
+		started := run.Started.Format(time.DateTime)
+		duration := run.Finished.Sub(run.Started).Round(time.Second)
+		for _, t := range run.Tasks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t", run.ID, started, duration, t.Name, t.Total, t.Done, t.Failed, t.Pending)
+			if t.Error != "" {
+				fmt.Fprintf(tw, "error: %s", t.Error)
+			}
+			fmt.Fprintln(tw)
+		}
+	}
+	return tw.Flush()
+}
+
+// rowHistoryEntry is an operation on the row in a run of the history.
+type rowHistoryEntry struct {
+	Run     string    `json:"run"`
+	Started time.Time `json:"started"`
+	rowOutcome
+}
+
+// parseRowRef parses the "task:row" reference.
+func parseRowRef(ref string) (string, int, error) {
+	name, row, ok := strings.Cut(ref, ":")
+	var n int
+	if ok {
+		_, err := fmt.Sscan(row, &n)
+		ok = err == nil && n > 1
+	}
+	if !ok || name == "" {
+		return "", 0, fmt.Errorf("invalid row reference %s, task:row expected", ref)
+	}
+	return name, n, nil
+}
+
+// writeRowHistory writes the operations on the task row of the runs in the
+// history, most recent first.
+func writeRowHistory(w io.Writer, cfg *config, task string, row int, asJSON bool) error {
+	db, err := openHistoryDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	entries, err := db.rowHistory(task, row)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "run\tstarted\ttarget\top\tstatus\trecord_id\t")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t", e.Run, e.Started.Format(time.DateTime), e.Target, e.Op, e.Status, e.RecordId)
+		if e.Error != "" {
+			fmt.Fprintf(tw, "%s: %s", e.Class, e.Error)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
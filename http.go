@@ -0,0 +1,277 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+var (
+	// httpClient is used for all outgoing requests.
+	httpClient = http.DefaultClient
+	// telegramAPIURL is the Telegram Bot API base URL.
+	telegramAPIURL = defaultTelegramAPIURL
+)
+
+// userAgentTransport sets the User-Agent header of outgoing requests.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// Default HTTP timeouts, requests have no overall timeout by default, so
+// large uploads are not interrupted.
+const (
+	defaultConnectTimeout        = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 2 * time.Minute
+	defaultIdleConnTimeout       = 90 * time.Second
+)
+
+// setupHTTP applies network related config options. Outgoing requests are
+// cancelled when ctx is done.
+func setupHTTP(ctx context.Context, cfg *config) {
+	if cfg.TelegramAPIURL != "" {
+		telegramAPIURL = strings.TrimRight(cfg.TelegramAPIURL, "/")
+	}
+	timeouts := httpTimeouts(cfg)
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.DialContext = (&net.Dialer{Timeout: timeouts.connect, KeepAlive: 30 * time.Second}).DialContext
+	base.TLSHandshakeTimeout = timeouts.tlsHandshake
+	base.ResponseHeaderTimeout = timeouts.responseHeader
+	base.IdleConnTimeout = timeouts.idle
+	var transport http.RoundTripper = &contextTransport{
+		base:    newRetryTransport(base, cfg.Retry),
+		ctx:     ctx,
+		timeout: timeouts.request,
+	}
+	if cfg.UserAgent != "" {
+		transport = &userAgentTransport{base: transport, userAgent: cfg.UserAgent}
+	}
+	httpClient = &http.Client{Transport: transport}
+}
+
+type timeouts struct {
+	connect        time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	idle           time.Duration
+	request        time.Duration
+}
+
+func httpTimeouts(cfg *config) timeouts {
+	t := timeouts{
+		connect:        defaultConnectTimeout,
+		tlsHandshake:   defaultTLSHandshakeTimeout,
+		responseHeader: defaultResponseHeaderTimeout,
+		idle:           defaultIdleConnTimeout,
+	}
+	if c := cfg.HTTPTimeouts; c != nil {
+		for _, v := range []struct {
+			seconds int
+			d       *time.Duration
+		}{
+			{c.Connect, &t.connect},
+			{c.TLSHandshake, &t.tlsHandshake},
+			{c.ResponseHeader, &t.responseHeader},
+			{c.Idle, &t.idle},
+			{c.Request, &t.request},
+		} {
+			if v.seconds > 0 {
+				*v.d = time.Duration(v.seconds) * time.Second
+			}
+		}
+	}
+	// bot long polling responds when the poll timeout expires
+	if poll := time.Duration(cfg.BotPollTimeout)*time.Second + defaultBotPollTimeout; t.responseHeader < poll {
+		t.responseHeader = poll
+	}
+	return t
+}
+
+// contextTransport binds requests to the context, so they are cancelled
+// when it is done, and applies the request timeout if set. The timeout
+// covers retries and reading the response body.
+type contextTransport struct {
+	base    http.RoundTripper
+	ctx     context.Context
+	timeout time.Duration
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	stop := context.AfterFunc(t.ctx, cancel)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		stop()
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: func() {
+		stop()
+		cancel()
+	}}
+	return resp, nil
+}
+
+// cancelBody releases the request context when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// multipartBody is a multipart form request body streamed through a pipe
+// as it is written, so uploads are not held in memory.
+type multipartBody struct {
+	boundary string
+	write    func(w *multipart.Writer) error
+	mu       sync.Mutex
+	err      error
+	// last is the pipe of the previous write, done is closed when the
+	// write returns.
+	last *io.PipeReader
+	done chan struct{}
+	// header is added to the requests.
+	header http.Header
+}
+
+func newMultipartBody(write func(w *multipart.Writer) error) *multipartBody {
+	return &multipartBody{boundary: multipart.NewWriter(io.Discard).Boundary(), write: write}
+}
+
+func (b *multipartBody) contentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+// open starts writing the form, each call writes it again after the
+// previous write is stopped, so the writes don't share the sources.
+func (b *multipartBody) open() (io.ReadCloser, error) {
+	if b.last != nil {
+		b.last.Close()
+		<-b.done
+	}
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	if err := w.SetBoundary(b.boundary); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	b.last, b.done = pr, done
+	go func() {
+		defer close(done)
+		err := b.write(w)
+		if err == nil {
+			err = w.Close()
+		}
+		// the pipe is closed by the transport if the request failed
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			b.mu.Lock()
+			b.err = err
+			b.mu.Unlock()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// writeFormFile writes the file as the multipart form field.
+func writeFormFile(w *multipart.Writer, field, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := w.CreateFormFile(field, filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+// writeErr returns the error of a failed write. A request failed by the
+// write has it set, it is set before the pipe is closed.
+func (b *multipartBody) writeErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// post sends the form, the request is retryable if replay is set and the
+// form can be written again.
+func (b *multipartBody) post(ctx context.Context, url string, replay bool) (*http.Response, error) {
+	body, err := b.open()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	for key, values := range b.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", b.contentType())
+	if replay {
+		req.GetBody = b.open
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if werr := b.writeErr(); werr != nil {
+			return nil, werr
+		}
+		return nil, classifyHTTPError(err)
+	}
+	return resp, nil
+}
+
+// telegramMethodURL returns the Bot API URL of the method.
+func telegramMethodURL(token, method string) string {
+	return telegramAPIURL + "/bot" + token + "/" + method
+}
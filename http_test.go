@@ -0,0 +1,102 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ctxRoundTripper records the context of the request.
+type ctxRoundTripper struct {
+	ctx context.Context
+}
+
+func (t *ctxRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.ctx = req.Context()
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+}
+
+func TestContextTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  string
+	}{
+		{"body close releases the request", 0, "close"},
+		{"body close releases the request with timeout", time.Hour, "close"},
+		{"run abort cancels the request", 0, "abort"},
+		{"request context cancels the request", time.Hour, "request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abort, stop := context.WithCancel(context.Background())
+			defer stop()
+			rctx, rcancel := context.WithCancel(context.Background())
+			defer rcancel()
+			base := &ctxRoundTripper{}
+			ct := &contextTransport{base: base, ctx: abort, timeout: tt.timeout}
+			req, err := http.NewRequestWithContext(rctx, http.MethodGet, "http://example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := ct.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if base.ctx.Err() != nil {
+				t.Fatal("request cancelled before the body is closed")
+			}
+			switch tt.cancel {
+			case "close":
+				resp.Body.Close()
+			case "abort":
+				stop()
+			case "request":
+				rcancel()
+			}
+			select {
+			case <-base.ctx.Done():
+			case <-time.After(time.Second):
+				t.Error("request not cancelled")
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestTelegramCallContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer srv.Close()
+	telegramAPIURL = srv.URL
+	defer func() { telegramAPIURL = defaultTelegramAPIURL }()
+
+	if id, err := telegramSendMessage(context.Background(), "token", "chat", "text", nil); err != nil || id != "1" {
+		t.Fatalf("id = %s, err = %v", id, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := telegramSendMessage(ctx, "token", "chat", "text", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context canceled", err)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const hugoTargetType = "hugo"
+
+const (
+	hugoPageFile         = "index.md"
+	defaultHugoShortcode = "audio"
+)
+
+// hugoTarget writes a page bundle per row into the content section of a
+// Hugo site: index.md with YAML front matter and the row files next to it.
+// The record id is the bundle directory name used as the page slug.
+type hugoTarget struct {
+	taskDir     string
+	folder      string
+	sharedDrive string
+	name        string
+	dir         string
+	shortcode   string
+	runTime     time.Time
+}
+
+func newHugoTarget(cfg *targetConfig, tdir, sharedDrive, folder string, clock runClock) (target, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("invalid config: content dir not set")
+	}
+	dir := filepath.Join(cfg.Dir, cfg.Catalog)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create content directory: %v", err)
+	}
+	shortcode := cfg.Shortcode
+	if shortcode == "" {
+		shortcode = defaultHugoShortcode
+	}
+	return &hugoTarget{
+		taskDir:     tdir,
+		folder:      folder,
+		sharedDrive: sharedDrive,
+		name:        cfg.Name,
+		dir:         dir,
+		shortcode:   shortcode,
+		runTime:     clock.now(),
+	}, nil
+}
+
+func (ht *hugoTarget) ID() string {
+	return hugoTargetType + "_" + ht.name
+}
+
+func (ht *hugoTarget) Type() string {
+	return hugoTargetType
+}
+
+func (ht *hugoTarget) Name() string {
+	return ht.name
+}
+
+// yamlString quotes the string for YAML front matter, Go quoted strings
+// are valid YAML double quoted scalars.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+// page renders index.md of the row, file names are relative to the bundle.
+func (ht *hugoTarget) page(slug string, row map[string]string, date time.Time, audio, image string) ([]byte, error) {
+	item, err := newCatalogItem(slug, copyRowAny(row), date)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	fmt.Fprintf(&buf, "title: %s\n", yamlString(item.Title))
+	fmt.Fprintf(&buf, "date: %s\n", date.Format(time.RFC3339))
+	fmt.Fprintf(&buf, "slug: %s\n", yamlString(slug))
+	if len(item.Tags) != 0 {
+		buf.WriteString("tags:\n")
+		for _, tag := range item.Tags {
+			fmt.Fprintf(&buf, "  - %s\n", yamlString(tag))
+		}
+	}
+	if item.Series != "" {
+		fmt.Fprintf(&buf, "series: %s\n", yamlString(item.Series))
+	}
+	if item.Episode != 0 {
+		fmt.Fprintf(&buf, "episode: %d\n", item.Episode)
+	}
+	if item.Duration != 0 {
+		fmt.Fprintf(&buf, "duration: %d\n", int(item.Duration/time.Second))
+	}
+	if audio != "" {
+		fmt.Fprintf(&buf, "audio: %s\n", yamlString(audio))
+	}
+	if image != "" {
+		fmt.Fprintf(&buf, "image: %s\n", yamlString(image))
+		if row["alt"] != "" {
+			fmt.Fprintf(&buf, "image_alt: %s\n", yamlString(row["alt"]))
+		}
+	}
+	buf.WriteString("---\n\n")
+	if text := strings.TrimSpace(row["text"]); text != "" {
+		buf.WriteString(text + "\n")
+	}
+	if audio != "" {
+		fmt.Fprintf(&buf, "\n{{< %s src=%s >}}\n", ht.shortcode, yamlString(audio))
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBundle fetches the row files into bdir and writes the page.
+func (ht *hugoTarget) writeBundle(ctx context.Context, bdir, slug string, row map[string]string, date time.Time, fs *drive.FilesService) error {
+	if row["title"] == "" {
+		return fmt.Errorf("%w: no title", errValidation)
+	}
+	if err := os.MkdirAll(bdir, dirPerm); err != nil {
+		return err
+	}
+	var files [2]string
+	for i, media := range [][2]string{{"audio", row["audio"]}, {"image", rowImage(row)}} {
+		kind, name := media[0], media[1]
+		if name == "" {
+			continue
+		}
+		file, err := fetchTaskFile(ctx, fs, ht.sharedDrive, ht.folder, ht.taskDir, kind, name)
+		if err != nil {
+			return err
+		}
+		dst, err := copyItemFile(file, bdir, name)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bdir, dst)
+		if err != nil {
+			return err
+		}
+		files[i] = filepath.ToSlash(rel)
+	}
+	b, err := ht.page(slug, row, date, files[0], files[1])
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bdir, hugoPageFile), b, filePerm)
+}
+
+func (ht *hugoTarget) Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error) {
+	base := slugify(row["title"])
+	if base == "" {
+		return "", fmt.Errorf("%w: no title", errValidation)
+	}
+	slug := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(ht.dir, slug)); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+	bdir := filepath.Join(ht.dir, slug)
+	if err := ht.writeBundle(ctx, bdir, slug, row, ht.runTime, fs); err != nil {
+		_ = os.RemoveAll(bdir)
+		return "", err
+	}
+	return slug, nil
+}
+
+// pageDate reads the date of the existing page front matter.
+func pageDate(file string) (time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "date: "); ok {
+			return time.Parse(time.RFC3339, strings.Trim(v, `"`))
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("no date in %s", file)
+}
+
+// Update rebuilds the bundle next to the existing one and swaps them, the
+// page date is kept.
+func (ht *hugoTarget) Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error {
+	bdir := filepath.Join(ht.dir, id)
+	if _, err := os.Stat(bdir); err != nil {
+		return fmt.Errorf("page %s %w: %v", id, errNotFound, err)
+	}
+	date := ht.runTime
+	if d, err := pageDate(filepath.Join(bdir, hugoPageFile)); err == nil {
+		date = d
+	}
+	newdir, olddir := bdir+".new", bdir+".old"
+	_ = os.RemoveAll(newdir)
+	if err := ht.writeBundle(ctx, newdir, id, row, date, fs); err != nil {
+		_ = os.RemoveAll(newdir)
+		return err
+	}
+	_ = os.RemoveAll(olddir)
+	if err := os.Rename(bdir, olddir); err != nil {
+		_ = os.RemoveAll(newdir)
+		return err
+	}
+	if err := os.Rename(newdir, bdir); err != nil {
+		_ = os.Rename(olddir, bdir)
+		return err
+	}
+	return os.RemoveAll(olddir)
+}
+
+func (ht *hugoTarget) Delete(ctx context.Context, id string) error {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("%w: invalid page id %s", errValidation, id)
+	}
+	return os.RemoveAll(filepath.Join(ht.dir, id))
+}
+
+func (ht *hugoTarget) Finish(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// archivePost is a post published before the task was set up, matched to
+// sheet rows by title.
+type archivePost struct {
+	id    string
+	title string
+	text  string
+}
+
+// telegramExport is the channel history exported by Telegram Desktop as
+// JSON (result.json).
+type telegramExport struct {
+	Messages []struct {
+		Id   int             `json:"id"`
+		Type string          `json:"type"`
+		Text json.RawMessage `json:"text"`
+	} `json:"messages"`
+}
+
+// readArchive reads the posts of a Telegram Desktop JSON export or of a
+// directory of HTML posts.
+func readArchive(path string) ([]archivePost, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return readHTMLArchive(path)
+	}
+	return readTelegramExport(path)
+}
+
+func readTelegramExport(file string) ([]archivePost, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var exp telegramExport
+	if err = json.Unmarshal(b, &exp); err != nil {
+		return nil, fmt.Errorf("failed to parse telegram export: %v", err)
+	}
+	var posts []archivePost
+	for _, msg := range exp.Messages {
+		if msg.Type != "message" {
+			continue
+		}
+		text, err := telegramExportText(msg.Text)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %v", msg.Id, err)
+		}
+		if text == "" {
+			continue
+		}
+		title, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+		posts = append(posts, archivePost{id: strconv.Itoa(msg.Id), title: title, text: text})
+	}
+	return posts, nil
+}
+
+// telegramExportText returns the message text, which is exported either as
+// a string or as an array of strings and formatted entities.
+func telegramExportText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errors.New("invalid text")
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &s); err == nil {
+			sb.WriteString(s)
+		} else if err = json.Unmarshal(part, &entity); err == nil {
+			sb.WriteString(entity.Text)
+		} else {
+			return "", errors.New("invalid text entity")
+		}
+	}
+	return sb.String(), nil
+}
+
+// readHTMLArchive reads posts from <id>.html files and <id>/index.html
+// pages of the directory, the post title is the first h1 or the page title.
+func readHTMLArchive(dir string) ([]archivePost, error) {
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var posts []archivePost
+	for _, dirent := range dirents {
+		name := dirent.Name()
+		file := filepath.Join(dir, name)
+		if dirent.IsDir() {
+			file = filepath.Join(file, "index.html")
+		} else if filepath.Ext(name) == ".html" && name != "index.html" {
+			name = strings.TrimSuffix(name, ".html")
+		} else {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		doc, err := html.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+		if title := htmlPostTitle(doc); title != "" {
+			posts = append(posts, archivePost{id: name, title: title})
+		}
+	}
+	return posts, nil
+}
+
+func htmlPostTitle(doc *html.Node) string {
+	var h1, title string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "h1" && h1 == "":
+				h1 = htmlText(n)
+			case n.Data == "title" && title == "":
+				title = htmlText(n)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if h1 != "" {
+		return h1
+	}
+	return title
+}
+
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(htmlText(c))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func normalizeTitle(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// importArchive marks the rows of the task matching archive posts by title
+// as published by the target, so they are not published again. Rows with
+// the target status or record id set are left untouched.
+func (exp *export) importArchive(ctx context.Context, name, targetID, path string) error {
+	t, ok := exp.tasks[name]
+	if !ok {
+		return fmt.Errorf("task %s not found", name)
+	}
+	if _, ok = t.targets[targetID]; !ok {
+		return fmt.Errorf("target %s of task %s not found", targetID, name)
+	}
+	posts, err := readArchive(path)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	if err = t.fetch(ctx, exp.fs, exp.ss); err != nil {
+		return err
+	}
+	matched, err := t.importPosts(targetID, posts)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d of %d archive posts\n", matched, len(posts))
+	if matched == 0 {
+		return nil
+	}
+	if err = t.src.save(); err != nil {
+		return err
+	}
+	return t.update(ctx)
+}
+
+func (task *task) importPosts(targetID string, posts []archivePost) (int, error) {
+	rows, err := task.src.rows()
+	if err != nil {
+		return 0, err
+	}
+	fields, _, err := task.layout.split(rows)
+	if err != nil {
+		return 0, err
+	}
+	statusColumns, recordIdColumns, err := task.targetColumns(fields)
+	if err != nil {
+		return 0, err
+	}
+	titleIdx := -1
+	for i, f := range fields {
+		if f == "title" {
+			titleIdx = i
+		}
+	}
+	if titleIdx < 0 {
+		return 0, errors.New("invalid source: no title column")
+	}
+	statusIdx, recordIdIdx := statusColumns[targetID], recordIdColumns[targetID]
+	cell := func(row []string, idx int) string {
+		if idx < len(row) {
+			return row[idx]
+		}
+		return ""
+	}
+	used := make([]bool, len(posts))
+	matched := 0
+	for i := task.layout.data; i <= len(rows); i++ {
+		row := rows[i-1]
+		title := normalizeTitle(cell(row, titleIdx))
+		if title == "" || cell(row, statusIdx) != "" || cell(row, recordIdIdx) != "" {
+			continue
+		}
+		for j, post := range posts {
+			if used[j] || normalizeTitle(post.title) != title && !strings.HasPrefix(normalizeTitle(post.text), title) {
+				continue
+			}
+			if err = task.src.setCell(i, statusIdx, "ok"); err != nil {
+				return matched, err
+			}
+			if err = task.src.setCell(i, recordIdIdx, post.id); err != nil {
+				return matched, err
+			}
+			task.log.Info("row matched post", "row", i, "post", post.id, "title", post.title)
+			used[j] = true
+			task.updated = true
+			matched++
+			break
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// sheetJoin merges the fields of a reference sheet row (e.g. authors) into
+// the task rows with the same key.
+type sheetJoin struct {
+	sheet  string
+	key    string
+	column string
+	prefix string
+	rows   map[string]map[string]string
+}
+
+func newSheetJoins(tcfg *taskConfig) ([]*sheetJoin, error) {
+	var joins []*sheetJoin
+	for _, jcfg := range tcfg.Joins {
+		if jcfg.Sheet == "" || jcfg.Key == "" {
+			return nil, errors.New("invalid config: join sheet or key not set")
+		}
+		j := &sheetJoin{sheet: jcfg.Sheet, key: jcfg.Key, column: jcfg.Column, prefix: jcfg.Prefix}
+		if j.column == "" {
+			j.column = j.key
+		}
+		joins = append(joins, j)
+	}
+	return joins, nil
+}
+
+// load reads the reference sheet rows by key, the first row of a key wins.
+func (j *sheetJoin) load(ctx context.Context, src source) error {
+	rows, err := src.sheetRows(ctx, j.sheet)
+	if err != nil {
+		return fmt.Errorf("failed to read join sheet %s: %v", j.sheet, err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("join sheet %s empty", j.sheet)
+	}
+	fields := rows[0]
+	keyIdx := -1
+	for i, f := range fields {
+		if f == j.column {
+			keyIdx = i
+			break
+		}
+	}
+	if keyIdx < 0 {
+		return fmt.Errorf("invalid join sheet %s: no %s column", j.sheet, j.column)
+	}
+	j.rows = make(map[string]map[string]string, len(rows)-1)
+	for _, row := range rows[1:] {
+		if keyIdx >= len(row) || row[keyIdx] == "" {
+			continue
+		}
+		if _, ok := j.rows[row[keyIdx]]; ok {
+			continue
+		}
+		rec := make(map[string]string, len(fields))
+		for i, f := range fields {
+			if i < len(row) {
+				rec[f] = row[i]
+			} else {
+				rec[f] = ""
+			}
+		}
+		j.rows[row[keyIdx]] = rec
+	}
+	return nil
+}
+
+// merge adds the fields of the reference row to rec and records them in
+// joined. Fields of the task sheet are never overwritten.
+func (j *sheetJoin) merge(rec map[string]string, joined map[string]bool) {
+	ref, ok := j.rows[rec[j.key]]
+	if !ok {
+		return
+	}
+	for f, v := range ref {
+		if _, ok := rec[j.prefix+f]; !ok {
+			rec[j.prefix+f] = v
+			joined[j.prefix+f] = true
+		}
+	}
+}
+
+func (task *task) loadJoins(ctx context.Context) error {
+	for _, j := range task.joins {
+		if err := j.load(ctx, task.src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeJoins adds the joined fields to rec and returns them. Joined fields
+// are read-only, they are never written back.
+func (task *task) mergeJoins(rec map[string]string) map[string]bool {
+	joined := make(map[string]bool)
+	for _, j := range task.joins {
+		j.merge(rec, joined)
+	}
+	return joined
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeJoins(t *testing.T) {
+	speakers := &sheetJoin{key: "speaker", rows: map[string]map[string]string{
+		"ann": {"speaker": "ann", "bio": "Ann's bio", "episode": "7"},
+	}}
+	tests := []struct {
+		name       string
+		joins      []*sheetJoin
+		rec        map[string]string
+		wantRec    map[string]string
+		wantJoined map[string]bool
+	}{
+		{
+			"joined fields are added",
+			[]*sheetJoin{speakers},
+			map[string]string{"speaker": "ann"},
+			map[string]string{"speaker": "ann", "bio": "Ann's bio", "episode": "7"},
+			map[string]bool{"bio": true, "episode": true},
+		},
+		{
+			"sheet fields are kept, empty ones too",
+			[]*sheetJoin{speakers},
+			map[string]string{"speaker": "ann", "episode": ""},
+			map[string]string{"speaker": "ann", "bio": "Ann's bio", "episode": ""},
+			map[string]bool{"bio": true},
+		},
+		{
+			"prefixed fields",
+			[]*sheetJoin{{key: "speaker", prefix: "s_", rows: speakers.rows}},
+			map[string]string{"speaker": "ann"},
+			map[string]string{"speaker": "ann", "s_speaker": "ann", "s_bio": "Ann's bio", "s_episode": "7"},
+			map[string]bool{"s_speaker": true, "s_bio": true, "s_episode": true},
+		},
+		{
+			"unknown key",
+			[]*sheetJoin{speakers},
+			map[string]string{"speaker": "bob"},
+			map[string]string{"speaker": "bob"},
+			map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &task{joins: tt.joins}
+			joined := task.mergeJoins(tt.rec)
+			if !reflect.DeepEqual(tt.rec, tt.wantRec) {
+				t.Errorf("rec = %v, want %v", tt.rec, tt.wantRec)
+			}
+			if !reflect.DeepEqual(joined, tt.wantJoined) {
+				t.Errorf("joined = %v, want %v", joined, tt.wantJoined)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultLangColumn = "lang"
+
+// langRouting routes rows to targets by the language column, so one sheet
+// feeds e.g. Russian and English channels. Targets not listed in the
+// routes get rows of all languages.
+type langRouting struct {
+	column string
+	langs  map[string]map[string]bool // target id -> languages
+}
+
+func newLangRouting(cfg *langConfig, targets map[string]target) (*langRouting, error) {
+	if cfg == nil || len(cfg.Targets) == 0 {
+		return nil, nil
+	}
+	r := &langRouting{column: cfg.Column, langs: make(map[string]map[string]bool)}
+	if r.column == "" {
+		r.column = defaultLangColumn
+	}
+	for lang, ids := range cfg.Targets {
+		for _, id := range ids {
+			if _, ok := targets[id]; !ok {
+				return nil, fmt.Errorf("invalid config: unknown %s language target %s", lang, id)
+			}
+			if r.langs[id] == nil {
+				r.langs[id] = make(map[string]bool)
+			}
+			r.langs[id][normalizeLang(lang)] = true
+		}
+	}
+	return r, nil
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+// rowLang returns the language of the row, fields are the sheet header.
+func (r *langRouting) rowLang(fields, row []string) string {
+	if r == nil {
+		return ""
+	}
+	for i, f := range fields {
+		if f == r.column && i < len(row) {
+			return normalizeLang(row[i])
+		}
+	}
+	return ""
+}
+
+// routes reports whether rows of the language are published to the target.
+func (r *langRouting) routes(tid, lang string) bool {
+	if r == nil || r.langs[tid] == nil {
+		return true
+	}
+	return r.langs[tid][lang]
+}
+
+// routesRow reports whether the row record is published to the target.
+func (r *langRouting) routesRow(tid string, row map[string]string) bool {
+	if r == nil {
+		return true
+	}
+	return r.routes(tid, normalizeLang(row[r.column]))
+}
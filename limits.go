@@ -0,0 +1,183 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// maxUploadBuffer limits the in-memory request body of media uploads.
+	maxUploadBuffer int64
+	// tempSpace limits the data fetched into the export dir by the run.
+	tempSpace = &diskBudget{}
+	// templateTimeout and maxTemplateOutput limit a template execution.
+	templateTimeout   = defaultTemplateTimeout
+	maxTemplateOutput = int64(defaultMaxTemplateOutput)
+)
+
+const (
+	defaultTemplateTimeout   = 10 * time.Second
+	defaultMaxTemplateOutput = 8 << 20
+)
+
+// setupLimits applies the resource limits of the config.
+func setupLimits(cfg *config) {
+	maxUploadBuffer, tempSpace.max = 0, 0
+	templateTimeout, maxTemplateOutput = defaultTemplateTimeout, defaultMaxTemplateOutput
+	if cfg.Limits != nil {
+		maxUploadBuffer = cfg.Limits.MaxUploadBufferMB << 20
+		tempSpace.max = cfg.Limits.MaxTempMB << 20
+		if cfg.Limits.TemplateTimeoutMs > 0 {
+			templateTimeout = time.Duration(cfg.Limits.TemplateTimeoutMs) * time.Millisecond
+		}
+		if cfg.Limits.MaxTemplateOutputKB > 0 {
+			maxTemplateOutput = cfg.Limits.MaxTemplateOutputKB << 10
+		}
+	}
+}
+
+// limitError is returned when a resource limit is exceeded. The run does
+// not proceed with further rows then.
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+func isLimitError(err error) bool {
+	var le *limitError
+	return errors.As(err, &le)
+}
+
+// diskBudget accounts the temp disk space used by the run.
+type diskBudget struct {
+	mu   sync.Mutex
+	max  int64
+	used int64
+}
+
+func (b *diskBudget) reset() {
+	b.mu.Lock()
+	b.used = 0
+	b.mu.Unlock()
+}
+
+// reserve accounts n more bytes of the file, it fails if the limit would
+// be exceeded.
+func (b *diskBudget) reserve(name string, n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used+n > b.max {
+		return &limitError{fmt.Sprintf("temp space limit of %d MB exceeded fetching %s, %d MB already used (limits.max_temp_mb)",
+			b.max>>20, name, b.used>>20)}
+	}
+	b.used += n
+	return nil
+}
+
+// reader accounts bytes read from r into the budget. The whole size is
+// reserved at once if known, so large files fail before being fetched.
+func (b *diskBudget) reader(name string, r io.ReadCloser, size int64) (io.ReadCloser, error) {
+	if b.max <= 0 {
+		return r, nil
+	}
+	if size > 0 {
+		if err := b.reserve(name, size); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	return &budgetReader{ReadCloser: r, name: name, budget: b}, nil
+}
+
+type budgetReader struct {
+	io.ReadCloser
+	name   string
+	budget *diskBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if rerr := r.budget.reserve(r.name, int64(n)); rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, err
+}
+
+// uploadBuffer is the request body of a media upload held in memory, its
+// size is limited by maxUploadBuffer.
+type uploadBuffer struct {
+	bytes.Buffer
+}
+
+func (b *uploadBuffer) Write(p []byte) (int, error) {
+	if maxUploadBuffer > 0 && int64(b.Len()+len(p)) > maxUploadBuffer {
+		return 0, &limitError{fmt.Sprintf("upload buffer limit exceeded: the upload is larger than %d MB (limits.max_upload_buffer_mb)",
+			maxUploadBuffer>>20)}
+	}
+	return b.Buffer.Write(p)
+}
+
+// executeTemplate executes the template into w within the template time and
+// output limits. A template exceeding them fails with a validation error, so
+// only the row fails. Executions can't be interrupted, a timed out one is
+// left running until its next write.
+func executeTemplate(tmpl templateExecutor, w io.Writer, data any) error {
+	out := &templateOutput{}
+	done := make(chan error, 1)
+	go func() {
+		done <- tmpl.Execute(out, data)
+	}()
+	timer := time.NewTimer(templateTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out.buf.Bytes())
+		return err
+	case <-timer.C:
+		out.stopped.Store(true)
+		return fmt.Errorf("%w: template execution exceeded %v (limits.template_timeout_ms)", errValidation, templateTimeout)
+	}
+}
+
+// templateOutput buffers the template output up to maxTemplateOutput.
+type templateOutput struct {
+	buf     bytes.Buffer
+	stopped atomic.Bool
+}
+
+func (o *templateOutput) Write(p []byte) (int, error) {
+	if o.stopped.Load() {
+		return 0, errors.New("template execution stopped")
+	}
+	if int64(o.buf.Len()+len(p)) > maxTemplateOutput {
+		return 0, fmt.Errorf("%w: template output is larger than %d KB (limits.max_template_output_kb)", errValidation, maxTemplateOutput>>10)
+	}
+	return o.buf.Write(p)
+}
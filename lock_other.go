@@ -0,0 +1,44 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const lockFile = ".lock"
+
+var errLocked = errors.New("locked by another run")
+
+// lockDir takes an exclusive lock on the directory by creating a lock file
+// and returns the function releasing it.
+func lockDir(dir string) (func() error, error) {
+	file := filepath.Join(dir, lockFile)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_RDWR, filePerm)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return func() error {
+		f.Close()
+		return os.Remove(file)
+	}, nil
+}
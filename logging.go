@@ -0,0 +1,70 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging makes the default logger write leveled records in the config
+// format to stderr, secrets redacted. Output of the log package is logged
+// at the info level. The level is overridden by verbose (debug) and quiet
+// (warn) flags.
+func setupLogging(cfg *config, verbose, quiet bool) error {
+	level := slog.LevelInfo
+	format := logFormatText
+	if cfg.Logging != nil {
+		if cfg.Logging.Level != "" {
+			if err := level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+				return fmt.Errorf("invalid config: invalid log level %s", cfg.Logging.Level)
+			}
+		}
+		if cfg.Logging.Format != "" {
+			format = strings.ToLower(cfg.Logging.Format)
+		}
+	}
+	if verbose {
+		level = slog.LevelDebug
+	} else if quiet {
+		level = slog.LevelWarn
+	}
+	w := &redactWriter{w: os.Stderr}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case logFormatText:
+		h = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid config: invalid log format %s", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
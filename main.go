@@ -15,45 +15,359 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 )
 
 var (
-	flagNoClean = flag.Bool("no-clean", false, "do not remove fetched/modified files on exit")
-	flagBotMode = flag.Bool("bot-mode", false, "listen bot events")
+	flagConfig      = flag.String("config", "", "config file path (default: $DRIVE_EXPORT_CONFIG or <executable>.json)")
+	flagProfile     = flag.String("profile", "", "config profile name (default: $DRIVE_EXPORT_PROFILE)")
+	flagNoClean     = flag.Bool("no-clean", false, "do not remove fetched/modified files on exit")
+	flagBotMode     = flag.Bool("bot-mode", false, "listen bot events")
+	flagOnce        = flag.Bool("once", false, "in bot or trigger mode, handle pending triggers and exit")
+	flagVerbose     = flag.Bool("verbose", false, "log debug messages")
+	flagQuiet       = flag.Bool("quiet", false, "log warnings and errors only")
+	flagVersion     = flag.Bool("version", false, "print version and exit")
+	flagCheckUpdate = flag.Bool("check-update", false, "check for a newer release on start")
+	flagReportJSON  = flag.Bool("report-json", false, "print the JSON run report to stdout")
+	flagReport      = flag.Bool("report", false, "print published, pending and failed row counts per task and target without publishing and exit")
+	flagHistory     = flag.Bool("history", false, "print the recent runs and exit")
+	flagShowRow     = flag.String("show-row", "", "print the operations on the task:row of the recent runs and exit")
+	flagRebuild     = flag.Bool("rebuild-catalog", false, "regenerate html catalog pages from their manifests and templates and exit")
+
+	flagAPI       = flag.Bool("api", false, "run as a daemon serving the api only, runs are started by api requests")
+	flagScheduler = flag.Bool("scheduler", false, "run as a daemon exporting tasks on their cron schedules")
+	flagSchedule  = flag.String("schedule", "", "cron expression of the scheduler mode runs, e.g. \"0 9 * * *\" (default: the config cron schedule)")
+
+	flagTriggerFile     = flag.String("trigger-file", "", "run the export whenever the file, or a file in the directory, appears and remove it")
+	flagTriggerInterval = flag.Duration("trigger-interval", 5*time.Second, "trigger file check interval")
+
+	flagClock = flag.String("clock", "", "run at the fixed RFC 3339 time with sequential run ids, for reproducible test runs")
+
+	flagPublishRow = flag.String("publish-row", "", "publish the JSON row read from stdin through the targets of the task, print the record ids and exit")
+
+	flagImport       = flag.String("import", "", "mark rows matching posts of the Telegram Desktop JSON export or HTML posts directory as published and exit")
+	flagImportTask   = flag.String("import-task", "", "task of the imported posts")
+	flagImportTarget = flag.String("import-target", "", "target id of the imported posts, e.g. telegram_main")
+
+	flagBench            = flag.Bool("bench", false, "benchmark the export of a synthetic sheet against mock endpoints and exit")
+	flagBenchRows        = flag.Int("bench-rows", 100, "number of rows of the benchmark sheet")
+	flagBenchTasks       = flag.Int("bench-tasks", 1, "number of benchmark tasks")
+	flagBenchConcurrency = flag.Int("bench-concurrency", 1, "number of benchmark tasks handled in parallel")
+	flagBenchLatency     = flag.Duration("bench-latency", 0, "latency of mock endpoints")
 )
 
 func main() {
 	flag.Parse()
 
-	cfg, err := readConfig()
+	if *flagVersion {
+		fmt.Println(toolVersion())
+		return
+	}
+	log.Printf("drive_export %s\n", toolVersion())
+	if *flagBench {
+		result, err := runBench(benchOptions{
+			rows:        *flagBenchRows,
+			tasks:       *flagBenchTasks,
+			concurrency: *flagBenchConcurrency,
+			latency:     *flagBenchLatency,
+		})
+		if err != nil {
+			fatal("bench failed", err)
+		}
+		if *flagReportJSON {
+			b, err := result.json()
+			if err != nil {
+				fatal("failed to encode bench result", err)
+			}
+			fmt.Println(string(b))
+		} else if err = result.print(os.Stdout); err != nil {
+			fatal("failed to print bench result", err)
+		}
+		return
+	}
+	cfg, err := readConfig(*flagConfig, *flagProfile)
+	if err != nil {
+		fatal("failed to read config", err)
+	}
+	ctx, abort := signalContexts()
+
+	setupRedaction(cfg)
+	if err = setupLogging(cfg, *flagVerbose, *flagQuiet); err != nil {
+		fatal("failed to setup logging", err)
+	}
+	clock, err := newClock(*flagClock)
 	if err != nil {
-		log.Fatalf("failed to read config: %v", err)
+		fatal("failed to setup clock", err)
+	}
+	setupHTTP(abort, cfg)
+	setupLimits(cfg)
+	setupDrive(cfg, clock)
+
+	if *flagRebuild {
+		if err = rebuildCatalogs(ctx, cfg, clock); err != nil {
+			fatal("failed to rebuild catalogs", err)
+		}
+		return
+	}
+	if *flagHistory {
+		if err = writeHistory(os.Stdout, cfg, *flagReportJSON); err != nil {
+			fatal("failed to print history", err)
+		}
+		return
+	}
+	if *flagShowRow != "" {
+		name, row, err := parseRowRef(*flagShowRow)
+		if err == nil {
+			err = writeRowHistory(os.Stdout, cfg, name, row, *flagReportJSON)
+		}
+		if err != nil {
+			fatal("failed to print row history", err)
+		}
+		return
+	}
+	if *flagReport {
+		exp, err := newExport(cfg, clock)
+		if err != nil {
+			fatal("failed to init export", err)
+		}
+		report := exp.statusReport(ctx)
+		exp.close()
+		if !*flagNoClean {
+			exp.clean()
+		}
+		if err = writeStatusReport(os.Stdout, report, *flagReportJSON); err != nil {
+			fatal("failed to write status report", err)
+		}
+		return
+	}
+	if *flagPublishRow != "" {
+		exp, err := newExport(cfg, clock)
+		if err != nil {
+			fatal("failed to init export", err)
+		}
+		err = exp.publishRow(ctx, *flagPublishRow, os.Stdin, os.Stdout)
+		exp.close()
+		if !*flagNoClean {
+			exp.clean()
+		}
+		if err != nil {
+			fatal("failed to publish row", err)
+		}
+		return
+	}
+	if *flagImport != "" {
+		exp, err := newExport(cfg, clock)
+		if err != nil {
+			fatal("failed to init export", err)
+		}
+		err = exp.importArchive(ctx, *flagImportTask, *flagImportTarget, *flagImport)
+		exp.close()
+		if !*flagNoClean {
+			exp.clean()
+		}
+		if err != nil {
+			fatal("failed to import", err)
+		}
+		return
+	}
+
+	if *flagCheckUpdate {
+		if latest, err := checkUpdate(); err != nil {
+			slog.Warn("failed to check for updates", "err", err)
+		} else if latest != "" {
+			log.Printf("new version available: %s\n", latest)
+		}
 	}
 
+	notifier, err := newRunNotifier(cfg)
+	if err != nil {
+		fatal("failed to init notifiers", err)
+	}
+	// runs of the process don't overlap, status tracks them for the api
+	status := &runStatus{}
+	runStarted := func(cfg *config, clock runClock) ([]taskResult, error) {
+		var last *runReport
+		opts := runOptions{clock: clock, noClean: *flagNoClean}
+		opts.afterRun = func(report *runReport) {
+			last = report
+			if !*flagReportJSON {
+				return
+			}
+			if b, err := report.json(); err != nil {
+				slog.Warn("failed to encode run report", "err", err)
+			} else {
+				fmt.Println(string(b))
+			}
+		}
+		results, err := run(ctx, cfg, opts)
+		status.end(last, err)
+		// reports of stopped runs are sent too
+		notifier.notify(context.WithoutCancel(ctx), results, err)
+		return results, err
+	}
+	runTasks := func(cfg *config) ([]taskResult, error) {
+		if !status.beginWait(time.Duration(cfg.LockWait) * time.Second) {
+			return nil, errRunInProgress
+		}
+		return runStarted(cfg, clock)
+	}
 	runExport := func() ([]taskResult, error) {
-		exp, err := newExport(cfg)
+		return runTasks(cfg)
+	}
+	startExport := func() (string, error) {
+		if !status.begin() {
+			return "", errRunInProgress
+		}
+		id, err := clock.runID(clock.now())
+		if err != nil {
+			err = fmt.Errorf("failed to generate run id: %v", err)
+			status.end(nil, err)
+			return "", err
+		}
+		go func() {
+			if _, err := runStarted(cfg, clock.withRunID(id)); err != nil {
+				slog.Error("run failed", "err", err)
+			}
+		}()
+		return id, nil
+	}
+
+	preview := func(ctx context.Context, name string, row int, chat string) error {
+		exp, err := newExport(cfg, clock)
+		if err != nil {
+			return fmt.Errorf("failed init export: %v", err)
+		}
+		defer exp.close()
+		if !*flagNoClean {
+			defer exp.clean()
+		}
+		return exp.preview(ctx, name, row, chat)
+	}
+
+	approve := func(name string, row int) error {
+		exp, err := newExport(cfg, clock)
+		if err != nil {
+			return fmt.Errorf("failed init export: %v", err)
+		}
+		defer exp.close()
+		if !*flagNoClean {
+			defer exp.clean()
+		}
+		return exp.approve(ctx, name, row)
+	}
+
+	pending := func() ([]taskPending, error) {
+		exp, err := newExport(cfg, clock)
 		if err != nil {
 			return nil, fmt.Errorf("failed init export: %v", err)
 		}
-		exp.fetch()
-		results := exp.process()
-		exp.upload()
+		defer exp.close()
 		if !*flagNoClean {
-			exp.clean()
+			defer exp.clean()
 		}
-		return results, nil
+		exp.fetch(ctx)
+		return exp.pending(), nil
+	}
+
+	actions := &botActions{
+		sync:    runExport,
+		start:   startExport,
+		status:  status,
+		now:     clock.now,
+		preview: preview,
+		approve: approve,
+		pending: pending,
+	}
+	// daemon modes serve the api along if configured
+	daemon := *flagBotMode || *flagScheduler || *flagSchedule != "" || *flagTriggerFile != ""
+	if daemon && cfg.APIListen != "" && !*flagOnce {
+		go func() {
+			if err := serveAPI(ctx, cfg, actions); err != nil {
+				fatal("failed to serve api", err)
+			}
+		}()
 	}
 
-	if *flagBotMode {
-		err = telegramListenBot(cfg, runExport)
+	if *flagAPI {
+		if cfg.APIListen == "" {
+			fatal("invalid config", errors.New("api listen address not set"))
+		}
+		sdStartWatchdog(ctx)
+		if err = sdNotify("READY=1"); err != nil {
+			slog.Warn("failed to notify systemd", "err", err)
+		}
+		err = serveAPI(ctx, cfg, actions)
+	} else if *flagBotMode {
+		err = telegramListenBot(ctx, cfg, *flagOnce, actions)
+	} else if *flagScheduler || *flagSchedule != "" {
+		err = runScheduler(ctx, cfg, *flagSchedule, func(names []string) ([]taskResult, error) {
+			c := *cfg
+			c.Tasks = nil
+			for _, tcfg := range cfg.Tasks {
+				if slices.Contains(names, tcfg.Name) {
+					c.Tasks = append(c.Tasks, tcfg)
+				}
+			}
+			return runTasks(&c)
+		})
+	} else if *flagTriggerFile != "" {
+		err = watchTrigger(ctx, *flagTriggerFile, *flagTriggerInterval, *flagOnce, runExport)
 	} else {
-		_, err = runExport()
+		var results []taskResult
+		if results, err = runExport(); err == nil && hasRetryableFailures(results) {
+			slog.Warn("some rows failed with temporary errors", "exit_code", exitTempFail)
+			os.Exit(exitTempFail)
+		}
 	}
 
 	if err != nil {
-		log.Fatal(err)
+		fatal("run failed", err)
+	}
+}
+
+// signalContexts returns the context cancelled by the first SIGINT or
+// SIGTERM, stopping runs after the current row so the statuses of handled
+// rows are written back, and the one cancelled by the second signal,
+// aborting requests in flight.
+func signalContexts() (ctx, abort context.Context) {
+	ctx, stop := context.WithCancel(context.Background())
+	abort, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Println("interrupted, finishing the current row, interrupt again to abort")
+		stop()
+		<-sig
+		log.Println("aborting")
+		cancel()
+		// the next signal terminates the process
+		signal.Stop(sig)
+	}()
+	return ctx, abort
+}
+
+// exitTempFail is the exit code of runs with rows failed with retryable
+// errors, so schedulers may run the export again sooner (EX_TEMPFAIL).
+const exitTempFail = 75
+
+func hasRetryableFailures(results []taskResult) bool {
+	for _, result := range results {
+		for _, outcome := range result.rows {
+			if isRetryable(outcome.err) {
+				return true
+			}
+		}
 	}
+	return false
 }
@@ -0,0 +1,223 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Text formats of the text column.
+const (
+	textFormatPlain    = "plain"
+	textFormatMarkdown = "markdown"
+)
+
+func validTextFormat(format string) error {
+	switch format {
+	case "", textFormatPlain, textFormatMarkdown:
+		return nil
+	}
+	return fmt.Errorf("invalid config: unknown text format %s", format)
+}
+
+// mdBlock is a block of a Markdown document.
+type mdBlock struct {
+	kind  string // p, h1-h6, ul, ol, quote, code
+	lines []string
+}
+
+var (
+	mdHeadingRegexp = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdBulletRegexp  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	mdOrderedRegexp = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+)
+
+// parseMarkdown splits the Markdown subset supported by the converters into
+// blocks: paragraphs, ATX headings, lists, block quotes and fenced code.
+func parseMarkdown(text string) []mdBlock {
+	var blocks []mdBlock
+	var cur *mdBlock
+	flush := func() {
+		if cur != nil {
+			blocks = append(blocks, *cur)
+			cur = nil
+		}
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			code := mdBlock{kind: "code"}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code.lines = append(code.lines, lines[i])
+			}
+			blocks = append(blocks, code)
+		case trimmed == "":
+			flush()
+		case mdHeadingRegexp.MatchString(trimmed):
+			flush()
+			m := mdHeadingRegexp.FindStringSubmatch(trimmed)
+			blocks = append(blocks, mdBlock{kind: fmt.Sprintf("h%d", len(m[1])), lines: []string{m[2]}})
+		case strings.HasPrefix(trimmed, ">"):
+			if cur == nil || cur.kind != "quote" {
+				flush()
+				cur = &mdBlock{kind: "quote"}
+			}
+			cur.lines = append(cur.lines, strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+		case mdBulletRegexp.MatchString(trimmed):
+			if cur == nil || cur.kind != "ul" {
+				flush()
+				cur = &mdBlock{kind: "ul"}
+			}
+			cur.lines = append(cur.lines, mdBulletRegexp.FindStringSubmatch(trimmed)[1])
+		case mdOrderedRegexp.MatchString(trimmed):
+			if cur == nil || cur.kind != "ol" {
+				flush()
+				cur = &mdBlock{kind: "ol"}
+			}
+			cur.lines = append(cur.lines, mdOrderedRegexp.FindStringSubmatch(trimmed)[1])
+		default:
+			if cur != nil && (cur.kind == "ul" || cur.kind == "ol") && line != trimmed {
+				// continuation of the list item
+				cur.lines[len(cur.lines)-1] += " " + trimmed
+				continue
+			}
+			if cur == nil || cur.kind != "p" {
+				flush()
+				cur = &mdBlock{kind: "p"}
+			}
+			cur.lines = append(cur.lines, trimmed)
+		}
+	}
+	flush()
+	return blocks
+}
+
+var mdInlineRegexp = regexp.MustCompile("`([^`]+)`|\\*\\*(.+?)\\*\\*|__(.+?)__|\\*([^*\\s][^*]*?)\\*|\\b_([^_\\s][^_]*?)_\\b|~~(.+?)~~|\\[([^\\]]+)\\]\\(([^)\\s]+)\\)")
+
+// markdownInline converts inline code, emphasis, strikethrough and links of
+// the Markdown text to HTML escaping the rest.
+func markdownInline(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range mdInlineRegexp.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(html.EscapeString(text[last:m[0]]))
+		last = m[1]
+		group := func(n int) string {
+			return text[m[2*n]:m[2*n+1]]
+		}
+		switch {
+		case m[2] >= 0:
+			sb.WriteString("<code>" + html.EscapeString(group(1)) + "</code>")
+		case m[4] >= 0:
+			sb.WriteString("<b>" + markdownInline(group(2)) + "</b>")
+		case m[6] >= 0:
+			sb.WriteString("<b>" + markdownInline(group(3)) + "</b>")
+		case m[8] >= 0:
+			sb.WriteString("<i>" + markdownInline(group(4)) + "</i>")
+		case m[10] >= 0:
+			sb.WriteString("<i>" + markdownInline(group(5)) + "</i>")
+		case m[12] >= 0:
+			sb.WriteString("<s>" + markdownInline(group(6)) + "</s>")
+		case safeLinkURL(group(8)):
+			sb.WriteString(`<a href="` + html.EscapeString(group(8)) + `">` + markdownInline(group(7)) + "</a>")
+		default:
+			sb.WriteString(markdownInline(group(7)))
+		}
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+	return sb.String()
+}
+
+// safeLinkURL reports whether the link is relative or has a web or mail
+// scheme, javascript: and other links are rendered as text.
+func safeLinkURL(u string) bool {
+	scheme, _, ok := strings.Cut(u, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto", "tg":
+		return true
+	}
+	return false
+}
+
+// markdownHTML converts the Markdown text to HTML for web pages.
+func markdownHTML(text string) string {
+	var sb strings.Builder
+	for _, b := range parseMarkdown(text) {
+		switch b.kind {
+		case "code":
+			sb.WriteString("<pre><code>" + html.EscapeString(strings.Join(b.lines, "\n")) + "</code></pre>")
+		case "ul", "ol":
+			sb.WriteString("<" + b.kind + ">")
+			for _, item := range b.lines {
+				sb.WriteString("<li>" + markdownInline(item) + "</li>")
+			}
+			sb.WriteString("</" + b.kind + ">")
+		case "quote":
+			sb.WriteString("<blockquote><p>" + markdownInline(strings.Join(b.lines, " ")) + "</p></blockquote>")
+		case "p":
+			sb.WriteString("<p>" + strings.Join(mapStrings(b.lines, markdownInline), "<br>") + "</p>")
+		default:
+			sb.WriteString("<" + b.kind + ">" + markdownInline(b.lines[0]) + "</" + b.kind + ">")
+		}
+	}
+	return sb.String()
+}
+
+// markdownTelegram converts the Markdown text to the HTML subset supported
+// by the Bot API: headings become bold lines and lists bulleted lines.
+func markdownTelegram(text string) string {
+	var parts []string
+	for _, b := range parseMarkdown(text) {
+		switch b.kind {
+		case "code":
+			parts = append(parts, "<pre>"+html.EscapeString(strings.Join(b.lines, "\n"))+"</pre>")
+		case "ul", "ol":
+			items := make([]string, len(b.lines))
+			for i, item := range b.lines {
+				marker := "•"
+				if b.kind == "ol" {
+					marker = fmt.Sprintf("%d.", i+1)
+				}
+				items[i] = marker + " " + markdownInline(item)
+			}
+			parts = append(parts, strings.Join(items, "\n"))
+		case "quote":
+			parts = append(parts, "<blockquote>"+strings.Join(mapStrings(b.lines, markdownInline), "\n")+"</blockquote>")
+		case "p":
+			parts = append(parts, strings.Join(mapStrings(b.lines, markdownInline), "\n"))
+		default:
+			parts = append(parts, "<b>"+markdownInline(b.lines[0])+"</b>")
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func mapStrings(s []string, f func(string) string) []string {
+	r := make([]string, len(s))
+	for i, v := range s {
+		r[i] = f(v)
+	}
+	return r
+}
@@ -0,0 +1,288 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const mastodonTargetType = "mastodon"
+
+type mastodonTarget struct {
+	taskDir     string
+	folder      string
+	sharedDrive string
+	name        string
+	instance    string
+	token       string
+	visibility  string
+	template    *template.Template
+	templates   *rowTemplates
+	blocks      *contentBlocks
+}
+
+func newMastodonTarget(cfg *targetConfig, tdir, sharedDrive, folder string, blocks *contentBlocks) (target, error) {
+	if cfg.Instance == "" || cfg.AccessToken == "" {
+		return nil, errors.New("invalid config: mastodon instance or access token not set")
+	}
+	tmpl, err := template.ParseFiles(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	templates, err := newRowTemplates(cfg, func(file string) (templateExecutor, error) {
+		return template.ParseFiles(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mastodonTarget{
+		taskDir:     tdir,
+		folder:      folder,
+		sharedDrive: sharedDrive,
+		name:        cfg.Name,
+		instance:    strings.TrimRight(cfg.Instance, "/"),
+		token:       cfg.AccessToken,
+		visibility:  cfg.Visibility,
+		template:    tmpl,
+		templates:   templates,
+		blocks:      blocks,
+	}, nil
+}
+
+func (mt *mastodonTarget) ID() string {
+	return mastodonTargetType + "_" + mt.name
+}
+
+func (mt *mastodonTarget) Type() string {
+	return mastodonTargetType
+}
+
+func (mt *mastodonTarget) Name() string {
+	return mt.name
+}
+
+func (mt *mastodonTarget) render(row map[string]string) (string, error) {
+	var buf bytes.Buffer
+	data := copyRowAny(row)
+	data["blocks"] = mt.blocks.text(row)
+	tmpl, err := mt.templates.template(row)
+	if err != nil {
+		return "", err
+	}
+	if tmpl == nil {
+		tmpl = mt.template
+	}
+	if err := executeTemplate(tmpl, &buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Insert posts the status with the audio or the image attached. Mastodon
+// doesn't mix audio with images in a status, so the image of a row with
+// audio becomes the audio thumbnail.
+func (mt *mastodonTarget) Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error) {
+	text, err := mt.render(row)
+	if err != nil {
+		return "", err
+	}
+	var image string
+	imageName := rowImage(row)
+	if imageName != "" {
+		if image, err = fetchTaskFile(ctx, fs, mt.sharedDrive, mt.folder, mt.taskDir, "image", imageName); err != nil {
+			return "", err
+		}
+	}
+	media, name, thumbnail := image, imageName, ""
+	if audio := row["audio"]; audio != "" {
+		if media, err = fetchTaskFile(ctx, fs, mt.sharedDrive, mt.folder, mt.taskDir, "audio", audio); err != nil {
+			return "", err
+		}
+		name, thumbnail = audio, image
+	}
+	params := map[string]any{"status": text}
+	if media != "" {
+		id, err := mt.uploadMedia(ctx, media, thumbnail, row["alt"])
+		if err != nil {
+			return "", fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		params["media_ids"] = []string{id}
+	}
+	if mt.visibility != "" {
+		params["visibility"] = mt.visibility
+	}
+	var status mastodonStatus
+	if err = mt.call(ctx, http.MethodPost, "/api/v1/statuses", params, &status); err != nil {
+		return "", err
+	}
+	return status.Id, nil
+}
+
+// Update edits the status text keeping its media attachments.
+func (mt *mastodonTarget) Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error {
+	text, err := mt.render(row)
+	if err != nil {
+		return err
+	}
+	var status mastodonStatus
+	if err = mt.call(ctx, http.MethodGet, "/api/v1/statuses/"+id, nil, &status); err != nil {
+		return err
+	}
+	params := map[string]any{"status": text}
+	if len(status.MediaAttachments) != 0 {
+		ids := make([]string, len(status.MediaAttachments))
+		for i, m := range status.MediaAttachments {
+			ids[i] = m.Id
+		}
+		params["media_ids"] = ids
+	}
+	return mt.call(ctx, http.MethodPut, "/api/v1/statuses/"+id, params, nil)
+}
+
+func (mt *mastodonTarget) Delete(ctx context.Context, id string) error {
+	return mt.call(ctx, http.MethodDelete, "/api/v1/statuses/"+id, nil, nil)
+}
+
+func (mt *mastodonTarget) Finish(ctx context.Context) error {
+	return nil
+}
+
+type mastodonStatus struct {
+	Id               string               `json:"id"`
+	MediaAttachments []mastodonAttachment `json:"media_attachments"`
+}
+
+type mastodonAttachment struct {
+	Id  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// mastodonMediaAttempts limits waiting for asynchronous media processing.
+const mastodonMediaAttempts = 30
+
+// uploadMedia uploads the file with the thumbnail if set.
+func (mt *mastodonTarget) uploadMedia(ctx context.Context, file, thumbnail, description string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var buf uploadBuffer
+	w := multipart.NewWriter(&buf)
+	if description != "" {
+		if err = w.WriteField("description", description); err != nil {
+			return "", err
+		}
+	}
+	part, err := w.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(part, f); err != nil {
+		return "", err
+	}
+	if thumbnail != "" {
+		if err = writeFormFile(w, "thumbnail", thumbnail); err != nil {
+			return "", err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, mt.instance+"/api/v2/media", &buf.Buffer)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var media mastodonAttachment
+	if err = mt.do(req, &media); err != nil {
+		return "", err
+	}
+	// large media is processed asynchronously, the url is set when done
+	for i := 0; media.URL == "" && i < mastodonMediaAttempts; i++ {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+		}
+		if err = mt.call(ctx, http.MethodGet, "/api/v1/media/"+media.Id, nil, &media); err != nil {
+			return "", err
+		}
+	}
+	if media.URL == "" {
+		return "", errors.New("media processing timeout")
+	}
+	return media.Id, nil
+}
+
+func (mt *mastodonTarget) call(ctx context.Context, method, path string, params map[string]any, result any) error {
+	var body io.Reader
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, mt.instance+path, body)
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return mt.do(req, result)
+}
+
+func (mt *mastodonTarget) do(req *http.Request, result any) error {
+	req.Header.Set("Authorization", "Bearer "+mt.token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return classifyHTTPError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = resp.Status
+		}
+		err = fmt.Errorf("mastodon request error %d: %s", resp.StatusCode, e.Error)
+		if class := httpStatusClass(resp.StatusCode); class != nil {
+			err = fmt.Errorf("%w: %v", class, err)
+		}
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
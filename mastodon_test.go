@@ -0,0 +1,106 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+func TestMastodonInsertMedia(t *testing.T) {
+	tests := []struct {
+		name      string
+		row       map[string]string
+		uploads   []string
+		thumbnail string
+	}{
+		{"text only", map[string]string{"title": "t"}, nil, ""},
+		{"image", map[string]string{"title": "t", "image": "cover.jpg"}, []string{"cover.jpg"}, ""},
+		{"audio", map[string]string{"title": "t", "audio": "ep.mp3"}, []string{"ep.mp3"}, ""},
+		{"audio with image", map[string]string{"title": "t", "audio": "ep.mp3", "image": "cover.jpg"}, []string{"ep.mp3"}, "cover.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for kind, name := range map[string]string{"audio": "ep.mp3", "image": "cover.jpg"} {
+				if err := os.MkdirAll(filepath.Join(dir, kind), dirPerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, kind, name), []byte(name), filePerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var mu sync.Mutex
+			var uploads []string
+			var thumbnail string
+			var mediaIds []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case "/api/v2/media":
+					_, fh, err := r.FormFile("file")
+					if err != nil {
+						t.Fatal(err)
+					}
+					uploads = append(uploads, fh.Filename)
+					if _, th, err := r.FormFile("thumbnail"); err == nil {
+						thumbnail = th.Filename
+					}
+					_, _ = w.Write([]byte(`{"id":"m` + fh.Filename + `","url":"https://example.com/m"}`))
+				case "/api/v1/statuses":
+					var params struct {
+						MediaIds []string `json:"media_ids"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+						t.Error(err)
+					}
+					mediaIds = params.MediaIds
+					_, _ = w.Write([]byte(`{"id":"1"}`))
+				default:
+					t.Errorf("unexpected request %s", r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			mt := &mastodonTarget{
+				taskDir:  dir,
+				instance: srv.URL,
+				token:    "token",
+				template: template.Must(template.New("").Parse("{{.title}}")),
+			}
+			if _, err := mt.Insert(context.Background(), tt.row, nil); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(uploads, tt.uploads) {
+				t.Errorf("uploads = %v, want %v", uploads, tt.uploads)
+			}
+			if thumbnail != tt.thumbnail {
+				t.Errorf("thumbnail = %q, want %q", thumbnail, tt.thumbnail)
+			}
+			if len(mediaIds) != len(tt.uploads) {
+				t.Errorf("media ids = %v, want one per upload", mediaIds)
+			}
+		})
+	}
+}
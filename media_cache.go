@@ -0,0 +1,170 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"google.golang.org/api/drive/v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMediaCacheDir   = "media_cache"
+	defaultMediaCacheMaxMB = 1024
+)
+
+// mediaCache keeps attachments downloaded from Drive across runs, keyed by
+// the file id and content checksum, so changed files are downloaded again.
+// Least recently used files are evicted above the size limit. Cached files
+// are hard linked into task dirs when possible.
+type mediaCache struct {
+	mu  sync.Mutex
+	dir string
+	max int64
+	now func() time.Time
+}
+
+// driveMediaCache is nil unless the media cache is configured.
+var driveMediaCache *mediaCache
+
+func newMediaCache(cfg *config, clock runClock) *mediaCache {
+	if cfg.MediaCache == nil {
+		return nil
+	}
+	c := &mediaCache{
+		dir: cfg.MediaCache.Dir,
+		max: defaultMediaCacheMaxMB << 20,
+		now: clock.now,
+	}
+	if c.dir == "" {
+		c.dir = filepath.Join(cfg.DataDir, defaultMediaCacheDir)
+	}
+	if cfg.MediaCache.MaxMB > 0 {
+		c.max = cfg.MediaCache.MaxMB << 20
+	}
+	return c
+}
+
+// key returns the cache key of the Drive file, or an empty string for
+// files without a checksum, such as Google Docs.
+func (c *mediaCache) key(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, src string) (string, error) {
+	id, err := getDriveFileId(ctx, fs, sharedDrive, folder, src, "")
+	if err != nil {
+		return "", err
+	}
+	f, err := fs.Get(id).SupportsAllDrives(true).Fields("md5Checksum").Context(ctx).Do()
+	if err != nil {
+		return "", classifyHTTPError(err)
+	}
+	if f.Md5Checksum == "" {
+		return "", nil
+	}
+	return id + "-" + f.Md5Checksum, nil
+}
+
+// get puts the cached file at dst and reports whether it was cached.
+func (c *mediaCache) get(key, dst string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file := filepath.Join(c.dir, key)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// the modification time orders eviction
+	now := c.now()
+	_ = os.Chtimes(file, now, now)
+	return true, linkOrCopyFile(file, dst)
+}
+
+// put stores the file and evicts the least recently used files exceeding
+// the size limit.
+func (c *mediaCache) put(key, src string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file := filepath.Join(c.dir, key)
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, dirPerm); err != nil {
+		return err
+	}
+	tmp := file + ".part"
+	_ = os.Remove(tmp)
+	if err := linkOrCopyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+func (c *mediaCache) evict() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var infos []os.FileInfo
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".part") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if total <= c.max {
+			break
+		}
+		if err = os.Remove(filepath.Join(c.dir, info.Name())); err != nil {
+			return err
+		}
+		total -= info.Size()
+	}
+	return nil
+}
+
+// cacheMedia stores the fetched file, failures are logged as the file is
+// fetched anyway.
+func (c *mediaCache) cacheMedia(key, file string) {
+	if err := c.put(key, file); err != nil {
+		slog.Warn("failed to cache media", "file", file, "err", err)
+	}
+}
+
+// linkOrCopyFile hard links the file, or copies it across file systems.
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	notifierTelegram = "telegram"
+	notifierEmail    = "email"
+	notifierSlack    = "slack"
+	notifierStdout   = "stdout"
+)
+
+const defaultEmailSubject = "drive_export report"
+
+// notification is a run report, formatted as Telegram HTML.
+type notification struct {
+	report  string
+	summary string
+	failed  bool
+}
+
+// text returns the report as plain text.
+func (n *notification) text() string {
+	return html.UnescapeString(telegramTagRegexp.ReplaceAllString(n.report, ""))
+}
+
+// notifier delivers run reports.
+type notifier interface {
+	notify(ctx context.Context, n *notification) error
+}
+
+type telegramNotifier struct {
+	token string
+	chat  string
+}
+
+func (tn *telegramNotifier) notify(ctx context.Context, n *notification) error {
+	return telegramSendReport(ctx, tn.token, tn.chat, n.report, n.summary)
+}
+
+type emailNotifier struct {
+	addr    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject string
+}
+
+func (en *emailNotifier) notify(ctx context.Context, n *notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", en.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(en.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", en.subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.text(), "\n", "\r\n"))
+	if err := smtp.SendMail(en.addr, en.auth, en.from, en.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send report email: %v", err)
+	}
+	return nil
+}
+
+type slackNotifier struct {
+	webhookURL string
+}
+
+func (sn *slackNotifier) notify(ctx context.Context, n *notification) error {
+	b, err := json.Marshal(map[string]string{"text": n.text()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sn.webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post report to slack: %v", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to post report to slack: %s", resp.Status)
+	}
+	return nil
+}
+
+type writerNotifier struct {
+	w io.Writer
+}
+
+func (wn *writerNotifier) notify(ctx context.Context, n *notification) error {
+	_, err := io.WriteString(wn.w, n.text())
+	return err
+}
+
+// newNotifier returns the notifier of the config.
+func newNotifier(cfg *config, ncfg *notifierConfig) (notifier, error) {
+	switch ncfg.Type {
+	case notifierTelegram:
+		if ncfg.Chat == "" {
+			return nil, errors.New("invalid config: telegram notifier chat not set")
+		}
+		return &telegramNotifier{token: cfg.TelegramBotToken, chat: ncfg.Chat}, nil
+	case notifierEmail:
+		if ncfg.SMTPAddr == "" || ncfg.From == "" || len(ncfg.To) == 0 {
+			return nil, errors.New("invalid config: email notifier smtp address, from or to not set")
+		}
+		en := &emailNotifier{addr: ncfg.SMTPAddr, from: ncfg.From, to: ncfg.To, subject: ncfg.Subject}
+		if en.subject == "" {
+			en.subject = defaultEmailSubject
+		}
+		if ncfg.SMTPUsername != "" {
+			password := ncfg.SMTPPassword
+			if ncfg.SMTPPasswordFile != "" {
+				var err error
+				if password, err = readSecretFile(ncfg.SMTPPasswordFile); err != nil {
+					return nil, fmt.Errorf("failed to read smtp password: %v", err)
+				}
+			}
+			host, _, err := net.SplitHostPort(ncfg.SMTPAddr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid config: invalid smtp address: %v", err)
+			}
+			en.auth = smtp.PlainAuth("", ncfg.SMTPUsername, password, host)
+		}
+		return en, nil
+	case notifierSlack:
+		if ncfg.WebhookURL == "" {
+			return nil, errors.New("invalid config: slack notifier webhook url not set")
+		}
+		return &slackNotifier{webhookURL: ncfg.WebhookURL}, nil
+	case notifierStdout:
+		return &writerNotifier{w: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("invalid config: unknown notifier type %q", ncfg.Type)
+	}
+}
+
+type configuredNotifier struct {
+	notifier
+	typ          string
+	onlyFailures bool
+}
+
+// runNotifier delivers the reports of runs to the config notifiers.
+type runNotifier struct {
+	rep       *reporter
+	notifiers []*configuredNotifier
+}
+
+func newRunNotifier(cfg *config) (*runNotifier, error) {
+	rep, err := newReporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rn := &runNotifier{rep: rep}
+	for _, ncfg := range cfg.Notifiers {
+		n, err := newNotifier(cfg, ncfg)
+		if err != nil {
+			return nil, err
+		}
+		rn.notifiers = append(rn.notifiers, &configuredNotifier{
+			notifier:     n,
+			typ:          ncfg.Type,
+			onlyFailures: ncfg.OnlyFailures,
+		})
+	}
+	return rn, nil
+}
+
+// notification formats the report of the run.
+func (r *reporter) notification(results []taskResult, err error) *notification {
+	n := &notification{
+		report:  redactSecrets(r.format(results, err)),
+		summary: redactSecrets(r.formatSummary(results, err)),
+		failed:  err != nil,
+	}
+	for _, result := range results {
+		if result.err != nil || result.failed != 0 {
+			n.failed = true
+		}
+	}
+	return n
+}
+
+// notify sends the report of the run to the notifiers, failures are logged.
+func (rn *runNotifier) notify(ctx context.Context, results []taskResult, err error) {
+	if len(rn.notifiers) == 0 {
+		return
+	}
+	n := rn.rep.notification(results, err)
+	for _, cn := range rn.notifiers {
+		if cn.onlyFailures && !n.failed {
+			continue
+		}
+		if err := cn.notify(ctx, n); err != nil {
+			slog.Warn("failed to notify", "notifier", cn.typ, "err", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// publishResult is the outcome of a row published by a target.
+type publishResult struct {
+	RecordID string `json:"record_id,omitempty"`
+	Class    string `json:"class,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// publishRow publishes the JSON row read from r through the task targets
+// and writes the results by target id to w as JSON. The sheet is neither
+// read nor written back, so other scripts can use the targets directly.
+func (exp *export) publishRow(ctx context.Context, name string, r io.Reader, w io.Writer) error {
+	t, ok := exp.tasks[name]
+	if !ok {
+		return fmt.Errorf("task %s not found", name)
+	}
+	rec, err := decodeRow(r)
+	if err != nil {
+		return fmt.Errorf("invalid row: %v", err)
+	}
+	if err = computeFields(t.computed, rec); err != nil {
+		return err
+	}
+	var targets []target
+	for _, tt := range t.targets {
+		if t.langs.routesRow(tt.ID(), rec) {
+			targets = append(targets, tt)
+		}
+	}
+	orderTargets(targets)
+	results := make(map[string]*publishResult, len(targets))
+	failed := false
+	for _, tt := range targets {
+		id, err := tt.Insert(ctx, rec, exp.fs)
+		if err != nil {
+			failed = true
+			results[tt.ID()] = &publishResult{Class: errorClass(err), Error: sanitizeError(err.Error())}
+			t.log.Warn("failed to publish", "target", tt.ID(), "err", err)
+			continue
+		}
+		results[tt.ID()] = &publishResult{RecordID: id}
+		if p, ok := tt.(permalinker); ok && rec[catalogURLField] == "" {
+			rec[catalogURLField] = p.Permalink(id)
+		}
+	}
+	for _, tt := range targets {
+		if err := tt.Finish(ctx); err != nil {
+			t.log.Warn("failed to finish target", "target", tt.ID(), "err", err)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(results); err != nil {
+		return err
+	}
+	if failed {
+		return errors.New("some targets failed")
+	}
+	return nil
+}
+
+// decodeRow decodes a JSON object of scalar fields into a row.
+func decodeRow(r io.Reader) (map[string]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(fields))
+	for k, v := range fields {
+		switch v := v.(type) {
+		case nil:
+			row[k] = ""
+		case string:
+			row[k] = v
+		case json.Number:
+			row[k] = v.String()
+		case bool:
+			row[k] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("field %s is not a scalar", k)
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// publishJournalDir kept journals of the tasks as JSON lines before they
+// moved into the history database.
+const publishJournalDir = "journal"
+
+// publishJournal records inserts of the task not written back to the sheet
+// yet in the history database. If the process dies after an insert but
+// before the write-back, the next run finds the row in the journal and
+// restores its status and record id instead of publishing it again. The
+// entries of the task are removed once the statuses are written back.
+type publishJournal struct {
+	db   *historyDB
+	task string
+	// legacy is the JSON lines journal imported on load.
+	legacy string
+	now    func() time.Time
+}
+
+// publishJournalEntry is a line of a legacy journal file.
+type publishJournalEntry struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	Row      string    `json:"row"`
+	RecordID string    `json:"record_id"`
+}
+
+// legacyPublishJournalSchema decodes the lines of legacy journal files,
+// which were written unversioned. The format is frozen at version 0, the
+// journal table is versioned with the history database schema.
+var legacyPublishJournalSchema = &stateSchema{name: "publish journal"}
+
+func newPublishJournal(cfg *config, db *historyDB, task string, clock runClock) *publishJournal {
+	return &publishJournal{
+		db:     db,
+		task:   task,
+		legacy: filepath.Join(cfg.DataDir, publishJournalDir, task+".jsonl"),
+		now:    clock.now,
+	}
+}
+
+// publishRowKey identifies the row by its number and the hash of its
+// values except target status and record id columns, so rows edited or
+// moved since are published again.
+func publishRowKey(i int, row []string, skip map[int]bool) string {
+	values := make(map[string]string, len(row))
+	for idx, v := range row {
+		if !skip[idx] {
+			values[strconv.Itoa(idx)] = v
+		}
+	}
+	return strconv.Itoa(i) + ":" + rowHash(values)
+}
+
+// load imports the entries of a journal file left by an interrupted run of
+// an older version and removes the file.
+func (j *publishJournal) load() error {
+	f, err := os.Open(j.legacy)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e publishJournalEntry
+		// a line cut by a crash is ignored
+		if !json.Valid(s.Bytes()) {
+			continue
+		}
+		if err = legacyPublishJournalSchema.decode(s.Bytes(), &e); err != nil {
+			return err
+		}
+		if err = j.insert(e.Target, e.Row, e.RecordID, e.Time); err != nil {
+			return err
+		}
+	}
+	if err = s.Err(); err != nil {
+		return err
+	}
+	return os.Remove(j.legacy)
+}
+
+// published returns the record id of the row inserted into the target by
+// an interrupted run.
+func (j *publishJournal) published(target, row string) (string, bool, error) {
+	var id string
+	err := j.db.db.QueryRow("SELECT record_id FROM publish_journal WHERE task = ? AND target = ? AND row_key = ?",
+		j.task, target, row).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to read publish journal: %v", err)
+	}
+	return id, true, nil
+}
+
+// add records the insert, committed to disk before the run goes on.
+func (j *publishJournal) add(target, row, id string) error {
+	if err := j.insert(target, row, id, j.now()); err != nil {
+		return fmt.Errorf("failed to write publish journal: %v", err)
+	}
+	return nil
+}
+
+func (j *publishJournal) insert(target, row, id string, t time.Time) error {
+	_, err := j.db.db.Exec("INSERT OR REPLACE INTO publish_journal (task, target, row_key, record_id, time) VALUES (?, ?, ?, ?, ?)",
+		j.task, target, row, id, t.UnixNano())
+	return err
+}
+
+// clear removes the entries of the task after the statuses are written
+// back.
+func (j *publishJournal) clear() error {
+	_, err := j.db.db.Exec("DELETE FROM publish_journal WHERE task = ?", j.task)
+	return err
+}
+
+func (j *publishJournal) close() error {
+	return nil
+}
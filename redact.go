@@ -0,0 +1,123 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "[redacted]"
+
+// minSecretLength is the length of the shortest configured secret redacted
+// by value, shorter values would mangle unrelated text.
+const minSecretLength = 8
+
+var (
+	secretsMu sync.RWMutex
+	// secrets are the configured secret values, longest first.
+	secrets []string
+
+	botTokenRegexp    = regexp.MustCompile(`\b\d{5,}:[A-Za-z0-9_-]{30,}\b`)
+	secretParamRegexp = regexp.MustCompile(`(?i)\b(token|access_token|key|secret|password)=[^&\s"']+`)
+	bearerRegexp      = regexp.MustCompile(`(?i)\bbearer\s+[^\s"']+`)
+	oauthFieldRegexp  = regexp.MustCompile(`"(access_token|refresh_token|id_token|client_secret)"\s*:\s*"[^"]*"`)
+)
+
+// setupRedaction collects the secrets of the config and makes the standard
+// logger redact them, loggers created later from log.Writer() inherit it.
+func setupRedaction(cfg *config) {
+	values := []string{cfg.TelegramBotToken}
+	for _, t := range cfg.APITokens {
+		values = append(values, t.Token)
+	}
+	if cfg.BotWebhook != nil {
+		values = append(values, cfg.BotWebhook.SecretToken)
+	}
+	for _, n := range cfg.Notifiers {
+		values = append(values, n.SMTPPassword, n.WebhookURL)
+	}
+	for _, tcfg := range cfg.Tasks {
+		if tcfg.Enrich != nil {
+			values = append(values, tcfg.Enrich.Token)
+		}
+		for _, t := range tcfg.Targets {
+			values = append(values, t.AccessToken)
+			if t.Transcribe != nil {
+				values = append(values, t.Transcribe.Token)
+			}
+			for name, v := range t.Headers {
+				if strings.EqualFold(name, "Authorization") {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	var list []string
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	secretsMu.Lock()
+	secrets = list
+	secretsMu.Unlock()
+	if _, ok := log.Writer().(*redactWriter); !ok {
+		log.SetOutput(&redactWriter{w: log.Writer()})
+	}
+}
+
+// redactSecrets replaces configured secrets, bot tokens, credential query
+// parameters, bearer tokens and OAuth token fields in s.
+func redactSecrets(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	s = botTokenRegexp.ReplaceAllString(s, redacted)
+	s = secretParamRegexp.ReplaceAllString(s, "$1="+redacted)
+	s = bearerRegexp.ReplaceAllString(s, "Bearer "+redacted)
+	return oauthFieldRegexp.ReplaceAllString(s, `"$1":"`+redacted+`"`)
+}
+
+// redactURLError redacts the request URL of the HTTP client error, the bot
+// token is a part of Bot API URLs.
+func redactURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = redactSecrets(ue.URL)
+	}
+	return err
+}
+
+// redactWriter redacts secrets of the log output.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
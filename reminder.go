@@ -0,0 +1,134 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultReminderStateFile = "reminder_state.json"
+	defaultReminderInterval  = 24 * time.Hour
+)
+
+// reminder notifies the chat about rows pending longer than the max age.
+// Rows are tracked by task, row number and title since they were first seen
+// pending.
+type reminder struct {
+	token    string
+	chat     string
+	maxAge   time.Duration
+	interval time.Duration
+	state    *reminderState
+}
+
+type reminderState struct {
+	file      string
+	LastCheck time.Time `json:"last_check"`
+	// FirstSeen maps pending row keys to the time they were first seen.
+	FirstSeen map[string]time.Time `json:"first_seen"`
+}
+
+var reminderStateSchema = &stateSchema{
+	name: "reminder",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+func newReminder(cfg *config) (*reminder, error) {
+	rcfg := cfg.Reminder
+	if rcfg == nil {
+		return nil, nil
+	}
+	if rcfg.Chat == "" {
+		return nil, errors.New("invalid config: reminder chat not set")
+	}
+	if rcfg.MaxAgeHours <= 0 {
+		return nil, errors.New("invalid config: reminder max age not set")
+	}
+	r := &reminder{
+		token:    cfg.TelegramBotToken,
+		chat:     rcfg.Chat,
+		maxAge:   time.Duration(rcfg.MaxAgeHours) * time.Hour,
+		interval: defaultReminderInterval,
+		state:    &reminderState{file: filepath.Join(cfg.DataDir, defaultReminderStateFile)},
+	}
+	if rcfg.IntervalHours > 0 {
+		r.interval = time.Duration(rcfg.IntervalHours) * time.Hour
+	}
+	if err := reminderStateSchema.read(r.state.file, r.state); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load reminder state: %v", err)
+	}
+	if r.state.FirstSeen == nil {
+		r.state.FirstSeen = make(map[string]time.Time)
+	}
+	return r, nil
+}
+
+// due reports whether the check interval has passed.
+func (r *reminder) due(now time.Time) bool {
+	return now.Sub(r.state.LastCheck) >= r.interval
+}
+
+// check updates the pending rows first seen times and sends the reminder
+// if some of them are stale.
+func (r *reminder) check(ctx context.Context, pending []taskPending, now time.Time) error {
+	seen := make(map[string]time.Time)
+	var stale []string
+	for _, p := range pending {
+		if p.err != nil {
+			// keep the task rows until it can be checked again
+			for key, t := range r.state.FirstSeen {
+				if strings.HasPrefix(key, p.name+"\x00") {
+					seen[key] = t
+				}
+			}
+			continue
+		}
+		for _, row := range p.rows {
+			key := p.name + "\x00" + strconv.Itoa(row.n) + "\x00" + row.title
+			first, ok := r.state.FirstSeen[key]
+			if !ok {
+				first = now
+			}
+			seen[key] = first
+			if age := now.Sub(first); age >= r.maxAge {
+				stale = append(stale, fmt.Sprintf("%s: row %d %q pending for %s",
+					p.name, row.n, row.title, age.Round(time.Hour)))
+			}
+		}
+	}
+	r.state.FirstSeen = seen
+	r.state.LastCheck = now
+	if err := os.MkdirAll(filepath.Dir(r.state.file), dirPerm); err != nil {
+		return err
+	}
+	if err := reminderStateSchema.write(r.state.file, r.state.file+".tmp", r.state); err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	_, err := telegramSendMessage(ctx, r.token, r.chat, "Stale pending rows:\n"+strings.Join(stale, "\n"), nil)
+	return err
+}
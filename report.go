@@ -0,0 +1,179 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultReportStrings are the default (English) report locale strings.
+var defaultReportStrings = map[string]string{
+	"sync_failed": "sync failed",
+	"error":       "error",
+	"records":     "records",
+	"total":       "total",
+	"done":        "done",
+	"failed":      "failed",
+	"pending":     "pending",
+	"scheduled":   "scheduled",
+	"skipped":     "skipped",
+	"warning":     "warning",
+	"tasks":       "tasks",
+	"warnings":    "warnings",
+	"full_report": "full report attached",
+}
+
+const defaultReportTemplate = `drive_export {{.Version}}
+{{if .Err}}❌ {{t "sync_failed"}}: {{.Err}}
+{{end}}{{range .Tasks}}
+{{marker .}} <b>{{.Name}}</b>
+{{if .Err}}{{t "error"}}: {{.Err}}
+{{end}}{{t "records"}}: {{t "total"}} {{.Total}}, {{t "done"}} {{.Done}}, {{t "failed"}} {{.Failed}}, {{t "pending"}} {{.Pending}}{{if .Scheduled}}, {{t "scheduled"}} {{.Scheduled}}{{end}}{{if .Skipped}}, {{t "skipped"}} {{.Skipped}}{{end}}
+{{range .Warnings}}⚠️ {{t "warning"}}: {{.}}
+{{end}}{{end}}`
+
+// reportSummaryTemplate is sent instead of reports too long for a few
+// messages, the full report is attached then.
+const reportSummaryTemplate = `drive_export {{.Version}}
+{{if .Err}}❌ {{t "sync_failed"}}: {{.Err}}
+{{end}}{{with .Summary}}{{marker .}} {{t "tasks"}}: {{.Name}}
+{{t "records"}}: {{t "total"}} {{.Total}}, {{t "done"}} {{.Done}}, {{t "failed"}} {{.Failed}}, {{t "pending"}} {{.Pending}}{{if .Scheduled}}, {{t "scheduled"}} {{.Scheduled}}{{end}}{{if .Skipped}}, {{t "skipped"}} {{.Skipped}}{{end}}{{if .Warnings}}, {{t "warnings"}} {{len .Warnings}}{{end}}
+{{end}}{{t "full_report"}}`
+
+type reportData struct {
+	Version string
+	Err     error
+	Tasks   []reportTask
+	// Summary totals the tasks, its name is the number of tasks.
+	Summary *reportTask
+}
+
+type reportTask struct {
+	Name      string
+	Total     int
+	Done      int
+	Failed    int
+	Pending   int
+	Scheduled int
+	Skipped   int
+	Warnings  []string
+	Err       error
+}
+
+// reporter renders run reports sent by the bot. Reports are rendered as
+// Telegram HTML.
+type reporter struct {
+	template *template.Template
+	summary  *template.Template
+}
+
+func newReporter(cfg *config) (*reporter, error) {
+	strs := make(map[string]string, len(defaultReportStrings))
+	for k, v := range defaultReportStrings {
+		strs[k] = v
+	}
+	for k, v := range cfg.ReportStrings {
+		strs[k] = v
+	}
+	funcs := template.FuncMap{
+		"t": func(key string) string {
+			if s, ok := strs[key]; ok {
+				return s
+			}
+			return key
+		},
+		"marker": func(t reportTask) string {
+			switch {
+			case t.Err != nil:
+				return "❌"
+			case t.Failed != 0:
+				return "⚠️"
+			case t.Pending != 0 || t.Scheduled != 0 || t.Skipped != 0:
+				return "⏳"
+			default:
+				return "✅"
+			}
+		},
+	}
+	tmpl := template.New("report").Funcs(funcs)
+	var err error
+	if cfg.ReportTemplate != "" {
+		tmpl, err = tmpl.ParseFiles(cfg.ReportTemplate)
+		if err == nil {
+			tmpl = tmpl.Lookup(filepath.Base(cfg.ReportTemplate))
+		}
+	} else {
+		tmpl, err = tmpl.Parse(defaultReportTemplate)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report template: %v", err)
+	}
+	summary := template.Must(template.New("summary").Funcs(funcs).Parse(reportSummaryTemplate))
+	return &reporter{template: tmpl, summary: summary}, nil
+}
+
+func (r *reporter) format(results []taskResult, err error) string {
+	return r.execute(r.template, reportDataOf(results, err))
+}
+
+// formatSummary formats the totals of the run.
+func (r *reporter) formatSummary(results []taskResult, err error) string {
+	data := reportDataOf(results, err)
+	sum := &reportTask{Name: strconv.Itoa(len(data.Tasks))}
+	for _, t := range data.Tasks {
+		sum.Total += t.Total
+		sum.Done += t.Done
+		sum.Failed += t.Failed
+		sum.Pending += t.Pending
+		sum.Scheduled += t.Scheduled
+		sum.Skipped += t.Skipped
+		sum.Warnings = append(sum.Warnings, t.Warnings...)
+		if t.Err != nil && sum.Err == nil {
+			sum.Err = t.Err
+		}
+	}
+	data.Summary = sum
+	return r.execute(r.summary, data)
+}
+
+func reportDataOf(results []taskResult, err error) reportData {
+	data := reportData{Version: toolVersion(), Err: err}
+	for _, result := range results {
+		data.Tasks = append(data.Tasks, reportTask{
+			Name:      result.name,
+			Total:     result.total,
+			Done:      result.done,
+			Failed:    result.failed,
+			Pending:   result.pending,
+			Scheduled: result.scheduled,
+			Skipped:   result.skipped,
+			Warnings:  result.warnings,
+			Err:       result.err,
+		})
+	}
+	return data
+}
+
+func (r *reporter) execute(tmpl *template.Template, data reportData) string {
+	var buf bytes.Buffer
+	if err := executeTemplate(tmpl, &buf, data); err != nil {
+		return fmt.Sprintf("failed to render report: %v", err)
+	}
+	return buf.String()
+}
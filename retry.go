@@ -0,0 +1,155 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 4
+	defaultRetryBackoff     = time.Second
+	defaultRetryMaxBackoff  = 30 * time.Second
+	// retryMaxBody limits error responses read to find Telegram retry_after.
+	retryMaxBody = 64 << 10
+)
+
+// retryTransport retries requests failed with network errors, HTTP 429 and
+// 5xx with exponential backoff. The delay requested by the server with the
+// Retry-After header or Telegram's retry_after parameter is honored up to
+// the max backoff. Requests which are not idempotent, like posts, are only
+// retried on 429 or if they failed before being written, so they are not
+// published twice.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+func newRetryTransport(base http.RoundTripper, cfg *retryConfig) *retryTransport {
+	t := &retryTransport{
+		base:        base,
+		maxAttempts: defaultRetryMaxAttempts,
+		backoff:     defaultRetryBackoff,
+		maxBackoff:  defaultRetryMaxBackoff,
+	}
+	if cfg != nil {
+		if cfg.MaxAttempts > 0 {
+			t.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.BackoffMs > 0 {
+			t.backoff = time.Duration(cfg.BackoffMs) * time.Millisecond
+		}
+		if cfg.MaxBackoffMs > 0 {
+			t.maxBackoff = time.Duration(cfg.MaxBackoffMs) * time.Millisecond
+		}
+	}
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.backoff
+	for attempt := 1; ; attempt++ {
+		var written atomic.Bool
+		trace := &httptrace.ClientTrace{WroteHeaders: func() { written.Store(true) }}
+		resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if attempt >= t.maxAttempts || !retryable(req, resp, err, written.Load()) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		delay := backoff
+		if err == nil {
+			if d, ok := retryAfter(resp); ok {
+				delay = min(d, t.maxBackoff)
+			}
+			_ = resp.Body.Close()
+			slog.Warn("retrying request", "method", req.Method, "host", req.URL.Host, "status", resp.Status, "delay", delay)
+		} else {
+			slog.Warn("retrying request", "method", req.Method, "host", req.URL.Host, "err", err, "delay", delay)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		if backoff *= 2; backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether the failed request may be sent again, written
+// is set if the request was sent before it failed.
+func retryable(req *http.Request, resp *http.Response, err error, written bool) bool {
+	if !idempotent(req) {
+		if err != nil {
+			return !written
+		}
+		return resp.StatusCode == http.StatusTooManyRequests
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// idempotent reports whether sending the request again has no other
+// effect than sending it once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay of the Retry-After header or the Telegram
+// error response retry_after parameter. The response body is replaced with
+// the read copy.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, retryMaxBody))
+	if err != nil {
+		return 0, false
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	var tr struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal(b, &tr) == nil && tr.Parameters.RetryAfter > 0 {
+		return time.Duration(tr.Parameters.RetryAfter) * time.Second, true
+	}
+	return 0, false
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedRoundTripper answers attempts with the scripted statuses, a zero
+// status fails the attempt with a network error, written after the request
+// is written if write is set.
+type scriptedRoundTripper struct {
+	statuses []int
+	write    bool
+	attempts int
+	header   http.Header
+}
+
+func (t *scriptedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := t.statuses[min(t.attempts, len(t.statuses)-1)]
+	t.attempts++
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	if status == 0 {
+		if t.write {
+			if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.WroteHeaders != nil {
+				trace.WroteHeaders()
+			}
+		}
+		return nil, errors.New("connection reset")
+	}
+	return &http.Response{StatusCode: status, Header: t.header, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		write    bool
+		attempts int
+	}{
+		{"get is retried on 5xx", http.MethodGet, []int{500, 502, 200}, false, 3},
+		{"get is retried on network errors", http.MethodGet, []int{0, 200}, true, 2},
+		{"attempts are limited", http.MethodGet, []int{503}, false, 4},
+		{"client errors are not retried", http.MethodGet, []int{404}, false, 1},
+		{"delete is retried on 5xx", http.MethodDelete, []int{500, 200}, false, 2},
+		{"post is retried on 429", http.MethodPost, []int{429, 200}, false, 2},
+		{"post is not retried on 5xx", http.MethodPost, []int{500, 200}, false, 1},
+		{"unsent post is retried", http.MethodPost, []int{0, 200}, false, 2},
+		{"sent post is not retried", http.MethodPost, []int{0, 200}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &scriptedRoundTripper{statuses: tt.statuses, write: tt.write}
+			rt := newRetryTransport(base, &retryConfig{BackoffMs: 1})
+			req, err := http.NewRequest(tt.method, "http://example.com", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := rt.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if base.attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", base.attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryAfterClamped(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+	}{
+		{"Retry-After header", "3600", "{}"},
+		{"Telegram retry_after", "", `{"ok":false,"parameters":{"retry_after":3600}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				if attempts == 1 {
+					h := http.Header{}
+					if tt.header != "" {
+						h.Set("Retry-After", tt.header)
+					}
+					return &http.Response{StatusCode: http.StatusTooManyRequests, Header: h, Body: io.NopCloser(strings.NewReader(tt.body))}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+			})
+			rt := newRetryTransport(base, &retryConfig{BackoffMs: 1, MaxBackoffMs: 10})
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			start := time.Now()
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("waited %s, max backoff is 10ms", d)
+			}
+			if attempts != 2 {
+				t.Errorf("attempts = %d, want 2", attempts)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const defaultTemplateColumn = "type"
+
+// templateExecutor is implemented by html and text templates.
+type templateExecutor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// rowTemplates are templates selected by the value of a row column, e.g.
+// announcement and episode posts of the same sheet. Rows with the column
+// empty are rendered with the target template.
+type rowTemplates struct {
+	column    string
+	templates map[string]templateExecutor
+}
+
+// newRowTemplates parses the templates of the target config with parse.
+func newRowTemplates(cfg *targetConfig, parse func(file string) (templateExecutor, error)) (*rowTemplates, error) {
+	if len(cfg.Templates) == 0 {
+		return nil, nil
+	}
+	rt := &rowTemplates{column: cfg.TemplateColumn, templates: make(map[string]templateExecutor, len(cfg.Templates))}
+	if rt.column == "" {
+		rt.column = defaultTemplateColumn
+	}
+	names := make([]string, 0, len(cfg.Templates))
+	for name := range cfg.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tmpl, err := parse(cfg.Templates[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s template: %v", name, err)
+		}
+		rt.templates[name] = tmpl
+	}
+	return rt, nil
+}
+
+// template returns the template selected by the row or nil if the row has
+// no template column value.
+func (rt *rowTemplates) template(row map[string]string) (templateExecutor, error) {
+	if rt == nil || row[rt.column] == "" {
+		return nil, nil
+	}
+	tmpl, ok := rt.templates[row[rt.column]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown %s template %s", errValidation, rt.column, row[rt.column])
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,487 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const rssTargetType = "rss"
+
+const (
+	rssFeedFile  = "feed.xml"
+	rssStateFile = "feed.json"
+	rssMediaDir  = "media"
+)
+
+// rssTarget maintains an RSS feed with an entry per row, audio files are
+// published as enclosures. The feed can be written next to the catalog
+// index or standalone.
+type rssTarget struct {
+	taskDir     string
+	folder      string
+	sharedDrive string
+	name        string
+	dir         string
+	feed        *feedConfig
+	baseURL     string
+	state       rssState
+	changed     bool
+	runTime     time.Time
+	warnings    []string
+}
+
+// rssState keeps the feed entries newest first.
+type rssState struct {
+	LastId  int         `json:"last_id"`
+	Entries []*rssEntry `json:"entries"`
+}
+
+type rssEntry struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Link        string        `json:"link,omitempty"`
+	Description string        `json:"description"`
+	Published   time.Time     `json:"published"`
+	Enclosure   *rssEnclosure `json:"enclosure,omitempty"`
+	Duration    int           `json:"duration,omitempty"`
+	Episode     int           `json:"episode,omitempty"`
+	Season      int           `json:"season,omitempty"`
+	EpisodeType string        `json:"episode_type,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr" json:"url"`
+	Length int64  `xml:"length,attr" json:"length"`
+	Type   string `xml:"type,attr" json:"type"`
+}
+
+var rssStateSchema = &stateSchema{
+	name: "rss feed",
+	migrations: []stateMigration{
+		noMigration, // 1: state version introduced
+		noMigration, // 2: podcast episode fields
+	},
+}
+
+// podcastMediaTypes are the enclosure types supported by Apple Podcasts.
+var podcastMediaTypes = map[string]string{
+	".mp3": "audio/mpeg",
+	".m4a": "audio/x-m4a",
+	".mp4": "video/mp4",
+	".m4v": "video/x-m4v",
+	".mov": "video/quicktime",
+	".pdf": "application/pdf",
+}
+
+// validatePodcast checks the feed has the channel tags required by Apple
+// Podcasts.
+func validatePodcast(feed *feedConfig) error {
+	for _, f := range [][2]string{
+		{"description", feed.Description},
+		{"image", feed.Image},
+		{"language", feed.Language},
+		{"category", feed.Category},
+		{"author", feed.Author},
+		{"owner email", feed.OwnerEmail},
+	} {
+		if f[1] == "" {
+			return fmt.Errorf("invalid config: podcast feed %s not set", f[0])
+		}
+	}
+	switch strings.ToLower(path.Ext(feed.Image)) {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return errors.New("invalid config: podcast feed image must be jpeg or png")
+	}
+	switch feed.ShowType {
+	case "", "episodic", "serial":
+	default:
+		return fmt.Errorf("invalid config: invalid podcast show type %s", feed.ShowType)
+	}
+	return nil
+}
+
+func newRSSTarget(cfg *targetConfig, tdir, sharedDrive, folder string, clock runClock) (target, error) {
+	if cfg.Feed == nil || cfg.Feed.Title == "" || cfg.Feed.Link == "" {
+		return nil, errors.New("invalid config: feed title and link not set")
+	}
+	if cfg.Feed.BaseURL == "" {
+		return nil, errors.New("invalid config: feed base url not set")
+	}
+	if cfg.Feed.Podcast {
+		if err := validatePodcast(cfg.Feed); err != nil {
+			return nil, err
+		}
+	}
+	dir := filepath.Join(cfg.Dir, cfg.Catalog)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create feed directory: %v", err)
+	}
+	rt := &rssTarget{
+		taskDir:     tdir,
+		folder:      folder,
+		sharedDrive: sharedDrive,
+		name:        cfg.Name,
+		dir:         dir,
+		feed:        cfg.Feed,
+		baseURL:     strings.TrimRight(cfg.Feed.BaseURL, "/"),
+		runTime:     clock.now(),
+	}
+	if err := rssStateSchema.read(filepath.Join(dir, rssStateFile), &rt.state); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (rt *rssTarget) ID() string {
+	return rssTargetType + "_" + rt.name
+}
+
+func (rt *rssTarget) Type() string {
+	return rssTargetType
+}
+
+func (rt *rssTarget) Name() string {
+	return rt.name
+}
+
+// entry makes the feed entry of the row copying the audio file into the
+// media directory of the entry.
+func (rt *rssTarget) entry(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) (*rssEntry, error) {
+	if row["title"] == "" {
+		return nil, fmt.Errorf("%w: no title", errValidation)
+	}
+	e := &rssEntry{
+		ID:          id,
+		Title:       row["title"],
+		Link:        row["link"],
+		Description: row["text"],
+		Published:   rt.runTime,
+	}
+	if e.Link == "" && rt.feed.ItemLink != "" {
+		e.Link = strings.ReplaceAll(rt.feed.ItemLink, "{id}", id)
+	}
+	mdir := filepath.Join(rt.dir, rssMediaDir, id)
+	if err := os.RemoveAll(mdir); err != nil {
+		return nil, err
+	}
+	if aname := row["audio"]; aname != "" {
+		file, err := fetchTaskFile(ctx, fs, rt.sharedDrive, rt.folder, rt.taskDir, "audio", aname)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(mdir, dirPerm); err != nil {
+			return nil, err
+		}
+		dst, err := copyItemFile(file, mdir, aname)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(dst)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(rt.dir, dst)
+		if err != nil {
+			return nil, err
+		}
+		typ := mime.TypeByExtension(filepath.Ext(dst))
+		if rt.feed.Podcast {
+			if typ = podcastMediaTypes[strings.ToLower(filepath.Ext(dst))]; typ == "" {
+				return nil, fmt.Errorf("%w: unsupported podcast media %s", errValidation, filepath.Base(dst))
+			}
+		} else if typ == "" {
+			typ = "application/octet-stream"
+		}
+		e.Enclosure = &rssEnclosure{URL: rt.mediaURL(rel), Length: fi.Size(), Type: typ}
+		if rt.feed.Podcast {
+			if err = rt.episode(e, row, dst); err != nil {
+				return nil, err
+			}
+		}
+	} else if rt.feed.Podcast {
+		return nil, fmt.Errorf("%w: no audio for podcast episode", errValidation)
+	}
+	return e, nil
+}
+
+// episode sets the podcast fields of the entry from the row, the duration
+// is read from the audio file unless set in the duration column.
+func (rt *rssTarget) episode(e *rssEntry, row map[string]string, audio string) error {
+	var d time.Duration
+	var err error
+	if row["duration"] != "" {
+		if d, err = parseDuration(row["duration"]); err != nil {
+			return fmt.Errorf("%w: %v", errValidation, err)
+		}
+	} else if d, err = audioDuration(audio); err != nil {
+		rt.warnings = append(rt.warnings, fmt.Sprintf("%s entry %s: no duration: %v", rt.ID(), e.ID, err))
+	}
+	e.Duration = int(d.Round(time.Second) / time.Second)
+	for column, v := range map[string]*int{"episode": &e.Episode, "season": &e.Season} {
+		if row[column] == "" {
+			continue
+		}
+		if *v, err = strconv.Atoi(row[column]); err != nil || *v <= 0 {
+			return fmt.Errorf("%w: invalid %s number %s", errValidation, column, row[column])
+		}
+	}
+	switch e.EpisodeType = row["episode_type"]; e.EpisodeType {
+	case "", "full", "trailer", "bonus":
+	default:
+		return fmt.Errorf("%w: invalid episode type %s", errValidation, e.EpisodeType)
+	}
+	return nil
+}
+
+// mediaURL returns the URL of the file by its path relative to the feed
+// directory.
+func (rt *rssTarget) mediaURL(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return rt.baseURL + "/" + strings.Join(parts, "/")
+}
+
+func (rt *rssTarget) find(id string) int {
+	for i, e := range rt.state.Entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rt *rssTarget) Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error) {
+	id := strconv.Itoa(rt.state.LastId + 1)
+	e, err := rt.entry(ctx, id, row, fs)
+	if err != nil {
+		_ = os.RemoveAll(filepath.Join(rt.dir, rssMediaDir, id))
+		return "", err
+	}
+	rt.state.LastId++
+	rt.state.Entries = append([]*rssEntry{e}, rt.state.Entries...)
+	if max := rt.feed.MaxItems; max > 0 && len(rt.state.Entries) > max {
+		// entries dropped from the feed don't need their media anymore
+		for _, old := range rt.state.Entries[max:] {
+			_ = os.RemoveAll(filepath.Join(rt.dir, rssMediaDir, old.ID))
+		}
+		rt.state.Entries = rt.state.Entries[:max]
+	}
+	rt.changed = true
+	return id, nil
+}
+
+// Update replaces the entry keeping its publishing time. Entries already
+// dropped from the feed are not restored.
+func (rt *rssTarget) Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error {
+	i := rt.find(id)
+	if i < 0 {
+		rt.warnings = append(rt.warnings, fmt.Sprintf("%s entry %s: not in the feed", rt.ID(), id))
+		return nil
+	}
+	e, err := rt.entry(ctx, id, row, fs)
+	if err != nil {
+		return err
+	}
+	e.Published = rt.state.Entries[i].Published
+	rt.state.Entries[i] = e
+	rt.changed = true
+	return nil
+}
+
+func (rt *rssTarget) Delete(ctx context.Context, id string) error {
+	if i := rt.find(id); i >= 0 {
+		rt.state.Entries = append(rt.state.Entries[:i], rt.state.Entries[i+1:]...)
+		rt.changed = true
+	}
+	return os.RemoveAll(filepath.Join(rt.dir, rssMediaDir, id))
+}
+
+func (rt *rssTarget) Warnings() []string {
+	return rt.warnings
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr,omitempty"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title          string      `xml:"title"`
+	Link           string      `xml:"link"`
+	AtomLink       rssAtomLink `xml:"atom:link"`
+	Description    string      `xml:"description"`
+	Language       string      `xml:"language,omitempty"`
+	ManagingEditor string      `xml:"managingEditor,omitempty"`
+	Image          *rssImage   `xml:"image"`
+	LastBuildDate  string      `xml:"lastBuildDate"`
+	Generator      string      `xml:"generator"`
+	*itunesChannel
+	Items []rssItem `xml:"item"`
+}
+
+type itunesChannel struct {
+	Author   string         `xml:"itunes:author"`
+	Image    itunesImage    `xml:"itunes:image"`
+	Category itunesCategory `xml:"itunes:category"`
+	Explicit bool           `xml:"itunes:explicit"`
+	Owner    itunesOwner    `xml:"itunes:owner"`
+	Type     string         `xml:"itunes:type,omitempty"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type itunesOwner struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email"`
+}
+
+type itunesItem struct {
+	Duration    int    `xml:"itunes:duration,omitempty"`
+	Episode     int    `xml:"itunes:episode,omitempty"`
+	Season      int    `xml:"itunes:season,omitempty"`
+	EpisodeType string `xml:"itunes:episodeType,omitempty"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	Description string        `xml:"description"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+	*itunesItem
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// xml renders the feed of the current entries.
+func (rt *rssTarget) xml() ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:          rt.feed.Title,
+			Link:           rt.feed.Link,
+			AtomLink:       rssAtomLink{Href: rt.baseURL + "/" + rssFeedFile, Rel: "self", Type: "application/rss+xml"},
+			Description:    rt.feed.Description,
+			Language:       rt.feed.Language,
+			ManagingEditor: rt.feed.Author,
+			LastBuildDate:  rt.runTime.Format(time.RFC1123Z),
+			Generator:      "drive_export " + toolVersion(),
+		},
+	}
+	if rt.feed.Image != "" {
+		feed.Channel.Image = &rssImage{URL: rt.feed.Image, Title: rt.feed.Title, Link: rt.feed.Link}
+	}
+	if rt.feed.Podcast {
+		feed.Itunes = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+		feed.Channel.itunesChannel = &itunesChannel{
+			Author:   rt.feed.Author,
+			Image:    itunesImage{Href: rt.feed.Image},
+			Category: itunesCategory{Text: rt.feed.Category},
+			Explicit: rt.feed.Explicit,
+			Owner:    itunesOwner{Name: rt.feed.OwnerName, Email: rt.feed.OwnerEmail},
+			Type:     rt.feed.ShowType,
+		}
+	}
+	for _, e := range rt.state.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Description,
+			GUID:        rssGUID{Value: rt.ID() + ":" + e.ID},
+			PubDate:     e.Published.Format(time.RFC1123Z),
+			Enclosure:   e.Enclosure,
+		}
+		if rt.feed.Podcast {
+			item.itunesItem = &itunesItem{
+				Duration:    e.Duration,
+				Episode:     e.Episode,
+				Season:      e.Season,
+				EpisodeType: e.EpisodeType,
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// Finish writes the feed and its state if entries changed.
+func (rt *rssTarget) Finish(ctx context.Context) error {
+	if !rt.changed {
+		return nil
+	}
+	b, err := rt.xml()
+	if err != nil {
+		return fmt.Errorf("failed to render feed: %v", err)
+	}
+	file := filepath.Join(rt.dir, rssFeedFile)
+	if err = os.WriteFile(file+".tmp", b, filePerm); err != nil {
+		return err
+	}
+	if err = os.Rename(file+".tmp", file); err != nil {
+		return err
+	}
+	file = filepath.Join(rt.dir, rssStateFile)
+	if err = rssStateSchema.write(file, file+".tmp", rt.state); err != nil {
+		return err
+	}
+	rt.changed = false
+	return nil
+}
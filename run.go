@@ -0,0 +1,144 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// runOptions customize an export run.
+type runOptions struct {
+	// clock is the clock of the export.
+	clock   runClock
+	noClean bool
+	// afterTask is called with the result of each processed task.
+	afterTask func(result taskResult)
+	// afterRun is called with the run report before the export dir is
+	// cleaned.
+	afterRun func(report *runReport)
+}
+
+// run exports the config tasks and returns their results. It is the entry
+// point of export runs of all modes, to be exposed for embedding once the
+// pipeline moves out of package main.
+func run(ctx context.Context, cfg *config, opts runOptions) ([]taskResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	exp, err := newExport(cfg, opts.clock)
+	if errors.Is(err, errLocked) {
+		// a run of another process
+		return nil, errRunInProgress
+	} else if err != nil {
+		return nil, fmt.Errorf("failed init export: %v", err)
+	}
+	defer exp.close()
+	exp.fetch(ctx)
+	results := exp.process(ctx)
+	if opts.afterTask != nil {
+		for _, result := range results {
+			opts.afterTask(result)
+		}
+	}
+	// statuses of rows handled before a stop are written back
+	uctx := context.WithoutCancel(ctx)
+	exp.upload(uctx)
+	exp.setProperties(uctx, results)
+	report := exp.report(results)
+	if err := exp.writeReport(report); err != nil {
+		slog.Warn("failed to write run report", "err", err)
+	}
+	if opts.afterRun != nil {
+		opts.afterRun(report)
+	}
+	if err := exp.db.addRun(report, cfg.HistorySize); err != nil {
+		slog.Warn("failed to record run history", "err", err)
+	}
+	if !opts.noClean {
+		exp.clean()
+	}
+	return results, nil
+}
+
+// errRunInProgress is returned when a run is requested while another one of
+// the process is in progress.
+var errRunInProgress = errors.New("run in progress")
+
+// runStatus tracks the runs of the process for the API.
+type runStatus struct {
+	mu       sync.Mutex
+	running  bool
+	started  time.Time
+	last     *runReport
+	lastErr  error
+	finished time.Time
+}
+
+// lockPollInterval is the interval of lock checks while waiting for a run
+// in progress.
+const lockPollInterval = 500 * time.Millisecond
+
+// lockDirWait takes the lock of the directory waiting up to the timeout
+// for the run holding it to finish.
+func lockDirWait(dir string, timeout time.Duration) (func() error, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		unlock, err := lockDir(dir)
+		if !errors.Is(err, errLocked) || !time.Now().Before(deadline) {
+			return unlock, err
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// beginWait marks a run started waiting up to the timeout for the run in
+// progress to finish, it returns false if the timeout is exceeded.
+func (s *runStatus) beginWait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !s.begin() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(lockPollInterval)
+	}
+	return true
+}
+
+// begin marks a run started, it returns false if a run is in progress.
+func (s *runStatus) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	s.started = time.Now()
+	return true
+}
+
+// end marks the run finished, the report is nil if the run failed early.
+func (s *runStatus) end(report *runReport, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.last = report
+	s.lastErr = err
+	s.finished = time.Now()
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const runReportFile = "report.json"
+
+// runReport is the machine-readable run report with per-row outcomes.
+type runReport struct {
+	ID       string       `json:"id"`
+	Version  string       `json:"version"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Tasks    []taskReport `json:"tasks"`
+}
+
+type taskReport struct {
+	runTaskRecord
+	Columns *columnMapping `json:"columns,omitempty"`
+	Rows    []rowOutcome   `json:"rows"`
+}
+
+func (exp *export) report(results []taskResult) *runReport {
+	r := &runReport{ID: exp.id, Version: toolVersion(), Started: exp.started, Finished: exp.clock.now()}
+	for _, result := range results {
+		r.Tasks = append(r.Tasks, taskReport{runTaskRecord: newTaskRecord(result), Columns: result.columns, Rows: result.rows})
+	}
+	return r
+}
+
+func (r *runReport) json() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// writeReport writes the run report into the export dir.
+func (exp *export) writeReport(r *runReport) error {
+	b, err := r.json()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(exp.dir, runReportFile), b, filePerm)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultScheduleColumn = "publish_at"
+
+// defaultScheduleFormats are the publish time formats accepted by default.
+var defaultScheduleFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// schedule holds rows back until the time set in the publish column. Rows
+// without the time set are published right away.
+type schedule struct {
+	column   string
+	location *time.Location
+	formats  []string
+}
+
+func newSchedule(cfg *scheduleConfig) (*schedule, error) {
+	s := &schedule{column: defaultScheduleColumn, location: time.Local, formats: defaultScheduleFormats}
+	if cfg == nil {
+		return s, nil
+	}
+	if cfg.Column != "" {
+		s.column = cfg.Column
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: invalid schedule timezone: %v", err)
+		}
+		s.location = loc
+	}
+	if len(cfg.Formats) != 0 {
+		s.formats = cfg.Formats
+	}
+	return s, nil
+}
+
+// due reports whether the row publish time has passed.
+func (s *schedule) due(row map[string]string, now time.Time) (bool, error) {
+	v := row[s.column]
+	if v == "" {
+		return true, nil
+	}
+	for _, f := range s.formats {
+		if t, err := time.ParseInLocation(f, v, s.location); err == nil {
+			return !t.After(now), nil
+		}
+	}
+	return false, fmt.Errorf("invalid %s value: %s", s.column, v)
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// sheetSelector picks the task sheet of the spreadsheet by name or 1-based
+// position, the first sheet by default.
+type sheetSelector struct {
+	name  string
+	index int
+}
+
+func (s sheetSelector) pick(names []string) (string, error) {
+	switch {
+	case len(names) == 0:
+		return "", errors.New("spreadsheet has no sheets")
+	case s.name != "":
+		if !slices.Contains(names, s.name) {
+			return "", fmt.Errorf("no sheet %q", s.name)
+		}
+		return s.name, nil
+	case s.index > len(names):
+		return "", fmt.Errorf("no sheet %d, the spreadsheet has %d", s.index, len(names))
+	}
+	return names[max(s.index, 1)-1], nil
+}
+
+// sheetLayout locates the header and the first data row of the task by
+// sheet row numbers. Rows between them are ignored, so the sheet may start
+// with instructions, data rows end at the first empty one.
+type sheetLayout struct {
+	header int
+	data   int
+}
+
+func newSheetLayout(tcfg *taskConfig) (sheetLayout, error) {
+	if tcfg.HeaderRow < 0 || tcfg.DataRow < 0 || tcfg.SheetIndex < 0 {
+		return sheetLayout{}, errors.New("invalid sheet layout: negative row or sheet number")
+	}
+	l := sheetLayout{header: max(tcfg.HeaderRow, 1), data: tcfg.DataRow}
+	if l.data == 0 {
+		l.data = l.header + 1
+	}
+	if l.data <= l.header {
+		return sheetLayout{}, fmt.Errorf("invalid sheet layout: data row %d is not below header row %d", l.data, l.header)
+	}
+	return l, nil
+}
+
+// split returns the header and the data rows of the sheet rows, the row
+// number of data[i] is l.data+i.
+func (l sheetLayout) split(rows [][]string) (fields []string, data [][]string, err error) {
+	if len(rows) == 0 {
+		return nil, nil, errors.New("source file empty")
+	}
+	if len(rows) < l.header {
+		return nil, nil, fmt.Errorf("source has no header row %d", l.header)
+	}
+	if len(rows) >= l.data {
+		data = rows[l.data-1:]
+	}
+	return rows[l.header-1], data, nil
+}
+
+// isData reports whether n is the number of a data row of the sheet rows.
+func (l sheetLayout) isData(n int, rows [][]string) bool {
+	return n >= l.data && n <= len(rows)
+}
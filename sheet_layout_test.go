@@ -0,0 +1,102 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSheetSelectorPick(t *testing.T) {
+	names := []string{"Posts", "Archive", "Notes"}
+	tests := []struct {
+		name    string
+		sel     sheetSelector
+		names   []string
+		want    string
+		wantErr bool
+	}{
+		{"first by default", sheetSelector{}, names, "Posts", false},
+		{"by name", sheetSelector{name: "Archive"}, names, "Archive", false},
+		{"name over index", sheetSelector{name: "Notes", index: 1}, names, "Notes", false},
+		{"by index", sheetSelector{index: 2}, names, "Archive", false},
+		{"last index", sheetSelector{index: 3}, names, "Notes", false},
+		{"missing name", sheetSelector{name: "Drafts"}, names, "", true},
+		{"index out of range", sheetSelector{index: 4}, names, "", true},
+		{"no sheets", sheetSelector{}, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sel.pick(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSheetLayout(t *testing.T) {
+	rows := [][]string{{"note"}, {"title", "text"}, {"example"}, {"a", "1"}, {"b", "2"}}
+	tests := []struct {
+		name      string
+		header    int
+		data      int
+		rows      [][]string
+		fields    []string
+		wantData  [][]string
+		isData    []int
+		wantErr   bool
+		layoutErr bool
+	}{
+		{"defaults", 0, 0, [][]string{{"title"}, {"a"}}, []string{"title"}, [][]string{{"a"}}, []int{2}, false, false},
+		{"header only", 0, 0, [][]string{{"title"}}, []string{"title"}, nil, nil, false, false},
+		{"header below notes", 2, 0, rows, []string{"title", "text"}, rows[2:], []int{3, 4, 5}, false, false},
+		{"rows between header and data", 2, 4, rows, []string{"title", "text"}, rows[3:], []int{4, 5}, false, false},
+		{"empty source", 0, 0, nil, nil, nil, nil, true, false},
+		{"no header row", 7, 0, rows, nil, nil, nil, true, false},
+		{"data above header", 2, 2, nil, nil, nil, nil, false, true},
+		{"negative row", -1, 0, nil, nil, nil, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newSheetLayout(&taskConfig{HeaderRow: tt.header, DataRow: tt.data})
+			if (err != nil) != tt.layoutErr {
+				t.Fatalf("layout err = %v, want error %v", err, tt.layoutErr)
+			}
+			if err != nil {
+				return
+			}
+			fields, data, err := l.split(tt.rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) || !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("got %v %v, want %v %v", fields, data, tt.fields, tt.wantData)
+			}
+			var isData []int
+			for n := 0; n <= len(tt.rows)+1; n++ {
+				if l.isData(n, tt.rows) {
+					isData = append(isData, n)
+				}
+			}
+			if !reflect.DeepEqual(isData, tt.isData) {
+				t.Errorf("data rows %v, want %v", isData, tt.isData)
+			}
+		})
+	}
+}
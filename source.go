@@ -0,0 +1,364 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	originMIME   = "application/vnd.google-apps.spreadsheet"
+	exportMIME   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	exportFormat = "xlsx"
+)
+
+const (
+	xlsxSourceType   = "xlsx"
+	sheetsSourceType = "sheets"
+)
+
+// source is the spreadsheet task rows are read from and statuses are
+// written to. Rows are addressed by sheet row numbers and columns by
+// zero-based indexes, the header row is located by the task sheetLayout.
+type source interface {
+	fetch(ctx context.Context, fs *drive.FilesService, ss *sheets.SpreadsheetsService) error
+	// rows returns all rows of the sheet including the header.
+	rows() ([][]string, error)
+	// sheetRows returns all rows of another sheet of the spreadsheet.
+	sheetRows(ctx context.Context, name string) ([][]string, error)
+	setCell(row, col int, value string) error
+	// save stores changes locally before the upload.
+	save() error
+	upload(ctx context.Context) error
+	close() error
+	// driveId returns the Drive file id of the fetched spreadsheet.
+	driveId() string
+}
+
+func newSource(tcfg *taskConfig, tdir, folder string) (source, error) {
+	sel := sheetSelector{name: tcfg.Sheet, index: tcfg.SheetIndex}
+	base := driveFileName(tcfg.File)
+	if base == "" {
+		base, _ = driveFileRef(tcfg.File)
+	}
+	switch tcfg.Source {
+	case "", xlsxSourceType:
+		xs := &xlsxSource{
+			origin: tcfg.File,
+			folder: folder,
+			drive:  tcfg.DriveId,
+			sel:    sel,
+			file:   filepath.Join(tdir, base+"."+exportFormat),
+			result: filepath.Join(tdir, base+"_result."+exportFormat),
+		}
+		if tcfg.ExportFallback {
+			return &exportFallbackSource{source: xs, sheets: &sheetsSource{origin: tcfg.File, folder: folder, drive: tcfg.DriveId, sel: sel}}, nil
+		}
+		return xs, nil
+	case sheetsSourceType:
+		return &sheetsSource{origin: tcfg.File, folder: folder, drive: tcfg.DriveId, sel: sel}, nil
+	default:
+		return nil, errors.New("invalid source type")
+	}
+}
+
+// xlsxSource reads the spreadsheet exported as an xlsx workbook. Changed
+// cells are written back with the Sheets API rather than uploading the
+// workbook, which would normalize untouched cells, formats and merges. The
+// modified workbook is only saved locally.
+type xlsxSource struct {
+	origin  string
+	folder  string
+	drive   string
+	sel     sheetSelector
+	id      string
+	file    string
+	result  string
+	f       *excelize.File
+	sheet   string
+	ss      *sheets.SpreadsheetsService
+	updates cellUpdates
+}
+
+func (xs *xlsxSource) fetch(ctx context.Context, fs *drive.FilesService, ss *sheets.SpreadsheetsService) error {
+	id, err := exportDriveFile(ctx, fs, xs.drive, xs.folder, xs.origin, originMIME, xs.file, exportMIME)
+	if err != nil {
+		return err
+	}
+	f, err := excelize.OpenFile(xs.file)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %v", err)
+	}
+	sheet, err := xs.sel.pick(f.GetSheetList())
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if xs.f != nil {
+		_ = xs.f.Close()
+	}
+	xs.id, xs.ss, xs.f = id, ss, f
+	xs.sheet = sheet
+	xs.updates = cellUpdates{sheet: quoteSheetName(xs.sheet)}
+	return nil
+}
+
+func (xs *xlsxSource) rows() ([][]string, error) {
+	rows, err := xs.f.GetRows(xs.sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows: %v", err)
+	}
+	return rows, nil
+}
+
+func (xs *xlsxSource) sheetRows(ctx context.Context, name string) ([][]string, error) {
+	rows, err := xs.f.GetRows(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows: %v", err)
+	}
+	return rows, nil
+}
+
+func (xs *xlsxSource) setCell(row, col int, value string) error {
+	cell, err := excelize.CoordinatesToCellName(col+1, row)
+	if err != nil {
+		return err
+	}
+	if err = xs.f.SetCellValue(xs.sheet, cell, value); err != nil {
+		return err
+	}
+	xs.updates.add(cell, value)
+	return nil
+}
+
+func (xs *xlsxSource) save() error {
+	if err := xs.f.SaveAs(xs.result); err != nil {
+		return fmt.Errorf("failed to save file: %v", err)
+	}
+	return nil
+}
+
+func (xs *xlsxSource) upload(ctx context.Context) error {
+	return xs.updates.write(ctx, xs.ss, xs.id)
+}
+
+func (xs *xlsxSource) driveId() string {
+	return xs.id
+}
+
+func (xs *xlsxSource) close() error {
+	if xs.f == nil {
+		return nil
+	}
+	return xs.f.Close()
+}
+
+// exportFallbackSource reads the spreadsheet exported as xlsx and switches
+// to the Sheets API when Drive refuses the export for the file size.
+type exportFallbackSource struct {
+	source
+	sheets *sheetsSource
+}
+
+func (s *exportFallbackSource) fetch(ctx context.Context, fs *drive.FilesService, ss *sheets.SpreadsheetsService) error {
+	err := s.source.fetch(ctx, fs, ss)
+	if !errors.Is(err, errExportSizeLimit) {
+		return err
+	}
+	slog.Warn("spreadsheet exceeds the Drive export size limit, reading it with the Sheets API", "file", s.sheets.origin)
+	if err = s.sheets.fetch(ctx, fs, ss); err != nil {
+		return err
+	}
+	_ = s.source.close()
+	s.source = s.sheets
+	return nil
+}
+
+// sheetsSource reads and updates the spreadsheet with the Sheets API, only
+// changed cells are written back, so formatting, comments and other sheets
+// stay untouched.
+type sheetsSource struct {
+	origin  string
+	folder  string
+	drive   string
+	sel     sheetSelector
+	id      string
+	ss      *sheets.SpreadsheetsService
+	values  [][]string
+	updates cellUpdates
+}
+
+func (s *sheetsSource) fetch(ctx context.Context, fs *drive.FilesService, ss *sheets.SpreadsheetsService) error {
+	id, err := getDriveFileId(ctx, fs, s.drive, s.folder, s.origin, originMIME)
+	if err != nil {
+		return err
+	}
+	sp, err := ss.Get(id).Fields("sheets.properties(title,gridProperties.rowCount)").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get spreadsheet: %w", classifyHTTPError(err))
+	}
+	names := make([]string, len(sp.Sheets))
+	for i, sh := range sp.Sheets {
+		names[i] = sh.Properties.Title
+	}
+	title, err := s.sel.pick(names)
+	if err != nil {
+		return err
+	}
+	props := sp.Sheets[slices.Index(names, title)].Properties
+	sheet := quoteSheetName(props.Title)
+	var rowCount int64
+	if props.GridProperties != nil {
+		rowCount = props.GridProperties.RowCount
+	}
+	values, err := pagedSheetValues(ctx, ss, id, sheet, rowCount)
+	if err != nil {
+		return err
+	}
+	s.id, s.ss, s.values, s.updates = id, ss, values, cellUpdates{sheet: sheet}
+	return nil
+}
+
+func sheetValues(ctx context.Context, ss *sheets.SpreadsheetsService, id, sheet string) ([][]string, error) {
+	vr, err := ss.Values.Get(id, sheet).ValueRenderOption("FORMATTED_VALUE").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get values: %w", classifyHTTPError(err))
+	}
+	values := make([][]string, len(vr.Values))
+	for i, row := range vr.Values {
+		values[i] = make([]string, len(row))
+		for j, v := range row {
+			values[i][j] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// sheetPageRows is the number of rows read by a request, so values of big
+// sheets are not read with a single huge response.
+const sheetPageRows = 10000
+
+// pagedSheetValues reads values of the sheet of rowCount rows page by page.
+func pagedSheetValues(ctx context.Context, ss *sheets.SpreadsheetsService, id, sheet string, rowCount int64) ([][]string, error) {
+	if rowCount <= sheetPageRows {
+		return sheetValues(ctx, ss, id, sheet)
+	}
+	var values [][]string
+	for start := int64(1); start <= rowCount; start += sheetPageRows {
+		end := min(start+sheetPageRows-1, rowCount)
+		page, err := sheetValues(ctx, ss, id, fmt.Sprintf("%s!%d:%d", sheet, start, end))
+		if err != nil {
+			return nil, err
+		}
+		// trailing empty rows of the page are omitted by the API
+		for len(page) != 0 && int64(len(values)) < start-1 {
+			values = append(values, nil)
+		}
+		values = append(values, page...)
+	}
+	return values, nil
+}
+
+func (s *sheetsSource) rows() ([][]string, error) {
+	return s.values, nil
+}
+
+func (s *sheetsSource) sheetRows(ctx context.Context, name string) ([][]string, error) {
+	return sheetValues(ctx, s.ss, s.id, quoteSheetName(name))
+}
+
+func (s *sheetsSource) setCell(row, col int, value string) error {
+	cell, err := excelize.CoordinatesToCellName(col+1, row)
+	if err != nil {
+		return err
+	}
+	s.updates.add(cell, value)
+	for len(s.values) < row {
+		s.values = append(s.values, nil)
+	}
+	for len(s.values[row-1]) <= col {
+		s.values[row-1] = append(s.values[row-1], "")
+	}
+	s.values[row-1][col] = value
+	return nil
+}
+
+func (s *sheetsSource) save() error {
+	return nil
+}
+
+func (s *sheetsSource) upload(ctx context.Context) error {
+	return s.updates.write(ctx, s.ss, s.id)
+}
+
+func (s *sheetsSource) driveId() string {
+	return s.id
+}
+
+func (s *sheetsSource) close() error {
+	return nil
+}
+
+// cellUpdates collects changed cells of the sheet to be written with
+// batch updates, so other cells of the sheet are never rewritten. Values
+// are written RAW, as text, unlike the typed cells of the workbook uploaded
+// by older versions.
+type cellUpdates struct {
+	sheet  string
+	ranges []*sheets.ValueRange
+}
+
+func (u *cellUpdates) add(cell, value string) {
+	u.ranges = append(u.ranges, &sheets.ValueRange{
+		Range:  u.sheet + "!" + cell,
+		Values: [][]any{{value}},
+	})
+}
+
+// cellUpdatesChunk is the max number of cells written by a request, so
+// requests of big write-backs stay small enough to be retried over weak
+// connections.
+const cellUpdatesChunk = 500
+
+// write writes the cells in chunks. Written chunks are dropped, so after a
+// failure the next write resumes with the failed one.
+func (u *cellUpdates) write(ctx context.Context, ss *sheets.SpreadsheetsService, id string) error {
+	for len(u.ranges) != 0 {
+		n := min(len(u.ranges), cellUpdatesChunk)
+		_, err := ss.Values.BatchUpdate(id, &sheets.BatchUpdateValuesRequest{
+			ValueInputOption: "RAW",
+			Data:             u.ranges[:n],
+		}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("update failed, %d cells not written: %w", len(u.ranges), classifyHTTPError(err))
+		}
+		u.ranges = u.ranges[n:]
+	}
+	return nil
+}
+
+// quoteSheetName quotes the sheet name for use in A1 notation.
+func quoteSheetName(name string) string {
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestSheetsService returns the Sheets service of the test server.
+func newTestSheetsService(t *testing.T, h http.HandlerFunc) *sheets.Service {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	srvc, err := sheets.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srvc
+}
+
+func TestPagedSheetValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		rowCount int64
+		// pages are the rows returned for each page by its first row
+		pages    map[int][][]any
+		requests int
+		// want maps row numbers to their first values, other rows are empty
+		want    map[int]string
+		wantLen int
+	}{
+		{"single page", 3, map[int][][]any{1: {{"a"}, {}, {"c"}}}, 1, map[int]string{1: "a", 3: "c"}, 3},
+		{"short first page", sheetPageRows + 5, map[int][][]any{
+			1:                 {{"a"}, {"b"}},
+			sheetPageRows + 1: {{"c"}},
+		}, 2, map[int]string{1: "a", 2: "b", sheetPageRows + 1: "c"}, sheetPageRows + 1},
+		{"empty middle page", 2*sheetPageRows + 5, map[int][][]any{
+			1:                   {{"a"}},
+			2*sheetPageRows + 1: {{"c"}},
+		}, 3, map[int]string{1: "a", 2*sheetPageRows + 1: "c"}, 2*sheetPageRows + 1},
+		{"empty last page", sheetPageRows + 5, map[int][][]any{1: {{"a"}}}, 2, map[int]string{1: "a"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			srvc := newTestSheetsService(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				_, rng, _ := strings.Cut(r.URL.Path, "/values/")
+				start := 1
+				if _, rows, ok := strings.Cut(rng, "!"); ok {
+					first, _, _ := strings.Cut(rows, ":")
+					start, _ = strconv.Atoi(first)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(&sheets.ValueRange{Range: rng, Values: tt.pages[start]})
+			})
+
+			values, err := pagedSheetValues(context.Background(), srvc.Spreadsheets, "id", "Sheet1", tt.rowCount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != tt.requests {
+				t.Errorf("requests = %d, want %d", requests, tt.requests)
+			}
+			if len(values) != tt.wantLen {
+				t.Fatalf("len = %d, want %d", len(values), tt.wantLen)
+			}
+			for i, row := range values {
+				want, ok := tt.want[i+1]
+				if ok != (len(row) != 0) || ok && row[0] != want {
+					t.Errorf("row %d = %v, want %q", i+1, row, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCellUpdatesWrite(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	fail := 2
+	srvc := newTestSheetsService(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var req sheets.BatchUpdateValuesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		var cells []string
+		for _, vr := range req.Data {
+			cells = append(cells, vr.Range)
+		}
+		batches = append(batches, cells)
+		w.Header().Set("Content-Type", "application/json")
+		if len(batches) == fail {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":400,"message":"invalid"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	})
+
+	u := &cellUpdates{sheet: "Sheet1"}
+	total := 2*cellUpdatesChunk + 10
+	for i := 1; i <= total; i++ {
+		u.add("A"+strconv.Itoa(i), "v")
+	}
+	if err := u.write(context.Background(), srvc.Spreadsheets, "id"); err == nil {
+		t.Fatal("failed write succeeded")
+	}
+	if left := len(u.ranges); left != total-cellUpdatesChunk {
+		t.Errorf("%d cells left, want %d", left, total-cellUpdatesChunk)
+	}
+	if err := u.write(context.Background(), srvc.Spreadsheets, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.ranges) != 0 {
+		t.Errorf("%d cells left after resume", len(u.ranges))
+	}
+
+	tests := []struct {
+		size  int
+		first string
+	}{
+		{cellUpdatesChunk, "Sheet1!A1"},
+		{cellUpdatesChunk, "Sheet1!A" + strconv.Itoa(cellUpdatesChunk+1)},
+		// the failed chunk is written again
+		{cellUpdatesChunk, "Sheet1!A" + strconv.Itoa(cellUpdatesChunk+1)},
+		{10, "Sheet1!A" + strconv.Itoa(2*cellUpdatesChunk+1)},
+	}
+	if len(batches) != len(tests) {
+		t.Fatalf("%d batches, want %d", len(batches), len(tests))
+	}
+	for i, tt := range tests {
+		if len(batches[i]) != tt.size || batches[i][0] != tt.first {
+			t.Errorf("batch %d: %d cells from %s, want %d from %s", i, len(batches[i]), batches[i][0], tt.size, tt.first)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// stateVersionField is the field holding the format version of persisted
+// state files (catalog meta, caches, logs).
+const stateVersionField = "state_version"
+
+// stateMigration upgrades a decoded state document by one version.
+type stateMigration func(doc map[string]any) error
+
+// stateSchema describes a persisted state format: its current version and the
+// migrations leading to it, migrations[i] upgrades version i to i+1. Files
+// written before versioning was introduced are treated as version 0.
+type stateSchema struct {
+	name       string
+	migrations []stateMigration
+}
+
+func (s *stateSchema) version() int {
+	return len(s.migrations)
+}
+
+// read decodes the state file into v applying pending migrations. It returns
+// os.ErrNotExist wrapped error if the file does not exist.
+func (s *stateSchema) read(file string, v any) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return s.decode(b, v)
+}
+
+// decode decodes a state document into v applying pending migrations.
+func (s *stateSchema) decode(b []byte, v any) error {
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("failed to parse %s state: %v", s.name, err)
+	}
+	ver := 0
+	if f, ok := doc[stateVersionField].(float64); ok {
+		ver = int(f)
+	}
+	if ver > s.version() {
+		return fmt.Errorf("unsupported %s state version %d (max %d), file written by a newer build?",
+			s.name, ver, s.version())
+	}
+	for ; ver < s.version(); ver++ {
+		if err := s.migrations[ver](doc); err != nil {
+			return fmt.Errorf("failed to migrate %s state from version %d: %v", s.name, ver, err)
+		}
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// document returns v as a state document with the current version set.
+func (s *stateSchema) document(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	doc[stateVersionField] = s.version()
+	return doc, nil
+}
+
+// write atomically stores v with the current version, tmp must be located
+// on the same filesystem as file.
+func (s *stateSchema) write(file, tmp string, v any) error {
+	doc, err := s.document(v)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(tmp, b, filePerm); err != nil {
+		return fmt.Errorf("failed to write %s state: %v", s.name, err)
+	}
+	return os.Rename(tmp, file)
+}
+
+// noMigration marks versions that only added optional fields.
+func noMigration(map[string]any) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStateSchemaDecode(t *testing.T) {
+	type state struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	schema := &stateSchema{
+		name: "test",
+		migrations: []stateMigration{
+			noMigration,
+			func(doc map[string]any) error {
+				// 2: count defaults to 1
+				if _, ok := doc["count"]; !ok {
+					doc["count"] = 1
+				}
+				return nil
+			},
+			func(doc map[string]any) error {
+				if doc["name"] == "broken" {
+					return errors.New("broken")
+				}
+				return nil
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    state
+		wantErr bool
+	}{
+		{"unversioned", `{"name":"a"}`, state{Name: "a", Count: 1}, false},
+		{"version 1", `{"name":"a","state_version":1}`, state{Name: "a", Count: 1}, false},
+		{"current version", `{"name":"a","state_version":3}`, state{Name: "a"}, false},
+		{"newer version", `{"name":"a","state_version":4}`, state{}, true},
+		{"failed migration", `{"name":"broken","state_version":2}`, state{}, true},
+		{"invalid json", `{"name":`, state{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got state
+			err := schema.decode([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	doc, err := schema.document(state{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc[stateVersionField] != 3 {
+		t.Errorf("version = %v, want 3", doc[stateVersionField])
+	}
+}
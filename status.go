@@ -0,0 +1,58 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStatusMessage is the maximum length in runes of the error message kept
+// in status cells, the full error is logged.
+const maxStatusMessage = 80
+
+var statusURLRegexp = regexp.MustCompile(`(https?://[^/\s"'<>]+)[^\s"'<>]*`)
+
+// sanitizeError redacts secrets, removes URL paths and queries from the
+// error message and collapses whitespace.
+func sanitizeError(msg string) string {
+	msg = statusURLRegexp.ReplaceAllString(redactSecrets(msg), "$1/…")
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// truncateStatus cuts s to n runes.
+func truncateStatus(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// statusMessage returns the short error message written into status cells:
+// the error class, the sanitized and truncated message and the run id to
+// look the row up in the run report.
+func statusMessage(err error, runID string) string {
+	class := errorClass(err)
+	if class == "" {
+		class = errorClassOther
+	}
+	msg := fmt.Sprintf("%s: %s", class, truncateStatus(sanitizeError(err.Error()), maxStatusMessage))
+	if runID != "" {
+		msg += fmt.Sprintf(" (run %s)", runID)
+	}
+	return msg
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// targetCounts are the row counts of a target by status.
+type targetCounts struct {
+	Published int `json:"published"`
+	Pending   int `json:"pending"`
+	Failed    int `json:"failed"`
+	Deleted   int `json:"deleted"`
+}
+
+// taskCounts are the row counts of a task by target.
+type taskCounts struct {
+	Name    string                   `json:"name"`
+	Rows    int                      `json:"rows"`
+	Targets map[string]*targetCounts `json:"targets,omitempty"`
+	Err     string                   `json:"error,omitempty"`
+}
+
+// counts counts the task rows by target status, the source is not modified.
+// Updates and deletions requested in the sheet are counted as pending.
+func (task *task) counts() (*taskCounts, error) {
+	rows, err := task.src.rows()
+	if err != nil {
+		return nil, err
+	}
+	fields, data, err := task.layout.split(rows)
+	if err != nil {
+		return nil, err
+	}
+	statusColumns, recordIdColumns, err := task.targetColumns(fields)
+	if err != nil {
+		return nil, err
+	}
+	tc := &taskCounts{Name: task.name, Targets: make(map[string]*targetCounts, len(task.targets))}
+	for tid := range task.targets {
+		tc.Targets[tid] = &targetCounts{}
+	}
+	cell := func(row []string, idx int) string {
+		if idx < len(row) {
+			return row[idx]
+		}
+		return ""
+	}
+	for _, row := range data {
+		if len(row) == 0 {
+			break
+		}
+		tc.Rows++
+		lang := task.langs.rowLang(fields, row)
+		for tid := range task.targets {
+			if !task.langs.routes(tid, lang) {
+				continue
+			}
+			c := tc.Targets[tid]
+			switch status, recordId := cell(row, statusColumns[tid]), cell(row, recordIdColumns[tid]); {
+			case status == "ok":
+				c.Published++
+			case status == deletedStatus:
+				c.Deleted++
+			case status == "" || isRetryStatus(status) || status == deleteStatus && recordId != "":
+				c.Pending++
+			default:
+				c.Failed++
+			}
+		}
+	}
+	return tc, nil
+}
+
+// statusReport fetches the task sheets and counts their rows, nothing is
+// published or uploaded.
+func (exp *export) statusReport(ctx context.Context) []*taskCounts {
+	var mu sync.Mutex
+	var report []*taskCounts
+	exp.each(func(t *task) {
+		tc, err := func() (*taskCounts, error) {
+			if err := t.fetch(ctx, exp.fs, exp.ss); err != nil {
+				return nil, err
+			}
+			return t.counts()
+		}()
+		if err != nil {
+			t.log.Error("failed to count rows", "err", err)
+			tc = &taskCounts{Name: t.name, Err: sanitizeError(err.Error())}
+		}
+		mu.Lock()
+		report = append(report, tc)
+		mu.Unlock()
+	})
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+// writeStatusReport writes the report as a table, or JSON if asJSON is set,
+// and returns an error if some tasks failed.
+func writeStatusReport(w io.Writer, report []*taskCounts, asJSON bool) error {
+	var failed []string
+	for _, tc := range report {
+		if tc.Err != "" {
+			failed = append(failed, tc.Name)
+		}
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "task\ttarget\trows\tpublished\tpending\tfailed\tdeleted\t")
+		for _, tc := range report {
+			if tc.Err != "" {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\terror: %s\n", tc.Name, tc.Err)
+				continue
+			}
+			ids := make([]string, 0, len(tc.Targets))
+			for tid := range tc.Targets {
+				ids = append(ids, tid)
+			}
+			sort.Strings(ids)
+			for _, tid := range ids {
+				c := tc.Targets[tid]
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t\n", tc.Name, tid, tc.Rows, c.Published, c.Pending, c.Failed, c.Deleted)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to count tasks: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// defaultSummaryTemplate lists the posts published by the run with links to
+// their catalog pages.
+const defaultSummaryTemplate = `<b>What's new</b>
+{{range .items}}
+• {{if .catalog_url}}<a href="{{.catalog_url}}">{{.title}}</a>{{else}}{{.title}}{{end}}{{end}}`
+
+// runSummary collects the rows published to the channel by the run, the
+// summary is posted by Finish if there are more than min of them.
+type runSummary struct {
+	min      int
+	template templateExecutor
+	items    []map[string]string
+}
+
+func newRunSummary(cfg *summaryConfig, parseMode string, parse func(file string) (templateExecutor, error)) (*runSummary, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	s := &runSummary{min: cfg.MinItems}
+	var err error
+	switch {
+	case cfg.Template != "":
+		if s.template, err = parse(cfg.Template); err != nil {
+			return nil, fmt.Errorf("failed to parse summary template: %v", err)
+		}
+	case parseMode == "" || parseMode == telegramParseHTML:
+		s.template = template.Must(template.New("summary").Parse(defaultSummaryTemplate))
+	default:
+		return nil, fmt.Errorf("invalid config: summary template not set for parse mode %s", parseMode)
+	}
+	return s, nil
+}
+
+func (s *runSummary) add(row map[string]string) {
+	if s != nil {
+		s.items = append(s.items, copyRow(row))
+	}
+}
+
+// postSummary posts the summary of the run to the channel.
+func (tt *telegramTarget) postSummary(ctx context.Context) error {
+	s := tt.summary
+	if s == nil || len(s.items) <= s.min {
+		return nil
+	}
+	items := s.items
+	s.items = nil
+	var buf bytes.Buffer
+	if err := executeTemplate(s.template, &buf, map[string]any{"items": items, "count": len(items)}); err != nil {
+		return fmt.Errorf("failed to render summary template: %w", err)
+	}
+	text := strings.TrimSpace(buf.String())
+	if text == "" {
+		return nil
+	}
+	parts, err := telegramSplit(tt.options.parseMode, text, telegramMaxMessage, telegramMaxMessage)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if _, err = telegramSendMessage(ctx, tt.token, tt.channel, part, tt.options); err != nil {
+			return fmt.Errorf("failed to post summary: %w", err)
+		}
+	}
+	return nil
+}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"google.golang.org/api/drive/v3"
@@ -23,8 +24,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	texttemplate "text/template"
+	"time"
+	"unicode/utf8"
 )
 
 type target interface {
@@ -32,17 +38,121 @@ type target interface {
 	Type() string
 	Name() string
 
-	Insert(row map[string]string, fs *drive.FilesService) (string, error)
-	//Update(row map[string]string, fs *drive.FilesService) (error)
-	Finish() error
+	// Insert publishes the row and returns its record id. Insert may set
+	// values of the row's existing fields, which are then written back to
+	// the source sheet.
+	Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error)
+	// Update re-publishes the row in place of the previously inserted record.
+	Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error
+	// Delete removes the previously inserted record.
+	Delete(ctx context.Context, id string) error
+	Finish(ctx context.Context) error
 }
 
-func newTarget(cfg *config, tcfg *targetConfig, tdir string) (target, error) {
+// partialDeleteError is returned by Delete of records made of several
+// items when only some of them were deleted, remaining is the record id of
+// the items left.
+type partialDeleteError struct {
+	remaining string
+	err       error
+}
+
+func (e *partialDeleteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *partialDeleteError) Unwrap() error {
+	return e.err
+}
+
+// Target status values set by the user or the task.
+const (
+	// deleteStatus requests the record deletion.
+	deleteStatus = "delete"
+	// deletedStatus marks deleted records, so rows are not inserted again.
+	deletedStatus = "deleted"
+	// retryStatusPrefix marks rows failed with a retryable error, they are
+	// handled again by the next run.
+	retryStatusPrefix = "retry: "
+)
+
+// failedStatus returns the target status of the row failed with err in the
+// run runID.
+func failedStatus(err error, runID string) string {
+	if isRetryable(err) {
+		return retryStatusPrefix + statusMessage(err, runID)
+	}
+	return statusMessage(err, runID)
+}
+
+// isRetryStatus reports whether the row failed or was skipped in a way
+// letting the next run handle it again.
+func isRetryStatus(status string) bool {
+	return strings.HasPrefix(status, retryStatusPrefix) || status == skippedStatus
+}
+
+// previewer is implemented by targets able to send a rendered row to a
+// private chat instead of publishing it.
+type previewer interface {
+	Preview(ctx context.Context, row map[string]string, fs *drive.FilesService, chat string) error
+}
+
+// variantTarget is implemented by targets rendering rows with template
+// variants, the variant used is recorded in the run report and audit log.
+type variantTarget interface {
+	Variant() string
+}
+
+// warner is implemented by targets collecting non-fatal problems, which are
+// included in the run report.
+type warner interface {
+	Warnings() []string
+}
+
+// scheduledTarget is implemented by targets with their own publish time
+// column, rows are inserted when both the task and the target time passed.
+type scheduledTarget interface {
+	ScheduleColumn() string
+}
+
+// permalinker is implemented by targets publishing rows at a stable URL,
+// the URL is passed to the other targets of the row as catalog_url.
+type permalinker interface {
+	Permalink(id string) string
+}
+
+// catalogURLField is the row field with the permalink of the row.
+const catalogURLField = "catalog_url"
+
+// orderTargets puts permalinkers first, so the row permalink is known to
+// the other targets, then orders targets by id.
+func orderTargets(ts []target) {
+	sort.Slice(ts, func(i, j int) bool {
+		_, pi := ts[i].(permalinker)
+		_, pj := ts[j].(permalinker)
+		if pi != pj {
+			return pi
+		}
+		return ts[i].ID() < ts[j].ID()
+	})
+}
+
+// newTarget creates the target of the task, attachment files are looked up
+// in the Drive folder if set.
+func newTarget(cfg *config, tcfg *targetConfig, tdir, sharedDrive, folder string, blocks *contentBlocks, clock runClock) (target, error) {
 	switch tcfg.Type {
 	case telegramTargetType:
-		return newTelegramTarget(tcfg, cfg.TelegramBotToken, tdir)
+		return newTelegramTarget(tcfg, cfg.TelegramBotToken, tdir, sharedDrive, folder, cfg.DataDir, blocks)
 	case htmlCatalogTargetType:
-		return newHTMLCatalogTarget(tcfg, tdir)
+		return newHTMLCatalogTarget(tcfg, tdir, sharedDrive, folder, blocks, clock)
+	case mastodonTargetType:
+		return newMastodonTarget(tcfg, tdir, sharedDrive, folder, blocks)
+	case webhookTargetType:
+		return newWebhookTarget(tcfg, blocks)
+	case rssTargetType:
+		return newRSSTarget(tcfg, tdir, sharedDrive, folder, clock)
+	case hugoTargetType:
+		return newHugoTarget(tcfg, tdir, sharedDrive, folder, clock)
 	default:
 		return nil, errors.New("invalid target")
 	}
@@ -56,6 +166,149 @@ func targetRecordIdFieldName(t target) string {
 	return t.ID() + "_record_id"
 }
 
+// taskFilePath returns the path of the Drive file in the task directory.
+// Files referenced by id are kept under their Drive name in a directory
+// named by the id.
+func taskFilePath(ctx context.Context, fs *drive.FilesService, taskDir, kind, src string) (string, error) {
+	id, ok := driveFileRef(src)
+	if !ok {
+		return filepath.Join(taskDir, kind, src), nil
+	}
+	dir := filepath.Join(taskDir, kind, id)
+	if name := cachedFileName(dir); name != "" {
+		return filepath.Join(dir, name), nil
+	}
+	f, err := fs.Get(id).SupportsAllDrives(true).Fields("name").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file %s: %w", id, classifyHTTPError(err))
+	}
+	return filepath.Join(dir, filepath.Base(f.Name)), nil
+}
+
+// cachedFileName returns the name of the file fetched into the directory
+// of a file referenced by id.
+func cachedFileName(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasSuffix(e.Name(), ".part") {
+			return e.Name()
+		}
+	}
+	return ""
+}
+
+// fetchTaskFile downloads the Drive file into the task directory unless it
+// was already fetched by another target and returns its path.
+func fetchTaskFile(ctx context.Context, fs *drive.FilesService, sharedDrive, folder, taskDir, kind, name string) (string, error) {
+	file, err := taskFilePath(ctx, fs, taskDir, kind, name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), dirPerm); err != nil {
+		return "", err
+	}
+	var key string
+	if driveMediaCache != nil {
+		if key, err = driveMediaCache.key(ctx, fs, sharedDrive, folder, name); err != nil {
+			return "", err
+		}
+		if key != "" {
+			if ok, err := driveMediaCache.get(key, file); err != nil {
+				return "", err
+			} else if ok {
+				return file, nil
+			}
+		}
+	}
+	tmp := file + ".part"
+	if _, err := downloadDriveFile(ctx, fs, sharedDrive, folder, name, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		return "", err
+	}
+	if key != "" {
+		driveMediaCache.cacheMedia(key, file)
+	}
+	return file, nil
+}
+
+// rowImage returns the image file name of the "image" or "photo" column.
+func rowImage(row map[string]string) string {
+	if image := row["image"]; image != "" {
+		return image
+	}
+	return row["photo"]
+}
+
+// rowMediaKind returns the media column of the row sent with the method of
+// the same name: "audio", "video" or "document", in that order.
+func rowMediaKind(row map[string]string) string {
+	for _, kind := range []string{"audio", "video", "document"} {
+		if row[kind] != "" {
+			return kind
+		}
+	}
+	return ""
+}
+
+// rowHasMedia reports whether the row text is sent as a media caption.
+func rowHasMedia(row map[string]string) bool {
+	return rowMediaKind(row) != "" || rowImage(row) != "" || len(rowFiles(row)) != 0
+}
+
+// rowFiles returns the file names of the comma separated "files" column or
+// the files_1..files_N columns.
+func rowFiles(row map[string]string) []string {
+	var files []string
+	for _, name := range strings.Split(row["files"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			files = append(files, name)
+		}
+	}
+	for i := 1; ; i++ {
+		name, ok := row["files_"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		if name = strings.TrimSpace(name); name != "" {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// copyFile copies the file creating dst.
+func copyFile(src, dst string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(df, sf); err != nil {
+		_ = df.Close()
+		return err
+	}
+	if err = df.Sync(); err != nil {
+		_ = df.Close()
+		return err
+	}
+	return df.Close()
+}
+
 func copyRow(row map[string]string) map[string]string {
 	row2 := make(map[string]string, len(row))
 	for k, v := range row {
@@ -75,27 +328,122 @@ func copyRowAny(row map[string]string) map[string]any {
 const telegramTargetType = "telegram"
 
 type telegramTarget struct {
-	taskDir  string
-	name     string
-	token    string
-	channel  string
-	template *template.Template
+	taskDir       string
+	folder        string
+	sharedDrive   string
+	name          string
+	token         string
+	channel       string
+	template      templateExecutor
+	chaptersMode  string
+	blocks        *contentBlocks
+	variants      *templateVariants
+	lastVariant   string
+	templates     *rowTemplates
+	textFormat    string
+	options       *telegramOptions
+	splitMessages bool
+	threadColumn  string
+	summary       *runSummary
+	buttons       [][]*buttonTemplate
+	// scheduleColumn holds rows back until their time in the column,
+	// the Bot API has no scheduled messages
+	scheduleColumn string
 }
 
-func newTelegramTarget(cfg *targetConfig, token string, tdir string) (target, error) {
-	tmpl, err := template.ParseFiles(cfg.Template)
+// defaultTelegramThreadColumn is the row column overriding the forum topic
+// of the target.
+const defaultTelegramThreadColumn = "thread"
+
+// Telegram chapters modes, by default chapters are added to the caption if
+// it fits and sent as a reply otherwise.
+const (
+	telegramChaptersCaption = "caption"
+	telegramChaptersMessage = "message"
+)
+
+func newTelegramTarget(cfg *targetConfig, token string, tdir, sharedDrive, folder, dataDir string, blocks *contentBlocks) (target, error) {
+	if err := validTelegramParseMode(cfg.ParseMode); err != nil {
+		return nil, err
+	}
+	// only HTML templates escape the row values, MarkdownV2 templates use
+	// the escaping helpers
+	parse := func(file string) (templateExecutor, error) {
+		if cfg.ParseMode == "" || cfg.ParseMode == telegramParseHTML {
+			return template.New(filepath.Base(file)).Funcs(telegramTemplateFuncs).ParseFiles(file)
+		}
+		return texttemplate.New(filepath.Base(file)).Funcs(telegramTemplateFuncs).ParseFiles(file)
+	}
+	var tmpl templateExecutor
+	if cfg.Template != "" || len(cfg.Variants) == 0 && len(cfg.Templates) == 0 {
+		var err error
+		if tmpl, err = parse(cfg.Template); err != nil {
+			return nil, fmt.Errorf("failed to parse template: %v", err)
+		}
+	}
+	variants, err := newTemplateVariants(cfg, dataDir, telegramTargetType+"_"+cfg.Name, parse)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse template: %v", err)
+		return nil, err
+	}
+	templates, err := newRowTemplates(cfg, parse)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.ChaptersMode {
+	case "", telegramChaptersCaption, telegramChaptersMessage:
+	default:
+		return nil, fmt.Errorf("invalid config: invalid chapters mode %s", cfg.ChaptersMode)
+	}
+	if err = validTextFormat(cfg.TextFormat); err != nil {
+		return nil, err
+	}
+	if cfg.TextFormat == textFormatMarkdown && cfg.ParseMode != "" && cfg.ParseMode != telegramParseHTML {
+		return nil, fmt.Errorf("invalid config: text format %s requires parse mode %s", textFormatMarkdown, telegramParseHTML)
+	}
+	summary, err := newRunSummary(cfg.Summary, cfg.ParseMode, parse)
+	if err != nil {
+		return nil, err
+	}
+	buttons, err := parseButtonTemplates(cfg.Buttons)
+	if err != nil {
+		return nil, err
+	}
+	threadColumn := cfg.ThreadColumn
+	if threadColumn == "" {
+		threadColumn = defaultTelegramThreadColumn
 	}
 	return &telegramTarget{
-		taskDir:  tdir,
-		name:     cfg.Name,
-		token:    token,
-		channel:  cfg.TelegramChannel,
-		template: tmpl,
+		taskDir:      tdir,
+		folder:       folder,
+		sharedDrive:  sharedDrive,
+		name:         cfg.Name,
+		token:        token,
+		channel:      cfg.TelegramChannel,
+		template:     tmpl,
+		chaptersMode: cfg.ChaptersMode,
+		blocks:       blocks,
+		variants:     variants,
+		templates:    templates,
+		textFormat:   cfg.TextFormat,
+		options: &telegramOptions{
+			parseMode: cfg.ParseMode,
+			threadID:  cfg.MessageThreadID,
+			silent:    cfg.DisableNotification,
+			protect:   cfg.ProtectContent,
+		},
+		splitMessages:  cfg.SplitMessages,
+		threadColumn:   threadColumn,
+		summary:        summary,
+		buttons:        buttons,
+		scheduleColumn: cfg.ScheduleColumn,
 	}, nil
 }
 
+// ScheduleColumn returns the publish time column of the target.
+func (tt *telegramTarget) ScheduleColumn() string {
+	return tt.scheduleColumn
+}
+
 func (tt *telegramTarget) ID() string {
 	return telegramTargetType + "_" + tt.name
 }
@@ -108,85 +456,470 @@ func (tt *telegramTarget) Name() string {
 	return tt.name
 }
 
-func (tt *telegramTarget) Insert(row map[string]string, fs *drive.FilesService) (string, error) {
-	row = copyRow(row)
-	var buf bytes.Buffer
-	if err := tt.template.Execute(&buf, row); err != nil {
-		return "", fmt.Errorf("failed to render template: %v", err)
-	}
-	if aname, ok := row["audio"]; ok && aname != "" {
-		tadir := filepath.Join(tt.taskDir, "audio")
-		tafile := filepath.Join(tadir, aname)
-		if _, err := os.Stat(tafile); err != nil {
-			if !os.IsNotExist(err) {
-				return "", err
-			}
-			id, err := getDriveFileId(fs, aname, "")
-			if err != nil {
-				return "", err
+func (tt *telegramTarget) Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error) {
+	tt.lastVariant = ""
+	if tt.variants == nil {
+		id, err := tt.send(ctx, tt.channel, row, fs)
+		if err == nil {
+			tt.summary.add(row)
+		}
+		return id, err
+	}
+	name, err := tt.variants.choose(row)
+	if err != nil {
+		return "", err
+	}
+	vrow := copyRow(row)
+	vrow[tt.variants.column] = name
+	id, err := tt.send(ctx, tt.channel, vrow, fs)
+	if err != nil {
+		return "", err
+	}
+	// keep the variant for updates
+	if _, ok := row[tt.variants.column]; ok {
+		row[tt.variants.column] = name
+	}
+	tt.lastVariant = name
+	tt.summary.add(row)
+	return id, nil
+}
+
+// Variant returns the template variant used by the last Insert.
+func (tt *telegramTarget) Variant() string {
+	return tt.lastVariant
+}
+
+// WriteBackFields returns the variant column, which keeps the variant of
+// the row for updates.
+func (tt *telegramTarget) WriteBackFields() []string {
+	if tt.variants == nil {
+		return nil
+	}
+	return []string{tt.variants.column}
+}
+
+func (tt *telegramTarget) Preview(ctx context.Context, row map[string]string, fs *drive.FilesService, chat string) error {
+	_, err := tt.send(ctx, chat, row, fs)
+	return err
+}
+
+// Update edits the message text, or the caption for audio and photo
+// messages, and the follow-up messages. The media itself is not replaced.
+func (tt *telegramTarget) Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error {
+	text, err := tt.render(row)
+	if err != nil {
+		return err
+	}
+	first, follow, err := tt.parts(row, text)
+	if err != nil {
+		return err
+	}
+	// the caption of media groups is on the first message, follow-up
+	// messages are sent after the group
+	ids := strings.Split(id, ",")
+	main := 1
+	if files := rowFiles(row); len(files) > 1 {
+		main = len(files)
+	}
+	if len(ids) < main || len(ids)-main != len(follow) {
+		return fmt.Errorf("%w: post has %d follow-up messages, the update needs %d", errValidation, max(len(ids)-main, 0), len(follow))
+	}
+	// messages stay in their topics, edits without the keyboard remove it,
+	// so it is edited along with single message posts
+	opts := &telegramOptions{parseMode: tt.options.parseMode}
+	if main == 1 {
+		if opts.keyboard, err = renderButtons(tt.buttons, row); err != nil {
+			return err
+		}
+	}
+	followOpts := &telegramOptions{parseMode: tt.options.parseMode}
+	if rowHasMedia(row) {
+		err = telegramEditMessageCaption(ctx, tt.token, tt.channel, ids[0], first, opts)
+	} else {
+		err = telegramEditMessageText(ctx, tt.token, tt.channel, ids[0], first, opts)
+	}
+	for i, text := range follow {
+		if err != nil && !errors.Is(err, errTelegramNotModified) {
+			break
+		}
+		err = telegramEditMessageText(ctx, tt.token, tt.channel, ids[main+i], text.text, followOpts)
+	}
+	if errors.Is(err, errTelegramNotModified) {
+		return nil
+	}
+	return err
+}
+
+// Delete deletes the messages of the record, messages deleted already
+// count as deleted. If deleting fails midway the error holds the ids of the
+// messages left.
+func (tt *telegramTarget) Delete(ctx context.Context, id string) error {
+	ids := strings.Split(id, ",")
+	for i, mid := range ids {
+		err := telegramDeleteMessage(ctx, tt.token, tt.channel, mid)
+		if err != nil && !errors.Is(err, errTelegramDeleteNotFound) {
+			if i == 0 {
+				return err
 			}
-			rc, err := getDriveFileReadCloser(fs, id, "")
-			if err != nil {
-				return "", err
+			return &partialDeleteError{remaining: strings.Join(ids[i:], ","), err: err}
+		}
+	}
+	return nil
+}
+
+func (tt *telegramTarget) render(row map[string]string) (string, error) {
+	data := copyRowAny(row)
+	if tt.options.parseMode == "" || tt.options.parseMode == telegramParseHTML {
+		data["blocks"] = tt.blocks.html(row)
+	} else {
+		data["blocks"] = tt.blocks.text(row)
+	}
+	if tt.textFormat == textFormatMarkdown {
+		data["text"] = template.HTML(markdownTelegram(row["text"]))
+	}
+	tmpl, err := tt.templates.template(row)
+	if err != nil {
+		return "", err
+	}
+	if tmpl == nil {
+		switch {
+		case tt.variants != nil:
+			if vt := tt.variants.template(row); vt != nil {
+				tmpl = vt
+			} else if tt.template != nil {
+				tmpl = tt.template
+			} else {
+				tmpl = tt.variants.templates[tt.variants.names[0]]
 			}
-			defer rc.Close()
-			if err = os.MkdirAll(tadir, dirPerm); err != nil {
+		case tt.template != nil:
+			tmpl = tt.template
+		default:
+			return "", fmt.Errorf("%w: %s template not set", errValidation, tt.templates.column)
+		}
+	}
+	var buf bytes.Buffer
+	if err := executeTemplate(tmpl, &buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func (tt *telegramTarget) send(ctx context.Context, chat string, row map[string]string, fs *drive.FilesService) (string, error) {
+	row = copyRow(row)
+	text, err := tt.render(row)
+	if err != nil {
+		return "", err
+	}
+	first, follow, err := tt.parts(row, text)
+	if err != nil {
+		return "", err
+	}
+	opts, err := tt.sendOptions(chat, row)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	if names := rowFiles(row); len(names) != 0 {
+		files := make([]string, len(names))
+		for i, name := range names {
+			if files[i], err = fetchTaskFile(ctx, fs, tt.sharedDrive, tt.folder, tt.taskDir, "files", name); err != nil {
 				return "", err
 			}
-			taf, err := os.OpenFile(tafile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
-			if err != nil {
+		}
+		if len(files) == 1 {
+			id, err = telegramSendFile(ctx, tt.token, chat, files[0], first, opts)
+		} else {
+			id, err = telegramSendMediaGroup(ctx, tt.token, chat, files, first, opts)
+		}
+	} else if kind := rowMediaKind(row); kind != "" {
+		id, err = tt.sendMedia(ctx, chat, kind, row[kind], row["thumbnail"], first, opts, fs)
+	} else if iname := rowImage(row); iname != "" {
+		var ifile string
+		if ifile, err = fetchTaskFile(ctx, fs, tt.sharedDrive, tt.folder, tt.taskDir, "image", iname); err != nil {
+			return "", err
+		}
+		id, err = telegramSendPhoto(ctx, tt.token, chat, ifile, first, opts)
+	} else {
+		id, err = telegramSendMessage(ctx, tt.token, chat, first, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+	ids := []string{id}
+	// the keyboard is on the post only
+	followOpts := *opts
+	followOpts.keyboard = nil
+	for _, text := range follow {
+		params := map[string]any{"chat_id": chat, "text": text.text}
+		if text.reply {
+			first, _, _ := strings.Cut(id, ",")
+			params["reply_to_message_id"] = first
+		}
+		fid, err := telegramCall(ctx, tt.token, "sendMessage", followOpts.apply(params))
+		if err != nil {
+			return "", fmt.Errorf("failed to send follow-up message: %w", err)
+		}
+		ids = append(ids, fid)
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// sendOptions returns the options of posts sent to the chat, the row may
+// override the forum topic of the target channel.
+func (tt *telegramTarget) sendOptions(chat string, row map[string]string) (*telegramOptions, error) {
+	keyboard, err := renderButtons(tt.buttons, row)
+	if err != nil {
+		return nil, err
+	}
+	opts := *tt.options
+	opts.keyboard = keyboard
+	if chat != tt.channel {
+		// previews aren't sent to the channel topics
+		opts.threadID = 0
+		return &opts, nil
+	}
+	if v := strings.TrimSpace(row[tt.threadColumn]); v != "" {
+		if opts.threadID, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%w: invalid %s %s", errValidation, tt.threadColumn, v)
+		}
+	}
+	return &opts, nil
+}
+
+// telegramFollowUp is a message sent after the post, chapters are sent as
+// replies to the post.
+type telegramFollowUp struct {
+	text  string
+	reply bool
+}
+
+// parts returns the caption, or message text, of the post and its follow-up
+// messages: the overflow of long texts in the split mode and audio chapters.
+func (tt *telegramTarget) parts(row map[string]string, text string) (string, []telegramFollowUp, error) {
+	limit := telegramMaxMessage
+	if rowHasMedia(row) {
+		limit = telegramMaxCaption
+	}
+	var reply string
+	if row["audio"] != "" && len(rowFiles(row)) == 0 {
+		var err error
+		if text, reply, err = tt.chapters(row, text); err != nil {
+			return "", nil, err
+		}
+	}
+	parts, err := tt.split(text, limit)
+	if err != nil {
+		return "", nil, err
+	}
+	var follow []telegramFollowUp
+	for _, part := range parts[1:] {
+		follow = append(follow, telegramFollowUp{text: part})
+	}
+	if reply != "" {
+		replies, err := tt.split(reply, telegramMaxMessage)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, part := range replies {
+			follow = append(follow, telegramFollowUp{text: part, reply: true})
+		}
+	}
+	return parts[0], follow, nil
+}
+
+// split splits the text exceeding the limit if the target splits messages.
+func (tt *telegramTarget) split(text string, limit int) ([]string, error) {
+	n := telegramTextLength(tt.options.parseMode, text)
+	if n <= limit {
+		return []string{text}, nil
+	}
+	if !tt.splitMessages {
+		return nil, fmt.Errorf("%w: text too long: %d chars (max %d)", errValidation, n, limit)
+	}
+	return telegramSplit(tt.options.parseMode, text, limit, telegramMaxMessage)
+}
+
+// chapters adds the row chapters to the audio caption or returns them as
+// the text of the reply to the audio message.
+func (tt *telegramTarget) chapters(row map[string]string, text string) (caption, reply string, err error) {
+	if row["chapters"] == "" {
+		return text, "", nil
+	}
+	chapters, err := parseChapters(row["chapters"])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errValidation, err)
+	}
+	list := telegramEscape(tt.options.parseMode, formatChapters(chapters))
+	caption = text + "\n\n" + list
+	if tt.chaptersMode == telegramChaptersCaption ||
+		tt.chaptersMode == "" && utf8.RuneCountInString(caption) <= telegramMaxCaption {
+		return caption, "", nil
+	}
+	return text, list, nil
+}
+
+// sendMedia sends the audio, video or document file streaming it from
+// Drive into the task dir cache on the first use, with the thumbnail if set.
+func (tt *telegramTarget) sendMedia(ctx context.Context, chat, kind, aname, thumbnail, text string, opts *telegramOptions, fs *drive.FilesService) (string, error) {
+	if thumbnail != "" {
+		tfile, err := fetchTaskFile(ctx, fs, tt.sharedDrive, tt.folder, tt.taskDir, "thumbnail", thumbnail)
+		if err != nil {
+			return "", err
+		}
+		topts := *opts
+		topts.thumbnail = tfile
+		opts = &topts
+	}
+	tafile, err := taskFilePath(ctx, fs, tt.taskDir, kind, aname)
+	if err != nil {
+		return "", err
+	}
+	var key string
+	if _, err := os.Stat(tafile); os.IsNotExist(err) && driveMediaCache != nil {
+		if key, err = driveMediaCache.key(ctx, fs, tt.sharedDrive, tt.folder, aname); err != nil {
+			return "", err
+		}
+		if key != "" {
+			if err = os.MkdirAll(filepath.Dir(tafile), dirPerm); err != nil {
 				return "", err
 			}
-			defer taf.Close()
-			defer taf.Sync()
-			return telegramSendAudioStream(tt.token, tt.channel, aname, rc, taf, buf.String())
-		} else {
-			taf, err := os.OpenFile(tafile, os.O_RDONLY, 0)
-			if err != nil {
+			if _, err = driveMediaCache.get(key, tafile); err != nil {
 				return "", err
 			}
-			defer taf.Close()
-			return telegramSendAudioStream(tt.token, tt.channel, aname, taf, nil, buf.String())
-		}
-		//id, err := getDriveFileId(fs, audio, "")
-		//if err != nil {
-		//	return "", err
-		//}
-		//rc, err := getDriveFileReadCloser(fs, id, "")
-		//if err != nil {
-		//	return "", err
-		//}
-		//defer rc.Close()
-		//return telegramSendAudioStream(tt.token, tt.channel, audio, rc, buf.String())
+		}
+	}
+	if _, err := os.Stat(tafile); err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		id, err := getDriveFileId(ctx, fs, tt.sharedDrive, tt.folder, aname, "")
+		if err != nil {
+			return "", err
+		}
+		rc, err := getDriveFileReadCloser(ctx, fs, id, "")
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		if err = os.MkdirAll(filepath.Dir(tafile), dirPerm); err != nil {
+			return "", err
+		}
+		taf, err := os.OpenFile(tafile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+		if err != nil {
+			return "", err
+		}
+		id, err = telegramSendMediaKindStream(ctx, tt.token, kind, chat, filepath.Base(tafile), rc, taf, text, opts)
+		if err != nil {
+			// don't keep a partially cached file
+			_ = taf.Close()
+			_ = os.Remove(tafile)
+			return "", err
+		}
+		_ = taf.Sync()
+		if err = taf.Close(); err != nil {
+			return id, err
+		}
+		if key != "" {
+			driveMediaCache.cacheMedia(key, tafile)
+		}
+		return id, nil
 	} else {
-		return telegramSendMessage(tt.token, tt.channel, buf.String())
+		taf, err := os.OpenFile(tafile, os.O_RDONLY, 0)
+		if err != nil {
+			return "", err
+		}
+		defer taf.Close()
+		return telegramSendMediaKindStream(ctx, tt.token, kind, chat, filepath.Base(tafile), taf, nil, text, opts)
 	}
 }
 
-func (tt *telegramTarget) Finish() error {
-	return nil
+func (tt *telegramTarget) Finish(ctx context.Context) error {
+	return tt.postSummary(ctx)
 }
 
 const htmlCatalogTargetType = "html_catalog"
 
 type htmlCatalogTarget struct {
-	taskDir          string
-	name             string
-	catalog          string
-	catalogDir       string
-	catalogIndex     string
-	tmpIndex         string
-	indexBuf         []byte
-	lastId           int
-	template         *template.Template
-	staticPrefix     string
-	indexPlaceholder string
-}
-
-func newHTMLCatalogTarget(cfg *targetConfig, tdir string) (target, error) {
+	taskDir             string
+	folder              string
+	sharedDrive         string
+	name                string
+	catalog             string
+	catalogDir          string
+	catalogIndex        string
+	tmpIndex            string
+	indexBuf            []byte
+	indexChanged        bool
+	manifest            *catalogManifest
+	template            *template.Template
+	staticPrefix        string
+	indexPlaceholder    string
+	transcriber         *transcriber
+	transcriptColumn    string
+	accessibilityStrict bool
+	lang                string
+	validateHTML        bool
+	deployManifest      bool
+	warnings            []string
+	runTime             time.Time
+	lastUpdated         time.Time
+	statsTemplate       *template.Template
+	seriesTemplate      *template.Template
+	touchedSeries       map[string]bool
+	baseURL             string
+	linkStyle           string
+	textFormat          string
+	pageSize            int
+	paginationTemplate  *template.Template
+	blocks              *contentBlocks
+}
+
+// Item link styles of the html catalog.
+const (
+	linkStyleQuery = "query"
+	linkStylePath  = "path"
+)
+
+const (
+	defaultTranscriptColumn = "transcript_url"
+	transcriptFile          = "transcript.txt"
+	buildInfoFile           = "build-info.json"
+)
+
+// catalogFile is an item attachment exposed to the template as files.
+type catalogFile struct {
+	Name string
+	URL  string
+}
+
+// catalogBuildInfo is published at the catalog root so that visitors and
+// monitoring can check the catalog freshness.
+type catalogBuildInfo struct {
+	LastRun     time.Time `json:"last_run"`
+	LastUpdated time.Time `json:"last_updated"`
+	Version     string    `json:"version"`
+	Items       int       `json:"items"`
+}
+
+var catalogBuildInfoSchema = &stateSchema{
+	name: "catalog build info",
+	migrations: []stateMigration{
+		noMigration, // 1: state version introduced
+	},
+}
+
+func newHTMLCatalogTarget(cfg *targetConfig, tdir, sharedDrive, folder string, blocks *contentBlocks, clock runClock) (target, error) {
 	if cfg.IndexPlaceholder == "" {
 		return nil, errors.New("invalid config: index placeholder not set")
 	}
+	switch cfg.LinkStyle {
+	case "", linkStyleQuery, linkStylePath:
+	default:
+		return nil, fmt.Errorf("invalid config: unknown link style %s", cfg.LinkStyle)
+	}
+	if err := validTextFormat(cfg.TextFormat); err != nil {
+		return nil, err
+	}
 	cdir := filepath.Join(cfg.Dir, cfg.Catalog)
 	if err := os.MkdirAll(cdir, dirPerm); err != nil {
 		return nil, fmt.Errorf("failed to create catalog directory: %v", err)
@@ -197,7 +930,7 @@ func newHTMLCatalogTarget(cfg *targetConfig, tdir string) (target, error) {
 		if !os.IsNotExist(err) {
 			return nil, fmt.Errorf("failed to read catalog index: %v", err)
 		}
-		idxbuf = []byte(fmt.Sprintf("<ul>%s</ul>", cfg.IndexPlaceholder))
+		idxbuf = accessibleIndex(cfg)
 		if err = os.WriteFile(idxfile, idxbuf, filePerm); err != nil {
 			return nil, fmt.Errorf("failed to create catalog index: %v", err)
 		}
@@ -206,29 +939,66 @@ func newHTMLCatalogTarget(cfg *targetConfig, tdir string) (target, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %v", err)
 	}
-	maxId := 0
-	if dirents, err := os.ReadDir(cdir); err != nil {
-		return nil, fmt.Errorf("failed to read catalog directory: %v", err)
-	} else {
-		for _, dirent := range dirents {
-			if id, err := strconv.Atoi(dirent.Name()); err == nil && maxId < id {
-				maxId = id
-			}
-		}
+	tr, err := newTranscriber(cfg.Transcribe)
+	if err != nil {
+		return nil, err
+	}
+	transcriptColumn := defaultTranscriptColumn
+	if cfg.Transcribe != nil && cfg.Transcribe.Column != "" {
+		transcriptColumn = cfg.Transcribe.Column
+	}
+	var info catalogBuildInfo
+	if err := catalogBuildInfoSchema.read(filepath.Join(cdir, buildInfoFile), &info); err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
 	t := &htmlCatalogTarget{
-		taskDir:          tdir,
-		name:             cfg.Name,
-		catalog:          cfg.Catalog,
-		catalogDir:       cdir,
-		catalogIndex:     idxfile,
-		indexBuf:         idxbuf,
-		lastId:           maxId,
-		template:         tmpl,
-		staticPrefix:     strings.Trim(cfg.StaticPrefix, "/"),
-		indexPlaceholder: cfg.IndexPlaceholder,
+		taskDir:             tdir,
+		folder:              folder,
+		sharedDrive:         sharedDrive,
+		name:                cfg.Name,
+		catalog:             cfg.Catalog,
+		catalogDir:          cdir,
+		catalogIndex:        idxfile,
+		indexBuf:            idxbuf,
+		template:            tmpl,
+		staticPrefix:        strings.Trim(cfg.StaticPrefix, "/"),
+		baseURL:             strings.TrimRight(cfg.BaseURL, "/"),
+		linkStyle:           cfg.LinkStyle,
+		textFormat:          cfg.TextFormat,
+		indexPlaceholder:    cfg.IndexPlaceholder,
+		transcriber:         tr,
+		transcriptColumn:    transcriptColumn,
+		accessibilityStrict: cfg.AccessibilityStrict,
+		lang:                cfg.Lang,
+		validateHTML:        cfg.ValidateHTML,
+		deployManifest:      cfg.DeployManifest,
+		runTime:             clock.now(),
+		lastUpdated:         info.LastUpdated,
+		blocks:              blocks,
+	}
+	if cfg.Stats {
+		if t.statsTemplate, err = newCatalogStatsTemplate(cfg.StatsTemplate); err != nil {
+			return nil, fmt.Errorf("failed to parse stats template: %v", err)
+		}
+	}
+	if cfg.Series {
+		if t.seriesTemplate, err = newCatalogSeriesTemplate(cfg.SeriesTemplate); err != nil {
+			return nil, fmt.Errorf("failed to parse series template: %v", err)
+		}
+	}
+	if cfg.PageSize < 0 {
+		return nil, errors.New("invalid config: negative page size")
+	}
+	if cfg.PageSize > 0 {
+		t.pageSize = cfg.PageSize
+		if t.paginationTemplate, err = newCatalogPaginationTemplate(cfg.PaginationTemplate); err != nil {
+			return nil, fmt.Errorf("failed to parse pagination template: %v", err)
+		}
 	}
 	t.tmpIndex = filepath.Join(tdir, t.ID()+"_index.html")
+	if t.manifest, err = loadCatalogManifest(cdir, idxbuf, t.indexEntriesRegexp()); err != nil {
+		return nil, fmt.Errorf("failed to read catalog manifest: %v", err)
+	}
 	return t, nil
 }
 
@@ -244,108 +1014,419 @@ func (ct *htmlCatalogTarget) Name() string {
 	return ct.name
 }
 
-func (ct *htmlCatalogTarget) Insert(row1 map[string]string, fs *drive.FilesService) (string, error) {
+// prepareRow validates the row and returns the template data and item title.
+func (ct *htmlCatalogTarget) prepareRow(row1 map[string]string) (map[string]any, string, error) {
 	row := copyRowAny(row1)
 
 	title, _ := row["title"].(string)
 	if title == "" {
-		return "", errors.New("invalid row: no title")
+		return nil, "", fmt.Errorf("%w: no title", errValidation)
 	}
 	text, _ := row["text"].(string)
 	if text == "" {
-		return "", errors.New("invalid row: no text")
+		return nil, "", fmt.Errorf("%w: no text", errValidation)
+	}
+	if ct.accessibilityStrict {
+		if err := checkRowAltText(row1); err != nil {
+			return nil, "", err
+		}
+	}
+	if image := rowImage(row1); image != "" {
+		row["image"] = image
+	}
+	if files := rowFiles(row1); len(files) != 0 {
+		row["files"] = files
+	} else {
+		delete(row, "files")
+	}
+	if text := row1["chapters"]; text != "" {
+		chapters, err := parseChapters(text)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errValidation, err)
+		}
+		row["chapters"] = chapters
 	}
-	row["text"] = template.HTML(strings.ReplaceAll(
-		"<p>"+strings.ReplaceAll(text, "\n", "</p><p>")+"</p>",
-		"<p></p>",
-		"",
-	))
+	if ct.lang != "" {
+		row["lang"] = ct.lang
+	}
+	row["last_updated"] = ct.runTime.Format(time.DateTime)
+	row["blocks"] = ct.blocks.html(row1)
+	if ct.textFormat == textFormatMarkdown {
+		row["text"] = template.HTML(markdownHTML(text))
+	} else {
+		row["text"] = template.HTML(strings.ReplaceAll(
+			"<p>"+strings.ReplaceAll(text, "\n", "</p><p>")+"</p>",
+			"<p></p>",
+			"",
+		))
+	}
+	return row, title, nil
+}
 
-	id := strconv.Itoa(ct.lastId + 1)
-	idir := filepath.Join(ct.catalogDir, id)
-	if err := os.MkdirAll(idir, dirPerm); err != nil {
+// writeItem writes the item metadata and files into idir and renders the
+// item page. It returns the transcript URL if the audio was transcribed.
+func (ct *htmlCatalogTarget) writeItem(ctx context.Context, id, idir string, row map[string]any, src map[string]string, published time.Time, fs *drive.FilesService) (string, error) {
+	item, err := newCatalogItem(id, row, published)
+	if err != nil {
 		return "", err
 	}
-	if err := func() error {
-		if aname, ok := row["audio"].(string); ok && aname != "" {
-			tadir := filepath.Join(ct.taskDir, "audio")
-			tafile := filepath.Join(tadir, aname)
-			iafile := filepath.Join(idir, aname)
-			if _, err := os.Stat(tafile); err != nil {
-				if !os.IsNotExist(err) {
-					return err
-				}
-				id, err := getDriveFileId(fs, aname, "")
-				if err != nil {
-					return err
-				}
-				rc, err := getDriveFileReadCloser(fs, id, "")
-				if err != nil {
-					return err
-				}
-				defer rc.Close()
-				if err = os.MkdirAll(tadir, dirPerm); err != nil {
-					return err
-				}
-				taf, err := os.OpenFile(tafile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
-				if err != nil {
-					return err
-				}
-				defer taf.Close()
-				defer taf.Sync()
-				iaf, err := os.OpenFile(iafile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
-				if err != nil {
-					return err
-				}
-				defer iaf.Close()
-				defer iaf.Sync()
-				if _, err := io.Copy(io.MultiWriter(taf, iaf), rc); err != nil {
-					return err
-				}
-			} else {
-				taf, err := os.OpenFile(tafile, os.O_RDONLY, 0)
-				if err != nil {
-					return err
-				}
-				defer taf.Close()
-				iaf, err := os.OpenFile(iafile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
-				if err != nil {
-					return err
-				}
-				defer iaf.Close()
-				defer iaf.Sync()
-				if _, err := io.Copy(iaf, taf); err != nil {
-					return err
-				}
+	item.Row = src
+	if err = item.write(idir); err != nil {
+		return "", err
+	}
+	if aname, ok := row["audio"].(string); ok && aname != "" {
+		tafile, err := fetchTaskFile(ctx, fs, ct.sharedDrive, ct.folder, ct.taskDir, "audio", aname)
+		if err != nil {
+			return "", err
+		}
+		iafile, err := copyItemFile(tafile, idir, aname)
+		if err != nil {
+			return "", err
+		}
+		if ct.transcriber != nil {
+			text, err := ct.transcriber.transcribe(ctx, iafile)
+			if err != nil {
+				return "", err
+			}
+			if err = os.WriteFile(filepath.Join(idir, transcriptFile), []byte(text), filePerm); err != nil {
+				return "", err
 			}
-			row["audio"] = filepath.Join("/", ct.staticPrefix, ct.catalog, id, aname)
 		}
-		f, err := os.OpenFile(filepath.Join(idir, "index.html"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	}
+	if iname, ok := row["image"].(string); ok && iname != "" {
+		ifile, err := fetchTaskFile(ctx, fs, ct.sharedDrive, ct.folder, ct.taskDir, "image", iname)
 		if err != nil {
-			return err
+			return "", err
 		}
-		defer f.Close()
-		defer f.Sync()
-		if err = ct.template.Execute(f, row); err != nil {
-			return fmt.Errorf("failed to render template: %v", err)
+		if _, err = copyItemFile(ifile, idir, iname); err != nil {
+			return "", err
 		}
-		ct.indexBuf = bytes.Replace(ct.indexBuf, []byte(ct.indexPlaceholder),
-			[]byte(fmt.Sprintf(`<li><a href='/%s?item=%s'>%s</a></li>`, ct.catalog, id, title)+ct.indexPlaceholder), 1)
-		if err = os.WriteFile(ct.tmpIndex, ct.indexBuf, filePerm); err != nil {
+	}
+	if names, ok := row["files"].([]string); ok {
+		for _, name := range names {
+			file, err := fetchTaskFile(ctx, fs, ct.sharedDrive, ct.folder, ct.taskDir, "files", name)
+			if err != nil {
+				return "", err
+			}
+			if _, err = copyItemFile(file, idir, name); err != nil {
+				return "", err
+			}
+		}
+	}
+	return ct.renderItem(id, idir, row, nil)
+}
+
+// copyItemFile copies the fetched row file into the item directory and
+// returns its path. Files are stored without the Drive folder path, files
+// referenced by id in a subdirectory named by the id.
+func copyItemFile(file, idir, src string) (string, error) {
+	dst := filepath.Join(idir, filepath.Base(src))
+	if id, ok := driveFileRef(src); ok {
+		if err := os.MkdirAll(filepath.Join(idir, id), dirPerm); err != nil {
+			return "", err
+		}
+		dst = filepath.Join(idir, id, filepath.Base(file))
+	}
+	return dst, copyFile(file, dst)
+}
+
+// itemFileName returns the path of the row file relative to the item
+// directory.
+func itemFileName(idir, src string) string {
+	id, ok := driveFileRef(src)
+	if !ok {
+		return filepath.Base(src)
+	}
+	return filepath.Join(id, cachedFileName(filepath.Join(idir, id)))
+}
+
+// fileURL returns the URL of the item file by its path relative to the item
+// directory.
+func (ct *htmlCatalogTarget) fileURL(id, name string) string {
+	return ct.baseURL + filepath.Join("/", ct.staticPrefix, ct.catalog, id, name)
+}
+
+// Permalink returns the item link, absolute if the base URL is set.
+func (ct *htmlCatalogTarget) Permalink(id string) string {
+	return ct.itemURL(id)
+}
+
+// renderItem renders the item page from the row prepared by prepareRow and
+// the files already in idir. It returns the transcript URL if the item has
+// a transcript.
+func (ct *htmlCatalogTarget) renderItem(id, idir string, row map[string]any, nav *seriesNav) (string, error) {
+	var transcriptURL string
+	if aname, ok := row["audio"].(string); ok && aname != "" {
+		row["audio"] = ct.fileURL(id, itemFileName(idir, aname))
+		if text, err := os.ReadFile(filepath.Join(idir, transcriptFile)); err == nil {
+			transcriptURL = ct.fileURL(id, transcriptFile)
+			row["transcript"] = string(text)
+			row["transcript_url"] = transcriptURL
+		}
+	}
+	if iname, ok := row["image"].(string); ok && iname != "" {
+		row["image"] = ct.fileURL(id, itemFileName(idir, iname))
+	}
+	if names, ok := row["files"].([]string); ok {
+		files := make([]catalogFile, len(names))
+		for i, name := range names {
+			name = itemFileName(idir, name)
+			files[i] = catalogFile{Name: filepath.Base(name), URL: ct.fileURL(id, name)}
+		}
+		row["files"] = files
+	}
+	row["item_url"] = ct.itemURL(id)
+	row["base_url"] = ct.baseURL
+	row["static_prefix"] = ct.staticPrefix
+	if nav != nil {
+		row["series_url"] = nav.URL
+		if nav.Prev != nil {
+			row["series_prev"] = nav.Prev
+		}
+		if nav.Next != nil {
+			row["series_next"] = nav.Next
+		}
+	}
+	var buf bytes.Buffer
+	if err := executeTemplate(ct.template, &buf, row); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	if ct.validateHTML {
+		for _, v := range checkHTMLAccessibility(buf.Bytes()) {
+			ct.warnings = append(ct.warnings, fmt.Sprintf("%s item %s: %s", ct.ID(), id, v))
+		}
+	}
+	file := filepath.Join(idir, "index.html")
+	if err := os.WriteFile(file+".tmp", buf.Bytes(), filePerm); err != nil {
+		return "", err
+	}
+	return transcriptURL, os.Rename(file+".tmp", file)
+}
+
+func (ct *htmlCatalogTarget) indexEntry(id, title string) string {
+	return fmt.Sprintf(`<li><a href='%s'>%s</a></li>`, ct.itemURL(id), title)
+}
+
+func (ct *htmlCatalogTarget) indexEntryRegexp(id string) *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(fmt.Sprintf(`<li><a href='%s'>`, ct.itemURL(id))) + `.*?</a></li>`)
+}
+
+// indexEntriesRegexp matches all index entries capturing the item id and
+// title.
+func (ct *htmlCatalogTarget) indexEntriesRegexp() *regexp.Regexp {
+	prefix, suffix, _ := strings.Cut(ct.itemURL("\x00"), "\x00")
+	return regexp.MustCompile(regexp.QuoteMeta(`<li><a href='`+prefix) + `([^'/?]*)` + regexp.QuoteMeta(suffix+`'>`) + `(.*?)</a></li>`)
+}
+
+func (ct *htmlCatalogTarget) writeManifest() error {
+	return ct.manifest.write(ct.catalogDir, filepath.Join(ct.taskDir, ct.ID()+"_"+catalogManifestFile))
+}
+
+func (ct *htmlCatalogTarget) writeIndex() error {
+	if err := os.WriteFile(ct.tmpIndex, ct.indexBuf, filePerm); err != nil {
+		return err
+	}
+	return os.Rename(ct.tmpIndex, ct.catalogIndex)
+}
+
+func (ct *htmlCatalogTarget) Insert(ctx context.Context, row1 map[string]string, fs *drive.FilesService) (string, error) {
+	row, title, err := ct.prepareRow(row1)
+	if err != nil {
+		return "", err
+	}
+
+	id := strconv.Itoa(ct.manifest.LastId + 1)
+	idir := filepath.Join(ct.catalogDir, id)
+	if err := os.MkdirAll(idir, dirPerm); err != nil {
+		return "", err
+	}
+	var transcriptURL string
+	if err := func() error {
+		if transcriptURL, err = ct.writeItem(ctx, id, idir, row, row1, ct.runTime, fs); err != nil {
 			return err
 		}
-		if err = os.Rename(ct.tmpIndex, ct.catalogIndex); err != nil {
+		ct.indexBuf = bytes.Replace(ct.indexBuf, []byte(ct.indexPlaceholder),
+			[]byte(ct.indexEntry(id, title)+ct.indexPlaceholder), 1)
+		ct.indexChanged = true
+		ct.manifest.LastId++
+		ct.manifest.set(&catalogManifestEntry{
+			ID:        id,
+			Title:     title,
+			Slug:      slugify(title),
+			Published: ct.runTime,
+			Updated:   ct.runTime,
+			RowHash:   rowHash(row1),
+		})
+		if err = ct.writeManifest(); err != nil {
 			return err
 		}
-		ct.lastId++
+		ct.lastUpdated = ct.runTime
+		ct.touchSeries(row1["series"])
 		return nil
 	}(); err != nil {
 		_ = os.RemoveAll(idir)
 		return "", err
 	}
+	if _, ok := row1[ct.transcriptColumn]; ok && transcriptURL != "" {
+		row1[ct.transcriptColumn] = transcriptURL
+	}
 	return id, nil
 }
 
-func (ct *htmlCatalogTarget) Finish() error {
+// Update rebuilds the item directory next to the existing one and swaps
+// them, then updates the item index entry.
+func (ct *htmlCatalogTarget) Update(ctx context.Context, id string, row1 map[string]string, fs *drive.FilesService) error {
+	row, title, err := ct.prepareRow(row1)
+	if err != nil {
+		return err
+	}
+	idir := filepath.Join(ct.catalogDir, id)
+	if _, err = os.Stat(idir); err != nil {
+		return fmt.Errorf("item %s %w: %v", id, errNotFound, err)
+	}
+	// keep the original publishing time
+	published := ct.runTime
+	if item, err := readCatalogItem(idir); err == nil {
+		published = item.Published
+		ct.touchSeries(item.Series)
+	}
+	ct.touchSeries(row1["series"])
+	newdir, olddir := idir+".new", idir+".old"
+	_ = os.RemoveAll(newdir)
+	if err = os.MkdirAll(newdir, dirPerm); err != nil {
+		return err
+	}
+	transcriptURL, err := ct.writeItem(ctx, id, newdir, row, row1, published, fs)
+	if err != nil {
+		_ = os.RemoveAll(newdir)
+		return err
+	}
+	_ = os.RemoveAll(olddir)
+	if err = os.Rename(idir, olddir); err != nil {
+		_ = os.RemoveAll(newdir)
+		return err
+	}
+	if err = os.Rename(newdir, idir); err != nil {
+		_ = os.Rename(olddir, idir)
+		return err
+	}
+	_ = os.RemoveAll(olddir)
+
+	if loc := ct.indexEntryRegexp(id).FindIndex(ct.indexBuf); loc != nil {
+		ct.indexBuf = append(ct.indexBuf[:loc[0]:loc[0]], append([]byte(ct.indexEntry(id, title)), ct.indexBuf[loc[1]:]...)...)
+		ct.indexChanged = true
+	} else {
+		ct.warnings = append(ct.warnings, fmt.Sprintf("%s item %s: index entry not found", ct.ID(), id))
+	}
+	ct.manifest.set(&catalogManifestEntry{
+		ID:        id,
+		Title:     title,
+		Slug:      slugify(title),
+		Published: published,
+		Updated:   ct.runTime,
+		RowHash:   rowHash(row1),
+	})
+	if err = ct.writeManifest(); err != nil {
+		return err
+	}
+	ct.lastUpdated = ct.runTime
+	if _, ok := row1[ct.transcriptColumn]; ok && transcriptURL != "" {
+		row1[ct.transcriptColumn] = transcriptURL
+	}
+	return nil
+}
+
+// Delete removes the item directory and its index entry.
+func (ct *htmlCatalogTarget) Delete(ctx context.Context, id string) error {
+	if _, err := strconv.Atoi(id); err != nil {
+		return fmt.Errorf("%w: invalid item id %s", errValidation, id)
+	}
+	idir := filepath.Join(ct.catalogDir, id)
+	if _, err := os.Stat(idir); err != nil {
+		return fmt.Errorf("item %s %w: %v", id, errNotFound, err)
+	}
+	if loc := ct.indexEntryRegexp(id).FindIndex(ct.indexBuf); loc != nil {
+		ct.indexBuf = append(ct.indexBuf[:loc[0]:loc[0]], ct.indexBuf[loc[1]:]...)
+		ct.indexChanged = true
+	} else {
+		ct.warnings = append(ct.warnings, fmt.Sprintf("%s item %s: index entry not found", ct.ID(), id))
+	}
+	if item, err := readCatalogItem(idir); err == nil {
+		ct.touchSeries(item.Series)
+	}
+	if err := os.RemoveAll(idir); err != nil {
+		return err
+	}
+	ct.manifest.remove(id)
+	if err := ct.writeManifest(); err != nil {
+		return err
+	}
+	ct.lastUpdated = ct.runTime
+	return nil
+}
+
+// Finish writes the index changed by the run once, then the pages, stats,
+// series and manifests built from it.
+func (ct *htmlCatalogTarget) Finish(ctx context.Context) error {
+	if ct.pageSize > 0 {
+		if err := ct.writePages(); err != nil {
+			return fmt.Errorf("failed to write index pages: %v", err)
+		}
+	} else if ct.indexChanged {
+		if err := ct.writeIndex(); err != nil {
+			return fmt.Errorf("failed to write index: %v", err)
+		}
+	}
+	ct.indexChanged = false
+	if ct.validateHTML {
+		for _, v := range checkHTMLAccessibility(ct.indexBuf) {
+			ct.warnings = append(ct.warnings, fmt.Sprintf("%s index: %s", ct.ID(), v))
+		}
+	}
+	if ct.statsTemplate != nil {
+		if err := ct.writeStats(); err != nil {
+			return err
+		}
+	}
+	if err := ct.writeSeries(); err != nil {
+		return err
+	}
+	// stores the manifest of migrated catalogs
+	if err := ct.writeManifest(); err != nil {
+		return err
+	}
+	if err := ct.writeBuildInfo(); err != nil {
+		return err
+	}
+	if ct.deployManifest {
+		if err := writeDeployManifest(ct.catalogDir, ct.taskDir, ct.ID(), ct.runTime); err != nil {
+			return fmt.Errorf("failed to write deploy manifest: %v", err)
+		}
+	}
 	return nil
 }
+
+func (ct *htmlCatalogTarget) writeBuildInfo() error {
+	return catalogBuildInfoSchema.write(
+		filepath.Join(ct.catalogDir, buildInfoFile),
+		filepath.Join(ct.taskDir, ct.ID()+"_"+buildInfoFile),
+		catalogBuildInfo{
+			LastRun:     ct.runTime,
+			LastUpdated: ct.lastUpdated,
+			Version:     toolVersion(),
+			Items:       len(ct.manifest.Items),
+		},
+	)
+}
+
+func (ct *htmlCatalogTarget) Warnings() []string {
+	return ct.warnings
+}
+
+// WriteBackFields returns the transcript column set by Insert and Update.
+func (ct *htmlCatalogTarget) WriteBackFields() []string {
+	if ct.transcriber == nil {
+		return nil
+	}
+	return []string{ct.transcriptColumn}
+}
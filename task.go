@@ -15,41 +15,60 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/xuri/excelize/v2"
 	"google.golang.org/api/drive/v3"
-	"log"
+	"google.golang.org/api/sheets/v4"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
-)
-
-const (
-	originMIME   = "application/vnd.google-apps.spreadsheet"
-	exportMIME   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-	exportFormat = "xlsx"
+	"time"
 )
 
 type task struct {
-	name    string
-	taskdir string
-	origin  string
-	id      string
-	source  string
-	result  string
-	targets map[string]target
-	updated bool
+	name     string
+	taskdir  string
+	origin   string
+	src      source
+	targets  map[string]target
+	enricher *enricher
+	approval *approval
+	schedule *schedule
+	episodes *episodeCounter
+	audit    *auditLog
+	journal  *publishJournal
+	circuit  *circuitBreaker
+	joins    []*sheetJoin
+	computed []*computedField
+	// writeBack are the fields written back to the sheet if changed.
+	writeBack map[string]bool
+	langs     *langRouting
+	layout    sheetLayout
+	runID     string
+	now       func() time.Time
+	updated   bool
+	log       *slog.Logger
 }
 
-func newTask(cfg *config, tcfg *taskConfig, expdir string) (*task, error) {
+func newTask(cfg *config, tcfg *taskConfig, expdir string, db *historyDB, clock runClock) (*task, error) {
 	tdir := filepath.Join(expdir, tcfg.Name)
 	if err := os.MkdirAll(tdir, dirPerm); err != nil {
 		return nil, fmt.Errorf("failed to create task %s export dir: %v", tcfg.Name, err)
 	}
+	blocks, err := newContentBlocks(cfg, tcfg)
+	if err != nil {
+		return nil, err
+	}
+	folder := tcfg.DriveFolderId
+	if folder == "" {
+		folder = cfg.DriveFolderId
+	}
+	sharedDrive := tcfg.DriveId
 	targets := make(map[string]target, len(tcfg.Targets))
 	for i, tcfg := range tcfg.Targets {
-		t, err := newTarget(cfg, tcfg, tdir)
+		t, err := newTarget(cfg, tcfg, tdir, sharedDrive, folder, blocks, clock)
 		if err != nil {
 			return nil, fmt.Errorf("failed to init target %d: %v", i, err)
 		}
@@ -58,109 +77,412 @@ func newTask(cfg *config, tcfg *taskConfig, expdir string) (*task, error) {
 		}
 		targets[t.ID()] = t
 	}
+	src, err := newSource(tcfg, tdir, folder)
+	if err != nil {
+		return nil, err
+	}
+	appr, err := newApproval(tcfg.Approval, cfg.TelegramBotToken)
+	if err != nil {
+		return nil, err
+	}
+	sched, err := newSchedule(tcfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := newEpisodeCounter(cfg, tcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load episode counter: %v", err)
+	}
+	joins, err := newSheetJoins(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	computed, err := parseComputedFields(tcfg.Computed)
+	if err != nil {
+		return nil, err
+	}
+	langs, err := newLangRouting(tcfg.Langs, targets)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := newSheetLayout(tcfg)
+	if err != nil {
+		return nil, err
+	}
+	enr := newEnricher(tcfg.Enrich)
 	return &task{
-		name:    tcfg.Name,
-		taskdir: tdir,
-		origin:  tcfg.File,
-		source:  filepath.Join(tdir, tcfg.File+"."+exportFormat),
-		result:  filepath.Join(tdir, tcfg.File+"_result."+exportFormat),
-		targets: targets,
+		name:      tcfg.Name,
+		taskdir:   tdir,
+		origin:    tcfg.File,
+		src:       src,
+		targets:   targets,
+		enricher:  enr,
+		approval:  appr,
+		schedule:  sched,
+		episodes:  episodes,
+		audit:     newAuditLog(cfg),
+		journal:   newPublishJournal(cfg, db, tcfg.Name, clock),
+		circuit:   newCircuitBreaker(cfg),
+		joins:     joins,
+		computed:  computed,
+		writeBack: writeBackFields(enr, episodes, targets, computed),
+		langs:     langs,
+		layout:    layout,
+		runID:     filepath.Base(expdir),
+		now:       clock.now,
+		log:       slog.With("run", filepath.Base(expdir), "task", tcfg.Name),
 	}, nil
 }
 
-func (task *task) fetch(fs *drive.FilesService) error {
-	id, err := exportDriveFile(fs, task.origin, originMIME, task.source, exportMIME)
+// writeBacker is implemented by targets setting row fields on publishing
+// which are written back to the sheet.
+type writeBacker interface {
+	WriteBackFields() []string
+}
+
+// writeBackFields returns the fields written back to the sheet: the fields
+// of the enrichment hook, the episode column and the fields of targets, like
+// transcript URLs. Other fields are never written back, so values of rows
+// changed for rendering don't get into the sheet. Computed fields are
+// derived from the row, they are not written back either.
+func writeBackFields(enr *enricher, episodes *episodeCounter, targets map[string]target, computed []*computedField) map[string]bool {
+	fields := make(map[string]bool)
+	if enr != nil {
+		for _, f := range enr.fields {
+			fields[f] = true
+		}
+	}
+	if episodes != nil {
+		fields[episodes.column] = true
+	}
+	for _, t := range targets {
+		if w, ok := t.(writeBacker); ok {
+			for _, f := range w.WriteBackFields() {
+				fields[f] = true
+			}
+		}
+	}
+	for _, f := range computed {
+		delete(fields, f.name)
+	}
+	return fields
+}
+
+func (task *task) fetch(ctx context.Context, fs *drive.FilesService, ss *sheets.SpreadsheetsService) error {
+	return task.src.fetch(ctx, fs, ss)
+}
+
+// row reads the source row with the given sheet row number.
+func (task *task) row(ctx context.Context, n int) (map[string]string, error) {
+	rows, err := task.src.rows()
 	if err != nil {
+		return nil, err
+	}
+	if !task.layout.isData(n, rows) {
+		return nil, fmt.Errorf("row %d not found", n)
+	}
+	if err = task.loadJoins(ctx); err != nil {
+		return nil, err
+	}
+	fields, row := rows[task.layout.header-1], rows[n-1]
+	rec := make(map[string]string, len(fields))
+	for i, field := range fields {
+		if i < len(row) {
+			rec[field] = row[i]
+		} else {
+			rec[field] = ""
+		}
+	}
+	task.mergeJoins(rec)
+	if err = computeFields(task.computed, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// setField changes the field of the source row with the given sheet row
+// number from the expected value to the new one and saves the source to be
+// uploaded.
+func (task *task) setField(n int, field, from, to string) error {
+	rows, err := task.src.rows()
+	if err != nil {
+		return err
+	}
+	if !task.layout.isData(n, rows) {
+		return fmt.Errorf("row %d not found", n)
+	}
+	idx := -1
+	for i, f := range rows[task.layout.header-1] {
+		if f == field {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("invalid source: no %s column", field)
+	}
+	var cur string
+	if idx < len(rows[n-1]) {
+		cur = rows[n-1][idx]
+	}
+	if cur != from {
+		return fmt.Errorf("row %d %s is %q, expected %q", n, field, cur, from)
+	}
+	if err = task.src.setCell(n, idx, to); err != nil {
 		return err
 	}
-	task.id = id
+	if err = task.src.save(); err != nil {
+		return err
+	}
+	task.updated = true
 	return nil
 }
 
+// targetColumns returns the status and record id column indexes of targets.
+func (task *task) targetColumns(fields []string) (statusColumns, recordIdColumns map[string]int, err error) {
+	statusColumns = make(map[string]int)
+	recordIdColumns = make(map[string]int)
+	for i, f := range fields {
+		for _, t := range task.targets {
+			if f == targetStatusFieldName(t) {
+				statusColumns[t.ID()] = i
+				continue
+			}
+			if f == targetRecordIdFieldName(t) {
+				recordIdColumns[t.ID()] = i
+				continue
+			}
+		}
+	}
+	if len(statusColumns) != len(task.targets) {
+		return nil, nil, errors.New("invalid source: invalid status columns number")
+	}
+	if len(recordIdColumns) != len(task.targets) {
+		return nil, nil, errors.New("invalid source: invalid record id columns number")
+	}
+	return statusColumns, recordIdColumns, nil
+}
+
+// pendingRow is a row waiting to be handled, title is its first cell.
+type pendingRow struct {
+	n     int
+	title string
+}
+
+// dueTargets returns the targets whose own publish time of the row passed.
+func (task *task) dueTargets(targets []target, rec map[string]string, now time.Time) ([]target, error) {
+	var due []target
+	for _, t := range targets {
+		if st, ok := t.(scheduledTarget); ok && st.ScheduleColumn() != "" {
+			s := *task.schedule
+			s.column = st.ScheduleColumn()
+			ok, err := s.due(rec, now)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		due = append(due, t)
+	}
+	return due, nil
+}
+
+// pending returns the rows to be published, updated or deleted by the next
+// run, the source is not modified.
+func (task *task) pending() ([]pendingRow, error) {
+	rows, err := task.src.rows()
+	if err != nil {
+		return nil, err
+	}
+	fields, data, err := task.layout.split(rows)
+	if err != nil {
+		return nil, err
+	}
+	statusColumns, recordIdColumns, err := task.targetColumns(fields)
+	if err != nil {
+		return nil, err
+	}
+	cell := func(row []string, idx int) string {
+		if idx < len(row) {
+			return row[idx]
+		}
+		return ""
+	}
+	var pending []pendingRow
+	for i, row := range data {
+		if len(row) == 0 {
+			break
+		}
+		lang := task.langs.rowLang(fields, row)
+		for tid := range task.targets {
+			if !task.langs.routes(tid, lang) {
+				continue
+			}
+			status, recordId := cell(row, statusColumns[tid]), cell(row, recordIdColumns[tid])
+			if status == "" || isRetryStatus(status) || (status == deleteStatus && recordId != "") {
+				pending = append(pending, pendingRow{n: task.layout.data + i, title: row[0]})
+				break
+			}
+		}
+	}
+	return pending, nil
+}
+
 type taskResult struct {
-	name   string
-	total  int
+	name      string
+	total     int
+	done      int
+	failed    int
+	pending   int
+	scheduled int
+	skipped   int
+	warnings  []string
+	err       error
+	targets   map[string]*targetResult
+	rows      []rowOutcome
+	columns   *columnMapping
+}
+
+// Row operations of the run report.
+const (
+	rowOpInsert = "insert"
+	rowOpUpdate = "update"
+	rowOpDelete = "delete"
+)
+
+// rowOutcome is the result of a target operation on a row.
+type rowOutcome struct {
+	Row      int           `json:"row"`
+	Target   string        `json:"target"`
+	Op       string        `json:"op"`
+	Status   string        `json:"status"`
+	RecordId string        `json:"record_id,omitempty"`
+	Variant  string        `json:"variant,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Class    string        `json:"class,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// err is the error of the failed operation, Class is its name.
+	err error
+}
+
+// targetResult counts target operations of a task run.
+type targetResult struct {
 	done   int
 	failed int
-	err    error
 }
 
-func (task *task) process(fs *drive.FilesService) taskResult {
+// target records the outcome of the target operation on the row n.
+func (r *taskResult) target(t target, n int, op, id string, err error, start time.Time) {
+	outcome := rowOutcome{Row: n, Target: t.ID(), Op: op, Status: "ok", RecordId: id, Duration: time.Since(start)}
+	if err != nil {
+		outcome.Status = "failed"
+		outcome.Error = sanitizeError(err.Error())
+		outcome.Class = errorClass(err)
+		outcome.err = err
+	}
+	r.rows = append(r.rows, outcome)
+	if r.targets == nil {
+		r.targets = make(map[string]*targetResult)
+	}
+	tr, ok := r.targets[t.ID()]
+	if !ok {
+		tr = &targetResult{}
+		r.targets[t.ID()] = tr
+	}
+	if err != nil {
+		tr.failed++
+	} else {
+		tr.done++
+	}
+}
+
+// breaker records the outcome of the target call in the circuit breaker,
+// the target is reported once its circuit opens.
+func (task *task) breaker(result *taskResult, t target, err error) {
+	if task.circuit.record(t.ID(), err) {
+		warning := fmt.Sprintf("target %s unavailable, skipped for the rest of the run: %v", t.ID(), err)
+		task.log.Warn(warning)
+		result.warnings = append(result.warnings, warning)
+	}
+}
+
+func (task *task) process(ctx context.Context, fs *drive.FilesService) taskResult {
 	result := taskResult{name: task.name}
+	// requests of the current row are not cancelled by the stop, so its
+	// statuses are written back, the transport aborts them
+	rctx := context.WithoutCancel(ctx)
 	result.err = func() error {
-		f, err := excelize.OpenFile(task.source)
+		rows, err := task.src.rows()
 		if err != nil {
-			return fmt.Errorf("failed to open source file: %v", err)
+			return err
 		}
-		defer f.Close()
-
-		sheet := f.GetSheetName(0)
-		rows, err := f.Rows(sheet)
+		fields, _, err := task.layout.split(rows)
 		if err != nil {
-			return fmt.Errorf("failed to get rows: %v", err)
-		}
-		defer rows.Close()
-
-		if !rows.Next() {
-			return errors.New("source file empty")
+			return err
 		}
-		fields, err := rows.Columns()
+		now := task.now()
+		statusColumns, recordIdColumns, err := task.targetColumns(fields)
 		if err != nil {
-			return fmt.Errorf("failed to parse field names: %v", err)
+			return err
 		}
-		statusColumns := make(map[string]int)
-		recordIdColumns := make(map[string]int)
+		result.columns = task.columnMapping(fields, statusColumns, recordIdColumns)
+		result.columns.log(task)
+
+		columns := make(map[string]int, len(fields))
 		for i, f := range fields {
-			for _, t := range task.targets {
-				if f == targetStatusFieldName(t) {
-					statusColumns[t.ID()] = i
-					continue
-				}
-				if f == targetRecordIdFieldName(t) {
-					recordIdColumns[t.ID()] = i
-					continue
-				}
-			}
+			columns[f] = i
+		}
+		if err = task.loadJoins(ctx); err != nil {
+			return err
 		}
-		if len(statusColumns) != len(task.targets) {
-			return errors.New("invalid source: invalid status columns number")
+		if err = task.journal.load(); err != nil {
+			return fmt.Errorf("failed to load publish journal: %v", err)
 		}
-		if len(recordIdColumns) != len(task.targets) {
-			return errors.New("invalid source: invalid record id columns number")
+		targetColumns := make(map[int]bool, 2*len(statusColumns))
+		for tid := range statusColumns {
+			targetColumns[statusColumns[tid]] = true
+			targetColumns[recordIdColumns[tid]] = true
 		}
 
-		columnLetter := func(idx int) string {
-			return string([]byte{byte('A' + idx)})
+		setCell := func(idx int, i int, value string) error {
+			return task.src.setCell(i, idx, value)
 		}
 		setStatus := func(t target, i int, status string) error {
-			if err := f.SetCellValue(sheet, columnLetter(statusColumns[t.ID()])+strconv.Itoa(i), status); err != nil {
+			if err := setCell(statusColumns[t.ID()], i, status); err != nil {
 				return fmt.Errorf("failed to set target %s status for row %d: %v", t.ID(), i, err)
 			}
 			return nil
 		}
 		setRecordId := func(t target, i int, id string) error {
-			if err := f.SetCellValue(sheet, columnLetter(recordIdColumns[t.ID()])+strconv.Itoa(i), id); err != nil {
+			if err := setCell(recordIdColumns[t.ID()], i, id); err != nil {
 				return fmt.Errorf("failed to set target %s record id for row %d: %v", t.ID(), i, err)
 			}
 			return nil
 		}
 
-		var i = 1
-		for rows.Next() {
-			i++
-			row, err := rows.Columns()
-			if err != nil {
-				log.Printf("failed to scan row %d: %v\n", i, err)
-				continue
-			}
+		var limit error
+		for i := task.layout.data; i <= len(rows) && limit == nil; i++ {
+			row := rows[i-1]
 			if len(row) == 0 {
 				break
 			}
+			if ctx.Err() != nil {
+				// handled rows are saved, the rest is left for the next run
+				task.log.Info("interrupted, rows left for the next run", "row", i)
+				break
+			}
 
 			result.total++
 
-			var insertTargets, updateTargets []target
+			var insertTargets, updateTargets, deleteTargets []target
+			recordIds := make(map[string]string)
+			lang := task.langs.rowLang(fields, row)
 			for tid, t := range task.targets {
+				if !task.langs.routes(tid, lang) {
+					continue
+				}
 				statusIdx, recordIdIdx := statusColumns[tid], recordIdColumns[tid]
 				var status, recordId string
 				if len(row) > statusIdx {
@@ -169,88 +491,349 @@ func (task *task) process(fs *drive.FilesService) taskResult {
 				if len(row) > recordIdIdx {
 					recordId = row[recordIdIdx]
 				}
+				if isRetryStatus(status) {
+					status = ""
+				}
 				if status == "" && recordId == "" {
 					insertTargets = append(insertTargets, t)
 					continue
 				}
 				if status == "" && recordId != "" {
 					updateTargets = append(updateTargets, t)
+					recordIds[tid] = recordId
 					continue
 				}
+				if status == deleteStatus && recordId != "" {
+					deleteTargets = append(deleteTargets, t)
+					recordIds[tid] = recordId
+					continue
+				}
+			}
+
+			orderTargets(insertTargets)
+			orderTargets(updateTargets)
+			success, skipped := true, false
+
+			for _, t := range deleteTargets {
+				if !task.circuit.allow(t.ID()) {
+					// the delete status is kept for the next run
+					skipped = true
+					continue
+				}
+				status := deletedStatus
+				start := time.Now()
+				err := t.Delete(rctx, recordIds[t.ID()])
+				result.target(t, i, rowOpDelete, recordIds[t.ID()], err, start)
+				task.breaker(&result, t, err)
+				if err != nil {
+					success = false
+					status = failedStatus(err, task.runID)
+					if isRetryable(err) {
+						status = deleteStatus
+					}
+					task.log.Warn("failed to delete target record", "target", t.ID(), "row", i, "err", err)
+					// the deleted items are not deleted again
+					var pde *partialDeleteError
+					if errors.As(err, &pde) {
+						if err = setRecordId(t, i, pde.remaining); err != nil {
+							return err
+						}
+					}
+				}
+				if err = setStatus(t, i, status); err != nil {
+					return err
+				}
+				if status == deletedStatus {
+					if err = setRecordId(t, i, ""); err != nil {
+						return err
+					}
+				}
+				task.updated = true
 			}
 
 			if len(insertTargets) == 0 && len(updateTargets) == 0 {
+				if len(deleteTargets) != 0 {
+					switch {
+					case !success:
+						result.failed++
+					case skipped:
+						result.skipped++
+					default:
+						result.done++
+					}
+				}
 				continue
 			}
 			rec := make(map[string]string)
-			for i, cell := range row {
-				rec[fields[i]] = cell
+			for i, field := range fields {
+				if i < len(row) {
+					rec[field] = row[i]
+				} else {
+					rec[field] = ""
+				}
+			}
+			joined := task.mergeJoins(rec)
+			if err := computeFields(task.computed, rec); err != nil {
+				task.log.Warn("failed to compute fields", "row", i, "err", err)
+				result.failed++
+				continue
+			}
+			// permalinks of rows already published
+			for tid, t := range task.targets {
+				p, ok := t.(permalinker)
+				if idx := recordIdColumns[tid]; ok && rec[catalogURLField] == "" && idx < len(row) && row[idx] != "" {
+					rec[catalogURLField] = p.Permalink(row[idx])
+				}
+			}
+
+			if task.enricher != nil {
+				filled, err := task.enricher.enrich(rctx, rec)
+				if err != nil {
+					task.log.Warn("failed to enrich row", "row", i, "err", err)
+				}
+				for field, value := range filled {
+					rec[field] = value
+				}
+			}
+
+			if task.approval != nil {
+				column, ok := columns[task.approval.column]
+				if !ok {
+					return fmt.Errorf("invalid source: no approval column %s", task.approval.column)
+				}
+				switch rec[task.approval.column] {
+				case approvalApproved:
+				case approvalPending:
+					result.pending++
+					continue
+				default:
+					result.pending++
+					if err := task.approval.request(rctx, task, i, rec, fs); err != nil {
+						task.log.Warn("failed to request approval", "row", i, "err", err)
+						continue
+					}
+					if err = setCell(column, i, approvalPending); err != nil {
+						return fmt.Errorf("failed to set approval for row %d: %v", i, err)
+					}
+					task.updated = true
+					continue
+				}
 			}
 
-			success := true
+			if len(insertTargets) != 0 {
+				due, err := task.schedule.due(rec, now)
+				if err != nil {
+					task.log.Warn("failed to schedule row", "row", i, "err", err)
+					result.failed++
+					continue
+				}
+				if due {
+					if insertTargets, err = task.dueTargets(insertTargets, rec, now); err != nil {
+						task.log.Warn("failed to schedule row", "row", i, "err", err)
+						result.failed++
+						continue
+					}
+					due = len(insertTargets) != 0
+				}
+				if !due {
+					// publish on a later run, updates are not held back
+					result.scheduled++
+					insertTargets = nil
+					if len(updateTargets) == 0 {
+						continue
+					}
+				}
+			}
+
+			// rows published by an interrupted run are restored before
+			// numbering, so they keep the episode number of the post
+			key := publishRowKey(i, row, targetColumns)
+			var publish []target
+			for _, t := range insertTargets {
+				jid, ok, err := task.journal.published(t.ID(), key)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					publish = append(publish, t)
+					continue
+				}
+				task.log.Info("restored status of row published by an interrupted run", "target", t.ID(), "row", i)
+				if err := setStatus(t, i, "ok"); err != nil {
+					return err
+				}
+				if err := setRecordId(t, i, jid); err != nil {
+					return err
+				}
+				if p, ok := t.(permalinker); ok && rec[catalogURLField] == "" {
+					rec[catalogURLField] = p.Permalink(jid)
+				}
+			}
+			// restored rows only get the number taken by the interrupted run,
+			// the one reserved otherwise is released below
+			if len(insertTargets) != 0 && task.episodes != nil {
+				task.episodes.assign(rec, key)
+			}
+			insertTargets = publish
 
 			for _, t := range insertTargets {
+				if !task.circuit.allow(t.ID()) {
+					skipped = true
+					if err := setStatus(t, i, skippedStatus); err != nil {
+						return err
+					}
+					continue
+				}
 				status := "ok"
-				id, err := t.Insert(rec, fs)
+				start := time.Now()
+				id, err := t.Insert(rctx, rec, fs)
+				result.target(t, i, rowOpInsert, id, err, start)
+				task.breaker(&result, t, err)
+				if vt, ok := t.(variantTarget); ok && err == nil && vt.Variant() != "" {
+					result.rows[len(result.rows)-1].Variant = vt.Variant()
+					task.audit.write(&auditRecord{
+						Time:   task.now(),
+						Action: "publish",
+						RunID:  task.runID,
+						Args:   []string{task.name, strconv.Itoa(i), t.ID(), id, "variant=" + vt.Variant()},
+					})
+				}
 				if err != nil {
 					success = false
-					status = err.Error()
-					log.Printf("failed to proccess target %s for row %d: %v", t.ID(), i, err)
+					status = failedStatus(err, task.runID)
+					task.log.Warn("failed to process target", "target", t.ID(), "row", i, "err", err)
+					if isLimitError(err) {
+						limit = err
+					}
 				}
 				if err = setStatus(t, i, status); err != nil {
 					return err
 				}
 				if status == "ok" {
+					if task.episodes != nil {
+						if err = task.episodes.commit(key); err != nil {
+							return fmt.Errorf("failed to save episode number for row %d: %v", i, err)
+						}
+					}
+					if err = task.journal.add(t.ID(), key, id); err != nil {
+						task.log.Warn("failed to record insert", "target", t.ID(), "row", i, "err", err)
+					}
 					if err = setRecordId(t, i, id); err != nil {
 						return err
 					}
+					if p, ok := t.(permalinker); ok && rec[catalogURLField] == "" {
+						rec[catalogURLField] = p.Permalink(id)
+					}
 				}
 			}
 
-			//for _, t := range updateTargets {
-			//
-			//}
+			if task.episodes != nil {
+				task.episodes.release(rec, key)
+			}
 
-			if success {
-				result.done++
-			} else {
+			for _, t := range updateTargets {
+				if !task.circuit.allow(t.ID()) {
+					skipped = true
+					if err := setStatus(t, i, skippedStatus); err != nil {
+						return err
+					}
+					continue
+				}
+				status := "ok"
+				start := time.Now()
+				err := t.Update(rctx, recordIds[t.ID()], rec, fs)
+				result.target(t, i, rowOpUpdate, recordIds[t.ID()], err, start)
+				task.breaker(&result, t, err)
+				if err != nil {
+					success = false
+					status = failedStatus(err, task.runID)
+					task.log.Warn("failed to update target", "target", t.ID(), "row", i, "err", err)
+					if isLimitError(err) {
+						limit = err
+					}
+				}
+				if err = setStatus(t, i, status); err != nil {
+					return err
+				}
+			}
+
+			// write back fields filled in by the steps owning them
+			for field := range task.writeBack {
+				idx, ok := columns[field]
+				if !ok || joined[field] {
+					continue
+				}
+				var value string
+				if idx < len(row) {
+					value = row[idx]
+				}
+				if rec[field] == value || rec[field] == "" {
+					continue
+				}
+				if err = setCell(idx, i, rec[field]); err != nil {
+					return fmt.Errorf("failed to set field %s for row %d: %v", field, i, err)
+				}
+			}
+
+			switch {
+			case !success:
 				result.failed++
+			case skipped:
+				result.skipped++
+			default:
+				result.done++
 			}
 			task.updated = true
 		}
 
-		if err = rows.Close(); err != nil {
-			log.Printf("failed to close rows: %v", err)
-		}
-
 		if task.updated {
-			if err := f.SaveAs(task.result); err != nil {
-				return fmt.Errorf("failed to save file: %v", err)
+			if err := task.src.save(); err != nil {
+				return err
 			}
 		}
-		return err
+		// rows after the one exceeding a limit are left for the next run
+		return limit
 	}()
+	task.finish(rctx, &result)
 	return result
 }
 
-func (task *task) update(fs *drive.FilesService) error {
+// finish runs the per-run work of the targets, also after a failed run so
+// rows published before the failure get into indexes and feeds. Failures of
+// targets are added to the task error.
+func (task *task) finish(ctx context.Context, result *taskResult) {
+	errs := []error{result.err}
+	for _, t := range task.targets {
+		if err := t.Finish(ctx); err != nil {
+			task.log.Error("failed to finish target", "target", t.ID(), "err", err)
+			errs = append(errs, fmt.Errorf("failed to finish target %s: %w", t.ID(), err))
+		}
+		if w, ok := t.(warner); ok {
+			for _, warning := range w.Warnings() {
+				task.log.Warn(warning, "target", t.ID())
+				result.warnings = append(result.warnings, warning)
+			}
+		}
+	}
+	if len(errs) > 1 {
+		result.err = errors.Join(errs...)
+	}
+}
+
+func (task *task) update(ctx context.Context) error {
 	if !task.updated {
 		return nil
 	}
-
-	f, err := os.OpenFile(task.result, os.O_RDONLY, filePerm)
-	if err != nil {
+	if err := task.src.upload(ctx); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	_, err = fs.Update(task.id, &drive.File{
-		Name:     task.origin,
-		MimeType: originMIME,
-	}).Media(f).Do()
-
-	if err != nil {
-		return fmt.Errorf("upload failed: %v", err)
+	if err := task.journal.clear(); err != nil {
+		task.log.Warn("failed to clear publish journal", "err", err)
 	}
 	return nil
 }
+
+func (task *task) close() error {
+	_ = task.journal.close()
+	return task.src.close()
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWriteBackFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		enricher *enricher
+		episodes *episodeCounter
+		targets  map[string]target
+		computed []*computedField
+		want     map[string]bool
+	}{
+		{"nothing to write back", nil, nil, map[string]target{"rss": &rssTarget{}, "tg": &telegramTarget{}}, nil, map[string]bool{}},
+		{"enrichment fields", &enricher{fields: []string{"teaser", "seo"}}, nil, nil, nil, map[string]bool{"teaser": true, "seo": true}},
+		{"episode column", nil, &episodeCounter{column: "ep"}, nil, nil, map[string]bool{"ep": true}},
+		{
+			"computed fields are not written back",
+			&enricher{fields: []string{"teaser", "slug"}}, &episodeCounter{column: "ep"}, nil,
+			[]*computedField{{name: "slug"}, {name: "ep"}},
+			map[string]bool{"teaser": true},
+		},
+		{
+			"target fields",
+			nil, nil,
+			map[string]target{
+				"tg":      &telegramTarget{variants: &templateVariants{column: "variant"}},
+				"catalog": &htmlCatalogTarget{transcriber: &transcriber{}, transcriptColumn: "transcript_url"},
+				"plain":   &htmlCatalogTarget{transcriptColumn: "transcript_url"},
+			},
+			nil,
+			map[string]bool{"variant": true, "transcript_url": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := writeBackFields(tt.enricher, tt.episodes, tt.targets, tt.computed)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeBackFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,106 +16,606 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-func telegramSendMessage(token string, chat string, text string) (string, error) {
+// telegramCall calls the Bot API method with JSON encoded parameters.
+func telegramCall(ctx context.Context, token string, method string, params map[string]any) (string, error) {
 	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(map[string]any{
-		"chat_id":    chat,
-		"text":       text,
-		"parse_mode": "HTML",
-	}); err != nil {
+	if err := json.NewEncoder(&buf).Encode(params); err != nil {
 		return "", err
 	}
-	resp, err := http.Post(
-		fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", token),
-		"application/json",
-		&buf,
-	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramMethodURL(token, method), &buf)
 	if err != nil {
 		return "", err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", classifyHTTPError(err)
+	}
 	return telegramParseResponse(resp)
 }
 
-func telegramSendAudioStream(token string, chat string, audio string, audioReader io.Reader, audioWriter io.Writer, text string) (string, error) {
-	var buf bytes.Buffer
-	w := multipart.NewWriter(&buf)
-	for key, val := range map[string]string{
-		"chat_id":    chat,
-		"caption":    text,
-		"parse_mode": "HTML",
-	} {
-		part, err := w.CreateFormField(key)
-		if err != nil {
-			return "", err
+// Telegram parse modes, parse mode none sends the text as is.
+const (
+	telegramParseHTML       = "HTML"
+	telegramParseMarkdownV2 = "MarkdownV2"
+	telegramParseNone       = "none"
+)
+
+func validTelegramParseMode(mode string) error {
+	switch mode {
+	case "", telegramParseHTML, telegramParseMarkdownV2, telegramParseNone:
+		return nil
+	}
+	return fmt.Errorf("invalid config: invalid parse mode %s", mode)
+}
+
+// telegramOptions are the optional send parameters of a target, nil options
+// send HTML text.
+type telegramOptions struct {
+	parseMode string
+	// threadID is the forum topic of sent messages.
+	threadID int
+	// thumbnail is the local thumbnail file of sent audio, video and
+	// documents.
+	thumbnail string
+	keyboard  [][]telegramButton
+	silent    bool
+	protect   bool
+}
+
+// fields returns the options as method parameters.
+func (o *telegramOptions) fields() map[string]string {
+	fields := map[string]string{}
+	switch {
+	case o == nil || o.parseMode == "":
+		fields["parse_mode"] = telegramParseHTML
+	case o.parseMode != telegramParseNone:
+		fields["parse_mode"] = o.parseMode
+	}
+	if o != nil && o.threadID != 0 {
+		fields["message_thread_id"] = strconv.Itoa(o.threadID)
+	}
+	if o != nil && len(o.keyboard) != 0 {
+		b, _ := json.Marshal(map[string]any{"inline_keyboard": o.keyboard})
+		fields["reply_markup"] = string(b)
+	}
+	if o != nil && o.silent {
+		fields["disable_notification"] = "true"
+	}
+	if o != nil && o.protect {
+		fields["protect_content"] = "true"
+	}
+	return fields
+}
+
+// apply adds the options to the JSON method parameters.
+func (o *telegramOptions) apply(params map[string]any) map[string]any {
+	for key, val := range o.fields() {
+		params[key] = val
+	}
+	if o != nil && o.threadID != 0 {
+		params["message_thread_id"] = o.threadID
+	}
+	if o != nil && len(o.keyboard) != 0 {
+		params["reply_markup"] = map[string]any{"inline_keyboard": o.keyboard}
+	}
+	for _, key := range []string{"disable_notification", "protect_content"} {
+		if _, ok := params[key]; ok {
+			params[key] = true
+		}
+	}
+	return params
+}
+
+// telegramMarkdownV2Special are the characters escaped in MarkdownV2 text.
+const telegramMarkdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+
+// telegramEscapeMarkdownV2 escapes the text for MarkdownV2 messages.
+func telegramEscapeMarkdownV2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(telegramMarkdownV2Special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// telegramEscape escapes the plain text for the parse mode.
+func telegramEscape(mode, s string) string {
+	switch mode {
+	case telegramParseMarkdownV2:
+		return telegramEscapeMarkdownV2(s)
+	case telegramParseNone:
+		return s
+	}
+	return html.EscapeString(s)
+}
+
+// Telegram text limits, in UTF-16 code units of the text without markup.
+const (
+	telegramMaxMessage = 4096
+	telegramMaxCaption = 1024
+)
+
+var (
+	telegramTagRegexp       = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	telegramTagPrefixRegexp = regexp.MustCompile(`^</?[a-zA-Z][^>]*>`)
+)
+
+// telegramTextLength returns the length of the text counted by Telegram
+// against its limits. MarkdownV2 markup is counted, so the length is an
+// upper bound.
+func telegramTextLength(mode, s string) int {
+	if mode == "" || mode == telegramParseHTML {
+		s = html.UnescapeString(telegramTagRegexp.ReplaceAllString(s, ""))
+	}
+	n := 0
+	for _, r := range s {
+		n += telegramRuneLength(r)
+	}
+	return n
+}
+
+// telegramRuneLength returns the number of UTF-16 code units of the rune.
+func telegramRuneLength(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// telegramSplit splits the text into the first part fitting the first limit
+// and the parts fitting the limit. Parts are cut at paragraph, line or word
+// boundaries outside of HTML tags.
+func telegramSplit(mode, text string, first, limit int) ([]string, error) {
+	var parts []string
+	for n := first; telegramTextLength(mode, text) > n; n = limit {
+		cut := telegramCut(mode, text, n)
+		if cut <= 0 {
+			return nil, fmt.Errorf("%w: text can't be split into parts of %d chars", errValidation, n)
 		}
-		if _, err = io.Copy(part, strings.NewReader(val)); err != nil {
-			return "", err
+		parts = append(parts, strings.TrimRight(text[:cut], " \n"))
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	return append(parts, text), nil
+}
+
+// telegramCut returns the length of the longest text prefix fitting the
+// limit and ending at a boundary, or 0 if there is none.
+func telegramCut(mode, text string, limit int) int {
+	isHTML := mode == "" || mode == telegramParseHTML
+	n, depth, i := 0, 0, 0
+	var para, line, word int
+	for i < len(text) {
+		if isHTML && text[i] == '<' {
+			if loc := telegramTagPrefixRegexp.FindStringIndex(text[i:]); loc != nil {
+				if text[i+1] == '/' {
+					depth--
+				} else {
+					depth++
+				}
+				i += loc[1]
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		units := telegramRuneLength(r)
+		if isHTML && r == '&' {
+			if end := strings.IndexByte(text[i:], ';'); end > 0 && end <= 10 {
+				size, units = end+1, 1
+			}
+		}
+		if n+units > limit {
+			break
+		}
+		n += units
+		i += size
+		if depth != 0 {
+			continue
+		}
+		switch {
+		case r == '\n' && i > 1 && text[i-2] == '\n':
+			para = i
+		case r == '\n':
+			line = i
+		case r == ' ':
+			word = i
+		}
+	}
+	switch {
+	case para > 0:
+		return para
+	case line > 0:
+		return line
+	case word > 0:
+		return word
+	case depth == 0:
+		return i
+	}
+	return 0
+}
+
+// telegramTemplateFuncs are the escaping helpers of telegram templates.
+var telegramTemplateFuncs = map[string]any{
+	"escapeHTML":       html.EscapeString,
+	"escapeMarkdownV2": telegramEscapeMarkdownV2,
+}
+
+func telegramSendMessage(ctx context.Context, token string, chat string, text string, opts *telegramOptions) (string, error) {
+	return telegramCall(ctx, token, "sendMessage", opts.apply(map[string]any{
+		"chat_id": chat,
+		"text":    text,
+	}))
+}
+
+func telegramEditMessageText(ctx context.Context, token string, chat string, id string, text string, opts *telegramOptions) error {
+	_, err := telegramCall(ctx, token, "editMessageText", opts.apply(map[string]any{
+		"chat_id":    chat,
+		"message_id": id,
+		"text":       text,
+	}))
+	return err
+}
+
+func telegramEditMessageCaption(ctx context.Context, token string, chat string, id string, caption string, opts *telegramOptions) error {
+	_, err := telegramCall(ctx, token, "editMessageCaption", opts.apply(map[string]any{
+		"chat_id":    chat,
+		"message_id": id,
+		"caption":    caption,
+	}))
+	return err
+}
+
+func telegramDeleteMessage(ctx context.Context, token string, chat string, id string) error {
+	_, err := telegramCall(ctx, token, "deleteMessage", map[string]any{
+		"chat_id":    chat,
+		"message_id": id,
+	})
+	return err
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+func telegramSendMessageKeyboard(ctx context.Context, token string, chat string, text string, keyboard [][]telegramButton) (string, error) {
+	return telegramCall(ctx, token, "sendMessage", map[string]any{
+		"chat_id":      chat,
+		"text":         text,
+		"parse_mode":   "HTML",
+		"reply_markup": map[string]any{"inline_keyboard": keyboard},
+	})
+}
+
+func telegramAnswerCallbackQuery(ctx context.Context, token string, id string, text string, alert bool) error {
+	_, err := telegramCall(ctx, token, "answerCallbackQuery", map[string]any{
+		"callback_query_id": id,
+		"text":              text,
+		"show_alert":        alert,
+	})
+	return err
+}
+
+// telegramEditMessageReplyMarkup replaces the message inline keyboard, nil
+// keyboard removes it.
+func telegramEditMessageReplyMarkup(ctx context.Context, token string, chat string, id string, keyboard [][]telegramButton) error {
+	if keyboard == nil {
+		keyboard = [][]telegramButton{}
+	}
+	_, err := telegramCall(ctx, token, "editMessageReplyMarkup", map[string]any{
+		"chat_id":      chat,
+		"message_id":   id,
+		"reply_markup": map[string]any{"inline_keyboard": keyboard},
+	})
+	return err
+}
+
+// telegramMaxReportMessages is the max number of messages of a report, the
+// summary is sent with the full report attached as a file instead of
+// longer reports.
+const telegramMaxReportMessages = 3
+
+// telegramSendReport sends the run report split into messages, or the
+// summary and the report file if it is too long.
+func telegramSendReport(ctx context.Context, token, chat, report, summary string) error {
+	parts, err := telegramSplit(telegramParseHTML, report, telegramMaxMessage, telegramMaxMessage)
+	if err == nil && len(parts) <= telegramMaxReportMessages {
+		for _, part := range parts {
+			if _, err = telegramSendMessage(ctx, token, chat, part, nil); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
-	part, err := w.CreateFormFile("audio", audio)
+	// the summary is sent first, so it is delivered if the upload fails
+	if _, err = telegramSendMessage(ctx, token, chat, summary, nil); err != nil {
+		return err
+	}
+	text := html.UnescapeString(telegramTagRegexp.ReplaceAllString(report, ""))
+	_, err = telegramSendMediaStream(ctx, token, "sendDocument", "document", chat, "report.txt", strings.NewReader(text), nil, "", nil)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to send report file: %w", err)
 	}
-	if audioWriter != nil {
-		_, err = io.Copy(io.MultiWriter(part, audioWriter), audioReader)
-	} else {
-		_, err = io.Copy(part, audioReader)
+	return nil
+}
+
+// telegramCallbackDataLimit is the max button callback data size.
+const telegramCallbackDataLimit = 64
+
+// telegramCallbackData builds button callback data from the action name and
+// its arguments.
+func telegramCallbackData(action string, args ...string) (string, error) {
+	data := strings.Join(append([]string{action}, args...), ":")
+	if len(data) > telegramCallbackDataLimit {
+		return "", fmt.Errorf("callback data too long: %s", data)
 	}
+	return data, nil
+}
+
+// telegramSendMediaKindStream sends audio, video or document media read
+// from the reader with the method of its kind.
+func telegramSendMediaKindStream(ctx context.Context, token, kind, chat, name string, mediaReader io.Reader, mediaWriter io.Writer, text string, opts *telegramOptions) (string, error) {
+	method := "send" + strings.ToUpper(kind[:1]) + kind[1:]
+	return telegramSendMediaStream(ctx, token, method, kind, chat, name, mediaReader, mediaWriter, text, opts)
+}
+
+func telegramSendPhoto(ctx context.Context, token string, chat string, file string, text string, opts *telegramOptions) (string, error) {
+	f, err := os.Open(file)
 	if err != nil {
 		return "", err
 	}
-	if err = w.Close(); err != nil {
+	defer f.Close()
+	return telegramSendMediaStream(ctx, token, "sendPhoto", "photo", chat, filepath.Base(file), f, nil, text, opts)
+}
+
+// telegramSendFile sends the file with the method of its media type.
+func telegramSendFile(ctx context.Context, token string, chat string, file string, text string, opts *telegramOptions) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
 		return "", err
 	}
-	resp, err := http.Post(
-		fmt.Sprintf("https://api.telegram.org/bot%s/sendAudio", token),
-		w.FormDataContentType(),
-		&buf,
-	)
+	defer f.Close()
+	typ := telegramMediaType(file)
+	method := "send" + strings.ToUpper(typ[:1]) + typ[1:]
+	return telegramSendMediaStream(ctx, token, method, typ, chat, filepath.Base(file), f, nil, text, opts)
+}
+
+// telegramSendMediaStream uploads the media read from the reader with the
+// method, field is the method media parameter name. The media is also
+// copied to the writer if not nil. The upload is streamed, it is retried
+// only if the reader can seek back and no writer is set.
+func telegramSendMediaStream(ctx context.Context, token, method, field, chat, name string, mediaReader io.Reader, mediaWriter io.Writer, text string, opts *telegramOptions) (string, error) {
+	fields := opts.fields()
+	fields["chat_id"] = chat
+	fields["caption"] = text
+	if method == "sendVideo" {
+		fields["supports_streaming"] = "true"
+	}
+	seeker, replay := mediaReader.(io.Seeker)
+	replay = replay && mediaWriter == nil
+	body := newMultipartBody(func(w *multipart.Writer) error {
+		for key, val := range fields {
+			if err := w.WriteField(key, val); err != nil {
+				return err
+			}
+		}
+		part, err := w.CreateFormFile(field, name)
+		if err != nil {
+			return err
+		}
+		if replay {
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		if mediaWriter != nil {
+			_, err = io.Copy(io.MultiWriter(part, mediaWriter), mediaReader)
+		} else {
+			_, err = io.Copy(part, mediaReader)
+		}
+		if err != nil {
+			return err
+		}
+		if opts != nil && opts.thumbnail != "" {
+			return writeFormFile(w, "thumbnail", opts.thumbnail)
+		}
+		return nil
+	})
+	resp, err := body.post(ctx, telegramMethodURL(token, method), replay)
 	if err != nil {
 		return "", err
 	}
 	return telegramParseResponse(resp)
 }
 
+// errTelegramNotModified is returned by edits not changing the message.
+var errTelegramNotModified = errors.New("message is not modified")
+
+// errTelegramDeleteNotFound is returned by deletes of messages deleted
+// already, unlike other not found errors it means the delete is done.
+var errTelegramDeleteNotFound = fmt.Errorf("message to delete not found: %w", errNotFound)
+
+// telegramDescriptionClasses are the classes of Bot API errors told apart
+// from others of their code by the description only. Details may be
+// appended to the descriptions.
+var telegramDescriptionClasses = []struct {
+	description string
+	class       error
+}{
+	{"Bad Request: message is not modified", errTelegramNotModified},
+	{"Bad Request: message to edit not found", errNotFound},
+	{"Bad Request: message to delete not found", errTelegramDeleteNotFound},
+	{"Bad Request: chat not found", errNotFound},
+}
+
+// telegramAPIError is an error response of the Bot API, it unwraps to its
+// class.
+type telegramAPIError struct {
+	code        int
+	description string
+	class       error
+}
+
+func (e *telegramAPIError) Error() string {
+	return fmt.Sprintf("telegram request error %d: %s", e.code, e.description)
+}
+
+func (e *telegramAPIError) Unwrap() error {
+	return e.class
+}
+
+// telegramError returns the Bot API error with its class.
+func telegramError(code int, desc string) error {
+	err := &telegramAPIError{code: code, description: desc, class: httpStatusClass(code)}
+	for _, dc := range telegramDescriptionClasses {
+		if code == http.StatusBadRequest && strings.HasPrefix(desc, dc.description) {
+			err.class = dc.class
+			break
+		}
+	}
+	return err
+}
+
 func telegramParseResponse(resp *http.Response) (string, error) {
 	defer resp.Body.Close()
 	result := make(map[string]any)
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
 	}
-	//e := json.NewEncoder(os.Stdout)
-	//e.SetIndent("", "  ")
-	//if err := e.Encode(result); err != nil {
-	//	return "", err
-	//}
+	slog.Debug("telegram response", "result", result)
 	if ok, _ := result["ok"].(bool); !ok {
 		code, _ := result["error_code"].(float64)
 		desc, _ := result["description"].(string)
 		if desc == "" {
 			desc = "unknown error"
 		}
-		return "", fmt.Errorf("telegram request error %d: %s", int(code), desc)
+		return "", telegramError(int(code), desc)
 	}
 	if result, ok := result["result"].(map[string]any); ok {
 		if id, ok := result["message_id"].(float64); ok {
 			return strconv.Itoa(int(id)), nil
 		}
 	}
+	// media groups are sent as several messages
+	if result, ok := result["result"].([]any); ok {
+		var ids []string
+		for _, m := range result {
+			if m, ok := m.(map[string]any); ok {
+				if id, ok := m["message_id"].(float64); ok {
+					ids = append(ids, strconv.Itoa(int(id)))
+				}
+			}
+		}
+		if len(ids) != 0 {
+			return strings.Join(ids, ","), nil
+		}
+	}
 	return "?", nil
 }
 
+// telegramMediaType returns the media group item type of the file. Photos
+// and videos can be mixed in a group, audio and documents can't be mixed
+// with other types.
+func telegramMediaType(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return "photo"
+	case ".mp4", ".mov":
+		return "video"
+	case ".mp3", ".m4a", ".ogg", ".flac", ".wav":
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+const telegramMaxMediaGroup = 10
+
+// telegramSendMediaGroup sends the files as an album with the caption on the
+// first item and returns the comma separated message ids.
+func telegramSendMediaGroup(ctx context.Context, token string, chat string, files []string, text string, opts *telegramOptions) (string, error) {
+	if len(files) > telegramMaxMediaGroup {
+		return "", fmt.Errorf("too many files for a media group: %d (max %d)", len(files), telegramMaxMediaGroup)
+	}
+	types := make([]string, len(files))
+	mixed := false
+	for i, file := range files {
+		types[i] = telegramMediaType(file)
+		grouped := types[i] == "photo" || types[i] == "video"
+		if types[i] != types[0] && !(grouped && (types[0] == "photo" || types[0] == "video")) {
+			mixed = true
+		}
+	}
+	if mixed {
+		// incompatible types are sent as documents
+		for i := range types {
+			types[i] = "document"
+		}
+	}
+	// the parse mode is a media item field, other options are sent with
+	// the group, albums can't have inline keyboards
+	fields := opts.fields()
+	delete(fields, "reply_markup")
+	media := make([]map[string]string, len(files))
+	for i := range files {
+		media[i] = map[string]string{"type": types[i], "media": "attach://file" + strconv.Itoa(i)}
+		if i == 0 {
+			if mode, ok := fields["parse_mode"]; ok {
+				media[i]["parse_mode"] = mode
+				delete(fields, "parse_mode")
+			}
+			media[i]["caption"] = text
+		}
+	}
+	mb, err := json.Marshal(media)
+	if err != nil {
+		return "", err
+	}
+	fields["chat_id"] = chat
+	fields["media"] = string(mb)
+	// files are read again by retries
+	body := newMultipartBody(func(w *multipart.Writer) error {
+		for i, file := range files {
+			if err := writeFormFile(w, "file"+strconv.Itoa(i), file); err != nil {
+				return err
+			}
+		}
+		for key, val := range fields {
+			if err := w.WriteField(key, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	resp, err := body.post(ctx, telegramMethodURL(token, "sendMediaGroup"), true)
+	if err != nil {
+		return "", err
+	}
+	return telegramParseResponse(resp)
+}
+
 type telegramResponse struct {
 	OK          bool            `json:"ok"`
 	Result      json.RawMessage `json:"result'"`
@@ -129,26 +629,46 @@ type telegramUser struct {
 }
 
 type telegramChat struct {
-	Id int `json:"id"`
+	Id   int    `json:"id"`
+	Type string `json:"type"`
 }
 
 type telegramMessage struct {
-	From telegramUser `json:"from"`
-	Chat telegramChat `json:"chat"`
-	Text string       `json:"text"`
-	Date int64        `json:"date"`
+	MessageId int          `json:"message_id"`
+	From      telegramUser `json:"from"`
+	Chat      telegramChat `json:"chat"`
+	Text      string       `json:"text"`
+	Date      int64        `json:"date"`
+}
+
+type telegramCallbackQuery struct {
+	Id      string           `json:"id"`
+	From    telegramUser     `json:"from"`
+	Message *telegramMessage `json:"message"`
+	Data    string           `json:"data"`
 }
 
 type telegramUpdate struct {
-	UpdateId int             `json:"update_id"`
-	Message  telegramMessage `json:"message"`
+	UpdateId      int                    `json:"update_id"`
+	Message       telegramMessage        `json:"message"`
+	CallbackQuery *telegramCallbackQuery `json:"callback_query"`
 }
 
-func telegramGetUpdates(token string, offset int) ([]*telegramUpdate, error) {
-	r, err := http.Get(fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d", token, offset+1))
+// telegramGetUpdates returns updates after the offset, with non-zero timeout
+// the request waits for updates up to timeout (long polling).
+func telegramGetUpdates(ctx context.Context, token string, offset int, timeout time.Duration) ([]*telegramUpdate, error) {
+	u := telegramMethodURL(token, "getUpdates") + "?offset=" + strconv.Itoa(offset+1)
+	if timeout > 0 {
+		u += "&timeout=" + strconv.Itoa(int(timeout/time.Second))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
 	defer r.Body.Close()
 
 	var resp telegramResponse
@@ -157,14 +677,11 @@ func telegramGetUpdates(token string, offset int) ([]*telegramUpdate, error) {
 	}
 
 	if !resp.OK {
-		code, desc := "?", "?"
-		if resp.ErrorCode != 0 {
-			code = strconv.Itoa(resp.ErrorCode)
-		}
+		desc := "?"
 		if resp.Description != "" {
 			desc = resp.Description
 		}
-		return nil, fmt.Errorf("telegram request error %s: %s", code, desc)
+		return nil, telegramError(resp.ErrorCode, desc)
 	}
 
 	var updates []*telegramUpdate
@@ -184,95 +701,375 @@ func telegramGetUpdates(token string, offset int) ([]*telegramUpdate, error) {
 	}
 }
 
-func telegramListenBot(cfg *config, f func() ([]taskResult, error)) error {
-	users := make(map[int]struct{})
+// botActions are the operations available to bot users.
+type botActions struct {
+	sync func() ([]taskResult, error)
+	// start starts a run in the background and returns its id.
+	start  func() (string, error)
+	status *runStatus
+	// now is the time of the run clock.
+	now     func() time.Time
+	preview func(ctx context.Context, task string, row int, chat string) error
+	approve func(task string, row int) error
+	pending func() ([]taskPending, error)
+}
+
+const (
+	botPreviewCommand = "/preview"
+	botPendingCommand = "/pending"
+)
+
+// isBotCommand reports whether the text is a command handled by
+// telegramHandleCommand.
+func isBotCommand(text string) bool {
+	cmd, _, _ := strings.Cut(text, " ")
+	return cmd == botPreviewCommand || cmd == botPendingCommand
+}
+
+const defaultBotPollTimeout = 30 * time.Second
+
+// telegramHandleCommand handles a bot command message and replies to its chat.
+func telegramHandleCommand(ctx context.Context, cfg *config, actions *botActions, msg *telegramMessage) {
+	reply := func(text string) {
+		if _, err := telegramSendMessage(ctx, cfg.TelegramBotToken, strconv.Itoa(msg.Chat.Id), redactSecrets(text), nil); err != nil {
+			slog.Warn("failed to reply", "chat", msg.Chat.Id, "err", err)
+		}
+	}
+	args := strings.Fields(msg.Text)
+	switch args[0] {
+	case botPreviewCommand:
+		if len(args) != 3 {
+			reply("usage: /preview <task> <row>")
+			return
+		}
+		row, err := strconv.Atoi(args[2])
+		if err != nil {
+			reply(fmt.Sprintf("invalid row number: %s", args[2]))
+			return
+		}
+		slog.Info("previewing row", "task", args[1], "row", row, "user", msg.From.Id)
+		// previews are sent to the private chat with the requesting user
+		if err = actions.preview(ctx, args[1], row, strconv.Itoa(msg.From.Id)); err != nil {
+			reply(fmt.Sprintf("preview failed: %v", err))
+		}
+	case botPendingCommand:
+		result, err := actions.pending()
+		if err != nil {
+			reply(fmt.Sprintf("pending failed: %v", err))
+			return
+		}
+		var sb strings.Builder
+		for _, p := range result {
+			if p.err != nil {
+				fmt.Fprintf(&sb, "%s: error: %v\n", p.name, p.err)
+			} else {
+				fmt.Fprintf(&sb, "%s: %d pending\n", p.name, len(p.rows))
+			}
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("no tasks")
+		}
+		reply(sb.String())
+	}
+}
+
+// botCommandText returns the message text with the command addressed to the
+// bot in group chats normalized ("/run@bot args" to "/run args"). It returns
+// false for commands addressed to other bots and, if mentions are required,
+// for group commands without the bot mention.
+func botCommandText(cfg *config, msg *telegramMessage) (string, bool) {
+	text := msg.Text
+	if !strings.HasPrefix(text, "/") {
+		return text, true
+	}
+	cmd, args, _ := strings.Cut(text, " ")
+	cmd, bot, mentioned := strings.Cut(cmd, "@")
+	if mentioned {
+		if cfg.BotUsername == "" || !strings.EqualFold(bot, strings.TrimPrefix(cfg.BotUsername, "@")) {
+			return "", false
+		}
+	} else if cfg.BotRequireMention && msg.Chat.Type != "private" {
+		return "", false
+	}
+	if args != "" {
+		cmd += " " + args
+	}
+	return cmd, true
+}
+
+// botCallbackHandler handles a button press of its action, args are the
+// callback data arguments. The returned text is shown to the user.
+type botCallbackHandler func(ctx context.Context, cq *telegramCallbackQuery, args []string) (string, error)
+
+// telegramHandleCallback dispatches an inline keyboard button press to the
+// action handler and answers the callback query.
+func telegramHandleCallback(ctx context.Context, cfg *config, handlers map[string]botCallbackHandler, cq *telegramCallbackQuery) {
+	action, rest, _ := strings.Cut(cq.Data, ":")
+	var args []string
+	if rest != "" {
+		args = strings.Split(rest, ":")
+	}
+	text, alert := "unknown action", true
+	if h, ok := handlers[action]; ok {
+		var err error
+		if text, err = h(ctx, cq, args); err != nil {
+			slog.Warn("callback failed", "action", action, "err", err)
+			text = redactSecrets(fmt.Sprintf("%s failed: %v", action, err))
+		} else {
+			alert = false
+		}
+	}
+	if err := telegramAnswerCallbackQuery(ctx, cfg.TelegramBotToken, cq.Id, text, alert); err != nil {
+		slog.Warn("failed to answer callback", "err", err)
+	}
+}
+
+// botAuth authorizes bot updates by the allowed users and chats.
+type botAuth struct {
+	users map[int]struct{}
+	chats map[int]struct{}
+}
+
+func newBotAuth(cfg *config) *botAuth {
+	a := &botAuth{users: make(map[int]struct{}), chats: make(map[int]struct{})}
 	for _, u := range cfg.BotUsers {
-		users[u] = struct{}{}
+		a.users[u] = struct{}{}
+	}
+	for _, c := range cfg.BotChats {
+		a.chats[c] = struct{}{}
 	}
+	return a
+}
+
+// message checks whether the sender is allowed or the message was sent to
+// an allowed (group) chat.
+func (a *botAuth) message(msg *telegramMessage) bool {
+	if _, ok := a.users[msg.From.Id]; ok {
+		return true
+	}
+	_, ok := a.chats[msg.Chat.Id]
+	return ok
+}
 
-	offset := 0
-	startTime := time.Now().Unix()
+// callback checks whether the button was pressed by an allowed user. Button
+// presses approve rows, so members of allowed chats may only see them.
+func (a *botAuth) callback(cq *telegramCallbackQuery) bool {
+	_, ok := a.users[cq.From.Id]
+	return ok
+}
+
+// telegramListenBot handles bot triggers until ctx is cancelled. A running
+// export is always finished before returning. In once mode pending triggers
+// are handled and the function returns.
+func telegramListenBot(ctx context.Context, cfg *config, once bool, actions *botActions) error {
+	auth := newBotAuth(cfg)
+
+	rep, err := newReporter(cfg)
+	if err != nil {
+		return err
+	}
+	rem, err := newReminder(cfg)
+	if err != nil {
+		return err
+	}
+
+	state, restored, err := loadBotState(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load bot state: %v", err)
+	}
+	saveState := func() {
+		if err := state.save(); err != nil {
+			slog.Warn("failed to save bot state", "err", err)
+		}
+	}
+
+	offset := state.Offset
+	startTime := actions.now().Unix()
 
 	interval := 10 * time.Second
 	if cfg.BotRefreshInterval != 0 {
 		interval = time.Duration(cfg.BotRefreshInterval) * time.Second
 	}
+	// with long polling updates are requested again right away, the refresh
+	// interval is only waited after errors
+	pollTimeout := defaultBotPollTimeout
+	if cfg.BotPollTimeout != 0 {
+		pollTimeout = time.Duration(cfg.BotPollTimeout) * time.Second
+	}
+	if once || pollTimeout < 0 {
+		pollTimeout = 0
+	}
 	errnum := 0
 
-	log.Println("listening...")
+	// updates are received by the webhook if configured, polling is used
+	// in once mode and if the webhook can't be set
+	getUpdates := func() ([]*telegramUpdate, error) {
+		return telegramGetUpdates(ctx, cfg.TelegramBotToken, offset, pollTimeout)
+	}
+	if cfg.BotWebhook != nil && !once {
+		if wh, err := startBotWebhook(ctx, cfg); err != nil {
+			slog.Warn("failed to start webhook, falling back to polling", "err", err)
+			// updates can't be polled while a webhook is set
+			if _, err = telegramCall(ctx, cfg.TelegramBotToken, "deleteWebhook", map[string]any{}); err != nil {
+				slog.Warn("failed to delete webhook", "err", err)
+			}
+		} else {
+			if pollTimeout == 0 {
+				pollTimeout = defaultBotPollTimeout
+			}
+			getUpdates = func() ([]*telegramUpdate, error) {
+				return wh.getUpdates(ctx, pollTimeout)
+			}
+		}
+	}
+
+	if !once {
+		sdStartWatchdog(ctx)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("failed to notify systemd", "err", err)
+	}
+
+	callbacks := map[string]botCallbackHandler{
+		approveCallbackAction: approveCallback(cfg, actions.approve),
+	}
+
+	slog.Info("listening")
 
 	for {
+		var cmds []*telegramMessage
+		var cbs []*telegramCallbackQuery
 		reqs, err := func() (map[int]struct{}, error) {
-			updates, err := telegramGetUpdates(cfg.TelegramBotToken, offset)
+			updates, err := getUpdates()
 			if err != nil {
 				return nil, err
 			}
-			log.Printf("received %d updates\n", len(updates))
+			slog.Info("received updates", "count", len(updates))
 			reqs := make(map[int]struct{})
+			// resume sync requests interrupted by restart
+			for chat, cs := range state.Chats {
+				if cs.SyncPending {
+					reqs[chat] = struct{}{}
+				}
+			}
 			for _, u := range updates {
-
-				//enc := json.NewEncoder(os.Stdout)
-				//enc.SetIndent("", "  ")
-				//enc.Encode(u)
-
+				slog.Debug("telegram update", "update", u)
 				if u.UpdateId == 0 {
 					continue
 				}
 				offset = u.UpdateId
-				if u.Message.Date < startTime {
+				if u.CallbackQuery != nil {
+					if auth.callback(u.CallbackQuery) {
+						cbs = append(cbs, u.CallbackQuery)
+					}
+					continue
+				}
+				// without the saved offset old updates can't be told from handled ones
+				if !once && !restored && u.Message.Date < startTime {
+					continue
+				}
+				if !auth.message(&u.Message) {
 					continue
 				}
-				if _, ok := users[u.Message.From.Id]; !ok {
+				text, ok := botCommandText(cfg, &u.Message)
+				if !ok {
+					continue
+				}
+				u.Message.Text = text
+				if isBotCommand(u.Message.Text) {
+					state.chat(u.Message.Chat.Id).LastCommand = u.Message.Text
+					cmds = append(cmds, &u.Message)
 					continue
 				}
 				if u.Message.Text != cfg.BotTriggerMessage {
 					continue
 				}
 				reqs[u.Message.Chat.Id] = struct{}{}
+				state.chat(u.Message.Chat.Id).SyncPending = true
 			}
+			state.Offset = offset
+			saveState()
 			return reqs, nil
 		}()
 
+		wait := interval
+		if pollTimeout > 0 && err == nil {
+			wait = 0
+		}
 		if err != nil {
-			log.Printf("listening error: %v\n", err)
-			if errnum++; errnum > cfg.BotMaxErrors {
+			if ctx.Err() != nil {
+				slog.Info("stopped listening")
+				return nil
+			}
+			slog.Error("listening error", "err", err)
+			if errnum++; once || errnum > cfg.BotMaxErrors {
 				return err
 			}
 		} else {
 			errnum = 0
+			// received updates are handled to the end after the stop, the
+			// transport aborts their requests
+			rctx := context.WithoutCancel(ctx)
+			for _, msg := range cmds {
+				telegramHandleCommand(rctx, cfg, actions, msg)
+			}
+			for _, cq := range cbs {
+				telegramHandleCallback(rctx, cfg, callbacks, cq)
+			}
 			if len(reqs) != 0 {
-				log.Printf("received %d sync requests\n", len(reqs))
+				slog.Info("received sync requests", "count", len(reqs))
 
 				for chat := range reqs {
-					if _, err = telegramSendMessage(cfg.TelegramBotToken, strconv.Itoa(chat), "starting sync..."); err != nil {
-						log.Println(err)
+					if _, err = telegramSendMessage(rctx, cfg.TelegramBotToken, strconv.Itoa(chat), "starting sync...", nil); err != nil {
+						slog.Warn("failed to reply", "chat", chat, "err", err)
 					}
 				}
 
-				log.Println("starting sync...")
-				report := ""
-				if results, err := f(); err != nil {
-					report = fmt.Sprintf("sync failed: %v", err)
-				} else {
-					for _, result := range results {
-						report += result.name + "\n"
-						if result.err != nil {
-							report += fmt.Sprintf("error: %s\n", err)
-						}
-						report += fmt.Sprintf("records: total %d, done %d, failed %d\n", result.total, result.done, result.failed)
-					}
-				}
+				slog.Info("starting sync")
+				results, err := actions.sync()
+				n := rep.notification(results, err)
 
-				log.Println(report)
+				slog.Info("sync finished", "report", n.report)
 
+				// the report is a reply to the sync requests, config
+				// notifiers receive it on their own
 				for chat := range reqs {
-					if _, err = telegramSendMessage(cfg.TelegramBotToken, strconv.Itoa(chat), report); err != nil {
-						log.Println(err)
+					tn := &telegramNotifier{token: cfg.TelegramBotToken, chat: strconv.Itoa(chat)}
+					if err = tn.notify(rctx, n); err != nil {
+						slog.Warn("failed to send report", "chat", chat, "err", err)
 					}
+					cs := state.chat(chat)
+					cs.SyncPending = false
+					cs.LastSync = actions.now()
+				}
+				saveState()
+			}
+			if rem != nil && rem.due(actions.now()) {
+				slog.Info("checking stale pending rows")
+				if pending, err := actions.pending(); err != nil {
+					slog.Warn("failed to get pending rows", "err", err)
+				} else if err = rem.check(rctx, pending, actions.now()); err != nil {
+					slog.Warn("failed to send reminder", "err", err)
+				}
+			}
+		}
+
+		if once {
+			// confirm handled updates so they are not received again
+			if offset != 0 {
+				if _, err = telegramGetUpdates(ctx, cfg.TelegramBotToken, offset, 0); err != nil {
+					return err
 				}
 			}
+			return nil
 		}
 
-		time.Sleep(interval)
+		select {
+		case <-ctx.Done():
+			_ = sdNotify("STOPPING=1")
+			slog.Info("stopped listening")
+			return nil
+		case <-time.After(wait):
+		}
 	}
 }
@@ -0,0 +1,249 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// telegramTestServer serves Bot API uploads failing the first fail ones
+// with HTTP 429 and records the received form files.
+type telegramTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	fail     int
+	attempts int
+	files    map[string]string
+	fields   map[string]string
+}
+
+func newTelegramTestServer(t *testing.T, fail int) *telegramTestServer {
+	s := &telegramTestServer{fail: fail}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.attempts++
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: invalid form"}`))
+			return
+		}
+		if s.attempts <= s.fail {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests"}`))
+			return
+		}
+		s.files, s.fields = map[string]string{}, map[string]string{}
+		for key, fhs := range r.MultipartForm.File {
+			f, err := fhs[0].Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(f)
+			f.Close()
+			s.files[key] = fhs[0].Filename + ":" + string(b)
+		}
+		for key, vals := range r.MultipartForm.Value {
+			s.fields[key] = vals[0]
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":7}}`))
+	}))
+	telegramAPIURL = s.URL
+	httpClient = &http.Client{Transport: newRetryTransport(http.DefaultTransport, &retryConfig{BackoffMs: 1})}
+	t.Cleanup(func() {
+		s.Close()
+		telegramAPIURL = defaultTelegramAPIURL
+		httpClient = http.DefaultClient
+	})
+	return s
+}
+
+func TestTelegramSendMediaStream(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.mp3")
+	if err := os.WriteFile(file, []byte("audio"), filePerm); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		fail     int
+		reader   func() io.Reader
+		writer   bool
+		attempts int
+		ok       bool
+	}{
+		{"file", 0, func() io.Reader { f, _ := os.Open(file); t.Cleanup(func() { f.Close() }); return f }, false, 1, true},
+		{"file is sent again by retries", 2, func() io.Reader { f, _ := os.Open(file); t.Cleanup(func() { f.Close() }); return f }, false, 3, true},
+		{"seekable reader is sent again by retries", 1, func() io.Reader { return strings.NewReader("audio") }, false, 2, true},
+		{"stream is not retried", 1, func() io.Reader { return io.MultiReader(strings.NewReader("audio")) }, false, 1, false},
+		{"copied stream is not retried", 1, func() io.Reader { return strings.NewReader("audio") }, true, 1, false},
+		{"copied stream", 0, func() io.Reader { return io.MultiReader(strings.NewReader("audio")) }, true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTelegramTestServer(t, tt.fail)
+			var copied *bytes.Buffer
+			var w io.Writer
+			if tt.writer {
+				copied = &bytes.Buffer{}
+				w = copied
+			}
+			id, err := telegramSendMediaStream(context.Background(), "token", "sendAudio", "audio", "chat", "a.mp3", tt.reader(), w, "caption", nil)
+			if s.attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", s.attempts, tt.attempts)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Error("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "7" {
+				t.Errorf("id = %s", id)
+			}
+			if got := s.files["audio"]; got != "a.mp3:audio" {
+				t.Errorf("audio = %q", got)
+			}
+			if s.fields["chat_id"] != "chat" || s.fields["caption"] != "caption" || s.fields["parse_mode"] != telegramParseHTML {
+				t.Errorf("fields = %v", s.fields)
+			}
+			if copied != nil && copied.String() != "audio" {
+				t.Errorf("copied = %q", copied)
+			}
+		})
+	}
+}
+
+func TestTelegramSendMediaGroup(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "b.mp4")}
+	for _, file := range files {
+		if err := os.WriteFile(file, []byte(filepath.Base(file)), filePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newTelegramTestServer(t, 1)
+	if _, err := telegramSendMediaGroup(context.Background(), "token", "chat", files, "caption", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.attempts != 2 {
+		t.Errorf("attempts = %d, want 2", s.attempts)
+	}
+	if s.files["file0"] != "a.jpg:a.jpg" || s.files["file1"] != "b.mp4:b.mp4" {
+		t.Errorf("files = %v", s.files)
+	}
+	if !strings.Contains(s.fields["media"], `"attach://file1"`) {
+		t.Errorf("media = %s", s.fields["media"])
+	}
+
+	_, err := telegramSendMediaGroup(context.Background(), "token", "chat", []string{filepath.Join(dir, "missing.jpg")}, "", nil)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file err = %v", err)
+	}
+}
+
+func TestTelegramDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		responses map[string]string
+		wantErr   bool
+		remaining string
+		deleted   []string
+	}{
+		{"all deleted", "1,2", nil, false, "", []string{"1", "2"}},
+		{"deleted already", "1,2", map[string]string{"1": "Bad Request: message to delete not found"}, false, "", []string{"1", "2"}},
+		{"first failed", "1,2", map[string]string{"1": "Bad Request: chat not found"}, true, "", []string{"1"}},
+		{"failed midway", "1,2,3", map[string]string{"2": "Bad Request: message can't be deleted"}, true, "2,3", []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted []string
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var params struct {
+					MessageID string `json:"message_id"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+					t.Error(err)
+				}
+				deleted = append(deleted, params.MessageID)
+				if desc, ok := tt.responses[params.MessageID]; ok {
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 400, "description": desc})
+					return
+				}
+				_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+			}))
+			defer s.Close()
+			telegramAPIURL = s.URL
+			defer func() { telegramAPIURL = defaultTelegramAPIURL }()
+
+			err := (&telegramTarget{token: "token", channel: "chat"}).Delete(context.Background(), tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			var pde *partialDeleteError
+			if errors.As(err, &pde) != (tt.remaining != "") || (pde != nil && pde.remaining != tt.remaining) {
+				t.Errorf("err = %#v, want remaining %q", err, tt.remaining)
+			}
+			if strings.Join(deleted, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted = %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestBotAuth(t *testing.T) {
+	auth := newBotAuth(&config{BotUsers: []int{1}, BotChats: []int{-100}})
+	tests := []struct {
+		name     string
+		user     int
+		chat     int
+		message  bool
+		callback bool
+	}{
+		{"allowed user in private chat", 1, 1, true, true},
+		{"allowed user in other chat", 1, -200, true, true},
+		{"member of allowed chat", 2, -100, true, false},
+		{"stranger", 2, 2, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &telegramMessage{From: telegramUser{Id: tt.user}, Chat: telegramChat{Id: tt.chat}}
+			if got := auth.message(msg); got != tt.message {
+				t.Errorf("message authorized = %v, want %v", got, tt.message)
+			}
+			cq := &telegramCallbackQuery{From: telegramUser{Id: tt.user}, Message: msg}
+			if got := auth.callback(cq); got != tt.callback {
+				t.Errorf("callback authorized = %v, want %v", got, tt.callback)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// transcriber converts audio files to text either with a local command
+// (e.g. whisper.cpp) or an OpenAI-compatible transcription API.
+type transcriber struct {
+	endpoint string
+	token    string
+	model    string
+	command  []string
+}
+
+const transcribeFilePlaceholder = "{file}"
+
+func newTranscriber(cfg *transcribeConfig) (*transcriber, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Endpoint == "" && len(cfg.Command) == 0 {
+		return nil, errors.New("invalid config: transcription endpoint or command not set")
+	}
+	return &transcriber{
+		endpoint: cfg.Endpoint,
+		token:    cfg.Token,
+		model:    cfg.Model,
+		command:  cfg.Command,
+	}, nil
+}
+
+func (tr *transcriber) transcribe(ctx context.Context, file string) (string, error) {
+	var text string
+	var err error
+	if len(tr.command) != 0 {
+		text, err = tr.transcribeCommand(ctx, file)
+	} else {
+		text, err = tr.transcribeAPI(ctx, file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe %s: %v", filepath.Base(file), err)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+func (tr *transcriber) transcribeCommand(ctx context.Context, file string) (string, error) {
+	args := make([]string, len(tr.command))
+	for i, arg := range tr.command {
+		args[i] = strings.ReplaceAll(arg, transcribeFilePlaceholder, file)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (tr *transcriber) transcribeAPI(ctx context.Context, file string) (string, error) {
+	// the audio is streamed, not buffered, so long recordings don't
+	// exhaust memory
+	body := newMultipartBody(func(w *multipart.Writer) error {
+		if tr.model != "" {
+			if err := w.WriteField("model", tr.model); err != nil {
+				return err
+			}
+		}
+		return writeFormFile(w, "file", file)
+	})
+	if tr.token != "" {
+		body.header = http.Header{"Authorization": {"Bearer " + tr.token}}
+	}
+	// transcribing again is harmless
+	resp, err := body.post(ctx, tr.endpoint, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription request error: %s", resp.Status)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription response: %v", err)
+	}
+	return result.Text, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranscribeAPI(t *testing.T) {
+	audio := strings.Repeat("a", 4096)
+	file := filepath.Join(t.TempDir(), "episode.mp3")
+	if err := os.WriteFile(file, []byte(audio), filePerm); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("authorization = %q", got)
+		}
+		if got := r.FormValue("model"); got != "whisper-1" {
+			t.Errorf("model = %q", got)
+		}
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(f)
+		if fh.Filename != "episode.mp3" || string(b) != audio {
+			t.Errorf("file %s of %d bytes", fh.Filename, len(b))
+		}
+		_, _ = w.Write([]byte(`{"text":" hello \n"}`))
+	}))
+	defer srv.Close()
+	// the audio is streamed, the upload buffer limit doesn't apply
+	maxUploadBuffer = 1024
+	defer func() { maxUploadBuffer = 0 }()
+
+	tr := &transcriber{endpoint: srv.URL, token: "token", model: "whisper-1"}
+	text, err := tr.transcribe(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hello" {
+		t.Errorf("text = %q", text)
+	}
+}
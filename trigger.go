@@ -0,0 +1,97 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchTrigger runs the export when the trigger file appears, or any file
+// appears in the trigger directory, so other automation on the host can
+// start exports by touching a file. Triggers are removed before the run,
+// so triggers created during a run start the next one.
+func watchTrigger(ctx context.Context, path string, interval time.Duration, once bool, sync func() ([]taskResult, error)) error {
+	if !once {
+		sdStartWatchdog(ctx)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("failed to notify systemd", "err", err)
+	}
+	log.Printf("watching trigger %s...\n", path)
+	for {
+		triggers, err := consumeTriggers(path)
+		if err != nil {
+			return err
+		}
+		if len(triggers) != 0 {
+			log.Printf("triggered by %s, starting sync...\n", strings.Join(triggers, ", "))
+			if _, err = sync(); err != nil {
+				slog.Error("sync failed", "err", err)
+			}
+		}
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			_ = sdNotify("STOPPING=1")
+			log.Println("stopped watching")
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// consumeTriggers removes and returns the trigger file or the files of the
+// trigger directory. Hidden files in the directory are ignored, so triggers
+// may be written as hidden temp files and renamed.
+func consumeTriggers(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove trigger: %v", err)
+		}
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var triggers []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(path, e.Name())
+		if err = os.Remove(file); err != nil {
+			return nil, fmt.Errorf("failed to remove trigger: %v", err)
+		}
+		triggers = append(triggers, file)
+	}
+	return triggers, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Template variant selection modes.
+const (
+	variantRoundRobin = "round_robin"
+	variantRandom     = "random"
+	variantColumn     = "column"
+
+	defaultVariantColumn = "variant"
+	variantsDir          = "variants"
+)
+
+// templateVariants are alternative templates of a target. The variant used
+// for a row is kept in the variant column (if the sheet has it), so updates
+// are rendered with the same variant.
+type templateVariants struct {
+	names     []string
+	templates map[string]templateExecutor
+	selection string
+	column    string
+	mu        sync.Mutex
+	state     variantState
+}
+
+type variantState struct {
+	file string
+	Next int `json:"next"`
+}
+
+var variantStateSchema = &stateSchema{
+	name: "template variants",
+	migrations: []stateMigration{
+		noMigration, // 1: initial version
+	},
+}
+
+func newTemplateVariants(cfg *targetConfig, dataDir, id string, parse func(file string) (templateExecutor, error)) (*templateVariants, error) {
+	if len(cfg.Variants) == 0 {
+		return nil, nil
+	}
+	v := &templateVariants{
+		templates: make(map[string]templateExecutor, len(cfg.Variants)),
+		selection: cfg.VariantSelection,
+		column:    cfg.VariantColumn,
+		state:     variantState{file: filepath.Join(dataDir, variantsDir, id+".json")},
+	}
+	for name, file := range cfg.Variants {
+		tmpl, err := parse(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse variant %s template: %v", name, err)
+		}
+		v.names = append(v.names, name)
+		v.templates[name] = tmpl
+	}
+	sort.Strings(v.names)
+	if v.column == "" {
+		v.column = defaultVariantColumn
+	}
+	switch v.selection {
+	case "":
+		v.selection = variantRoundRobin
+	case variantRoundRobin, variantRandom, variantColumn:
+	default:
+		return nil, fmt.Errorf("invalid config: invalid variant selection %s", v.selection)
+	}
+	if err := variantStateSchema.read(v.state.file, &v.state); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// choose returns the variant for a new row.
+func (v *templateVariants) choose(row map[string]string) (string, error) {
+	if name := row[v.column]; name != "" {
+		if _, ok := v.templates[name]; !ok {
+			return "", fmt.Errorf("invalid row: unknown template variant %s", name)
+		}
+		return name, nil
+	}
+	switch v.selection {
+	case variantColumn:
+		return "", errors.New("invalid row: template variant not set")
+	case variantRandom:
+		return v.names[rand.Intn(len(v.names))], nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	name := v.names[v.state.Next%len(v.names)]
+	v.state.Next = (v.state.Next + 1) % len(v.names)
+	if err := os.MkdirAll(filepath.Dir(v.state.file), dirPerm); err != nil {
+		return "", err
+	}
+	if err := variantStateSchema.write(v.state.file, v.state.file+".tmp", &v.state); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// template returns the template of the row variant or nil if not set.
+func (v *templateVariants) template(row map[string]string) templateExecutor {
+	return v.templates[row[v.column]]
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 Dmitry Dikun
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const webhookTargetType = "webhook"
+
+const (
+	webhookBodyJSON     = "json"
+	webhookBodyTemplate = "template"
+
+	webhookIdPlaceholder  = "{id}"
+	defaultWebhookIdField = "id"
+)
+
+// webhookTarget posts rows to an HTTP endpoint, either as JSON of the row
+// fields or rendered with the template.
+type webhookTarget struct {
+	name        string
+	url         string
+	updateURL   string
+	deleteURL   string
+	headers     map[string]string
+	contentType string
+	idField     string
+	template    *template.Template
+	templates   *rowTemplates
+	blocks      *contentBlocks
+}
+
+func newWebhookTarget(cfg *targetConfig, blocks *contentBlocks) (target, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("invalid config: webhook url not set")
+	}
+	t := &webhookTarget{
+		name:        cfg.Name,
+		url:         cfg.URL,
+		updateURL:   cfg.UpdateURL,
+		deleteURL:   cfg.DeleteURL,
+		headers:     make(map[string]string, len(cfg.Headers)),
+		contentType: cfg.ContentType,
+		idField:     cfg.IdField,
+		blocks:      blocks,
+	}
+	// header values may refer environment variables to keep secrets out of
+	// the config file
+	for k, v := range cfg.Headers {
+		t.headers[k] = os.ExpandEnv(v)
+	}
+	if t.idField == "" {
+		t.idField = defaultWebhookIdField
+	}
+	switch cfg.Body {
+	case "", webhookBodyJSON:
+		if t.contentType == "" {
+			t.contentType = "application/json"
+		}
+	case webhookBodyTemplate:
+		tmpl, err := template.ParseFiles(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %v", err)
+		}
+		t.template = tmpl
+		if t.templates, err = newRowTemplates(cfg, func(file string) (templateExecutor, error) {
+			return template.ParseFiles(file)
+		}); err != nil {
+			return nil, err
+		}
+		if t.contentType == "" {
+			t.contentType = "text/plain; charset=utf-8"
+		}
+	default:
+		return nil, fmt.Errorf("invalid config: invalid webhook body %s", cfg.Body)
+	}
+	return t, nil
+}
+
+func (wt *webhookTarget) ID() string {
+	return webhookTargetType + "_" + wt.name
+}
+
+func (wt *webhookTarget) Type() string {
+	return webhookTargetType
+}
+
+func (wt *webhookTarget) Name() string {
+	return wt.name
+}
+
+func (wt *webhookTarget) body(row map[string]string) ([]byte, error) {
+	if wt.template == nil {
+		return json.Marshal(row)
+	}
+	var buf bytes.Buffer
+	data := copyRowAny(row)
+	data["blocks"] = wt.blocks.text(row)
+	tmpl, err := wt.templates.template(row)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		tmpl = wt.template
+	}
+	if err := executeTemplate(tmpl, &buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (wt *webhookTarget) Insert(ctx context.Context, row map[string]string, fs *drive.FilesService) (string, error) {
+	b, err := wt.body(row)
+	if err != nil {
+		return "", err
+	}
+	resp, err := wt.do(ctx, http.MethodPost, wt.url, b)
+	if err != nil {
+		return "", err
+	}
+	var result map[string]any
+	if err = json.Unmarshal(resp, &result); err != nil {
+		return "", fmt.Errorf("failed to decode webhook response: %v", err)
+	}
+	id, ok := lookupField(result, wt.idField)
+	if !ok {
+		return "", fmt.Errorf("webhook response has no %s field", wt.idField)
+	}
+	return id, nil
+}
+
+func (wt *webhookTarget) Update(ctx context.Context, id string, row map[string]string, fs *drive.FilesService) error {
+	if wt.updateURL == "" {
+		return errors.New("update not supported: webhook update url not set")
+	}
+	b, err := wt.body(row)
+	if err != nil {
+		return err
+	}
+	_, err = wt.do(ctx, http.MethodPut, strings.ReplaceAll(wt.updateURL, webhookIdPlaceholder, id), b)
+	return err
+}
+
+func (wt *webhookTarget) Delete(ctx context.Context, id string) error {
+	if wt.deleteURL == "" {
+		return errors.New("delete not supported: webhook delete url not set")
+	}
+	_, err := wt.do(ctx, http.MethodDelete, strings.ReplaceAll(wt.deleteURL, webhookIdPlaceholder, id), nil)
+	return err
+}
+
+func (wt *webhookTarget) Finish(ctx context.Context) error {
+	return nil
+}
+
+func (wt *webhookTarget) do(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", wt.contentType)
+	}
+	for k, v := range wt.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, classifyHTTPError(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("webhook request error: %s", resp.Status)
+		if class := httpStatusClass(resp.StatusCode); class != nil {
+			err = fmt.Errorf("%w: %v", class, err)
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// lookupField returns the value of the dot separated field path as string.
+func lookupField(v map[string]any, path string) (string, bool) {
+	var cur any = v
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if cur, ok = m[key]; !ok || cur == nil {
+			return "", false
+		}
+	}
+	switch val := cur.(type) {
+	case string:
+		return val, val != ""
+	case float64:
+		return fmt.Sprintf("%.0f", val), true
+	default:
+		return fmt.Sprint(val), true
+	}
+}